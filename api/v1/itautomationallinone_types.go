@@ -17,6 +17,11 @@ limitations under the License.
 package v1
 
 import (
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
@@ -37,22 +42,710 @@ type ITAutomationAllInOneSpec struct {
 	// +kubebuilder:default=en
 	Language string `json:"language,omitempty"`
 
-	// +kubebuilder:validation:Required
+	// FilePvcName is the name of an existing PersistentVolumeClaim for the file volume.
+	// When omitted, the operator creates the claim according to storage.file.
+	// +optional
 	FilePvcName string `json:"filePvcName,omitempty"`
 
-	// +kubebuilder:validation:Required
+	// DatabasePvcName is the name of an existing PersistentVolumeClaim for the database volume.
+	// When omitted, the operator creates the claim according to storage.database.
+	// +optional
 	DatabasePvcName string `json:"databasePvcName,omitempty"`
+
+	// Storage configures the PersistentVolumeClaims created by the operator
+	// +optional
+	Storage *StorageSpec `json:"storage,omitempty"`
+
+	// CredentialsSecret names an existing Secret in the same namespace holding the password of the
+	// application database user under the key database-password, and optionally the one of the
+	// ITA administrator under admin-password. It adopts the passwords of volumes which are
+	// already initialized. When omitted, the operator creates a Secret: random passwords are
+	// generated for volumes it creates and initializes with an image supporting the Credentials
+	// feature, otherwise the Secret holds the database password the image initializes with.
+	// The passwords are never changed by the operator, and rotating them is not supported: the
+	// images only apply them when they initialize empty volumes, so a password changed in the
+	// database would not match the one the running instance connects with.
+	// +optional
+	CredentialsSecret string `json:"credentialsSecret,omitempty"`
+
+	// Database configures the MariaDB embedded in the ITA image
+	// +optional
+	Database *DatabaseSpec `json:"database,omitempty"`
+
+	// Image overrides where the ITA container image is pulled from.
+	// Fields left empty fall back to the defaults of the operator.
+	// +optional
+	Image *ImageSpec `json:"image,omitempty"`
+
+	// SecurityContext selects the privileges the ITA container runs with
+	// +optional
+	SecurityContext *SecurityContextSpec `json:"securityContext,omitempty"`
+
+	// Service configures how the ITA web console is exposed
+	// +optional
+	Service *ServiceSpec `json:"service,omitempty"`
+
+	// Ingress exposes the ITA web console through an ingress controller
+	// +optional
+	Ingress *IngressSpec `json:"ingress,omitempty"`
+
+	// Route exposes the ITA web console through an OpenShift Route.
+	// It is ignored on clusters which do not serve the route.openshift.io API.
+	// +optional
+	Route *RouteSpec `json:"route,omitempty"`
+
+	// Probes overrides the health checks of the ITA container
+	// +optional
+	Probes *ProbesSpec `json:"probes,omitempty"`
+
+	// PodTemplate configures the compute resources and the placement of the ITA pod
+	// +optional
+	PodTemplate *PodTemplateSpec `json:"podTemplate,omitempty"`
+
+	// Deletion decides what happens to the data when the custom resource is deleted
+	// +optional
+	Deletion *DeletionSpec `json:"deletion,omitempty"`
+
+	// BackupSchedule takes ITAutomationBackups of the instance periodically and prunes old ones
+	// +optional
+	BackupSchedule *BackupScheduleSpec `json:"backupSchedule,omitempty"`
+
+	// Upgrade controls how a change of version is rolled out
+	// +optional
+	Upgrade *UpgradeSpec `json:"upgrade,omitempty"`
+
+	// Paused stops the operator from creating, updating or deleting the resources of the instance,
+	// e.g. for manual maintenance. The status is still reported, and the deletion is not paused.
+	// +optional
+	Paused bool `json:"paused,omitempty"`
+
+	// Suspended scales the frontend to zero replicas while keeping the claims and the Service,
+	// so that an idle instance does not consume compute resources. Scheduled backups are not taken.
+	// +optional
+	Suspended bool `json:"suspended,omitempty"`
+}
+
+// DatabaseSpec defines the database of the instance
+type DatabaseSpec struct {
+	// Service exposes the embedded database to the clients listed in it
+	// +optional
+	Service *DatabaseServiceSpec `json:"service,omitempty"`
+}
+
+// DatabaseServiceSpec defines the Service in front of the embedded database
+type DatabaseServiceSpec struct {
+	// +optional
+	Enabled bool `json:"enabled,omitempty"`
+
+	// Type of the Service
+	// +kubebuilder:validation:Enum=ClusterIP;NodePort;LoadBalancer
+	// +kubebuilder:default=ClusterIP
+	// +optional
+	Type corev1.ServiceType `json:"type,omitempty"`
+
+	// Annotations added to the Service
+	// +optional
+	Annotations map[string]string `json:"annotations,omitempty"`
+
+	// AllowedClients are the peers allowed to connect to the database, in addition to the backup and
+	// restore Jobs of the instance. Clients outside of the cluster reach it through the nodes,
+	// so they usually need an ipBlock covering the addresses the nodes forward them from.
+	// +optional
+	AllowedClients []networkingv1.NetworkPolicyPeer `json:"allowedClients,omitempty"`
+}
+
+// UpgradeSpec controls the upgrade of a running instance to a new version. The new version
+// is rolled out only after a backup of the running version succeeds.
+type UpgradeSpec struct {
+	// Backup is how the pre-upgrade backup is stored. The storage of the backup schedule
+	// is used when omitted.
+	// +optional
+	Backup *BackupStorage `json:"backup,omitempty"`
+
+	// SkipBackup rolls out the new version without taking a backup first
+	// +optional
+	SkipBackup bool `json:"skipBackup,omitempty"`
+
+	// RollbackPolicy decides whether a new version which does not become ready within the
+	// timeout is rolled back. Automatic rollback requires a pre-upgrade backup with the Archive method.
+	// +kubebuilder:default=Never
+	// +optional
+	RollbackPolicy RollbackPolicy `json:"rollbackPolicy,omitempty"`
+
+	// Timeout is how long the new version may take to become ready before it is rolled back.
+	// Defaults to 30 minutes.
+	// +optional
+	Timeout *metav1.Duration `json:"timeout,omitempty"`
+}
+
+// RollbackPolicy decides what happens when an upgrade does not become ready
+// +kubebuilder:validation:Enum=Never;Automatic
+type RollbackPolicy string
+
+const (
+	// RollbackPolicyNever keeps the new version until someone intervenes
+	RollbackPolicyNever RollbackPolicy = "Never"
+	// RollbackPolicyAutomatic deploys the previous version again and restores the pre-upgrade backup onto it
+	RollbackPolicyAutomatic RollbackPolicy = "Automatic"
+)
+
+// DefaultUpgradeTimeout is how long a new version may take to become ready unless spec.upgrade.timeout is given
+const DefaultUpgradeTimeout = 30 * time.Minute
+
+// BackupScheduleSpec defines when scheduled backups are taken and how many of them are kept.
+// Scheduled backups are not owned by the instance, so they survive its deletion.
+type BackupScheduleSpec struct {
+	// Schedule in the standard cron format, e.g. "0 3 * * *", evaluated in UTC
+	// +kubebuilder:validation:MinLength=1
+	// +kubebuilder:validation:Required
+	Schedule string `json:"schedule,omitempty"`
+
+	BackupStorage `json:",inline"`
+
+	// ConcurrencyPolicy decides whether a backup is taken while the previous scheduled backup
+	// is still running. With Forbid the run is skipped and reported in the status.
+	// +kubebuilder:default=Forbid
+	// +optional
+	ConcurrencyPolicy BackupConcurrencyPolicy `json:"concurrencyPolicy,omitempty"`
+
+	// Retention decides which succeeded scheduled backups are kept. A backup is kept if any of
+	// the rules selects it, and the most recent one is always kept. No backup is pruned when omitted.
+	// +optional
+	Retention *BackupRetention `json:"retention,omitempty"`
+}
+
+// BackupConcurrencyPolicy decides how overlapping scheduled backups are handled
+// +kubebuilder:validation:Enum=Forbid;Allow
+type BackupConcurrencyPolicy string
+
+const (
+	// BackupConcurrencyPolicyForbid skips a run while the previous scheduled backup is running
+	BackupConcurrencyPolicyForbid BackupConcurrencyPolicy = "Forbid"
+	// BackupConcurrencyPolicyAllow takes backups regardless of the previous ones
+	BackupConcurrencyPolicyAllow BackupConcurrencyPolicy = "Allow"
+)
+
+// GetConcurrencyPolicy returns the concurrency policy, defaulting to Forbid
+func (s *BackupScheduleSpec) GetConcurrencyPolicy() BackupConcurrencyPolicy {
+	if s.ConcurrencyPolicy == "" {
+		return BackupConcurrencyPolicyForbid
+	}
+	return s.ConcurrencyPolicy
+}
+
+// BackupRetention defines the rules to keep scheduled backups
+type BackupRetention struct {
+	// KeepLast keeps the given number of the most recent backups
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	KeepLast int32 `json:"keepLast,omitempty"`
+
+	// KeepDaily keeps the most recent backup of each of the given number of most recent days
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	KeepDaily int32 `json:"keepDaily,omitempty"`
+
+	// KeepWeekly keeps the most recent backup of each of the given number of most recent ISO weeks
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	KeepWeekly int32 `json:"keepWeekly,omitempty"`
+}
+
+// DeletionPolicy is applied to both volumes before the custom resource is deleted.
+// It applies to the claims given by name as well as to the ones created by the operator.
+// When a volume of the instance is gone, the policy is not applied and the remaining data is retained.
+// +kubebuilder:validation:Enum=Retain;Backup;Wipe
+type DeletionPolicy string
+
+const (
+	// DeletionPolicyRetain leaves the data untouched
+	DeletionPolicyRetain DeletionPolicy = "Retain"
+	// DeletionPolicyBackup stops the instance and archives both volumes before deletion
+	DeletionPolicyBackup DeletionPolicy = "Backup"
+	// DeletionPolicyWipe stops the instance and deletes everything on both volumes
+	DeletionPolicyWipe DeletionPolicy = "Wipe"
+)
+
+// DeletionSpec defines how the custom resource is finalized
+type DeletionSpec struct {
+	// +kubebuilder:default=Retain
+	// +optional
+	Policy DeletionPolicy `json:"policy,omitempty"`
+
+	// BackupPvcName is the claim the final backup is stored in with the Backup policy
+	// +optional
+	BackupPvcName string `json:"backupPvcName,omitempty"`
+}
+
+// PodTemplateSpec defines the compute resources and the scheduling of the ITA pod.
+// Backup, restore and volume Jobs only use the node selector and the tolerations.
+type PodTemplateSpec struct {
+	// Resources of the ITA container
+	// +optional
+	Resources corev1.ResourceRequirements `json:"resources,omitempty"`
+
+	// +optional
+	NodeSelector map[string]string `json:"nodeSelector,omitempty"`
+
+	// +optional
+	Tolerations []corev1.Toleration `json:"tolerations,omitempty"`
+
+	// Affinity of the pod. When omitted, instances prefer not to share a node.
+	// +optional
+	Affinity *corev1.Affinity `json:"affinity,omitempty"`
+
+	// +optional
+	TopologySpreadConstraints []corev1.TopologySpreadConstraint `json:"topologySpreadConstraints,omitempty"`
+
+	// +optional
+	PriorityClassName string `json:"priorityClassName,omitempty"`
+}
+
+// ProbesSpec defines the probes of the ITA container.
+// A probe left empty uses the default of the operator.
+type ProbesSpec struct {
+	// Disabled removes all the probes, e.g. for troubleshooting
+	// +optional
+	Disabled bool `json:"disabled,omitempty"`
+
+	// Startup probe. By default the database port is checked for up to 30 minutes,
+	// which covers the initialization of empty volumes.
+	// +optional
+	Startup *corev1.Probe `json:"startup,omitempty"`
+
+	// Readiness probe. By default the web console is requested over HTTP.
+	// +optional
+	Readiness *corev1.Probe `json:"readiness,omitempty"`
+
+	// Liveness probe. By default the web console is requested over HTTP.
+	// +optional
+	Liveness *corev1.Probe `json:"liveness,omitempty"`
+}
+
+// RouteSpec defines the OpenShift Route in front of the frontend Service
+type RouteSpec struct {
+	// +optional
+	Enabled bool `json:"enabled,omitempty"`
+
+	// Host of the Route. OpenShift generates one when omitted.
+	// +optional
+	Host string `json:"host,omitempty"`
+
+	// TLSTermination enables TLS on the Route. Plain HTTP is used when omitted.
+	// Only edge is supported: the reencrypt and passthrough terminations require the ITA image
+	// to serve TLS itself, while the operator only exposes its plain HTTP port.
+	// +kubebuilder:validation:Enum=edge
+	// +optional
+	TLSTermination string `json:"tlsTermination,omitempty"`
+
+	// InsecureEdgeTerminationPolicy decides what happens to plain HTTP requests on a TLS Route
+	// +kubebuilder:validation:Enum=None;Allow;Redirect
+	// +optional
+	InsecureEdgeTerminationPolicy string `json:"insecureEdgeTerminationPolicy,omitempty"`
+}
+
+// IngressSpec defines the Ingress in front of the frontend Service
+type IngressSpec struct {
+	// Host name the ITA web console is served at
+	Host string `json:"host"`
+
+	// +kubebuilder:default="/"
+	// +optional
+	Path string `json:"path,omitempty"`
+
+	// +optional
+	IngressClassName *string `json:"ingressClassName,omitempty"`
+
+	// Annotations added to the Ingress, e.g. for the ingress controller
+	// +optional
+	Annotations map[string]string `json:"annotations,omitempty"`
+
+	// TLSSecretName enables TLS with the certificate stored in the given Secret
+	// +optional
+	TLSSecretName string `json:"tlsSecretName,omitempty"`
+}
+
+// ServiceSpec defines the Service in front of the ITA web console
+type ServiceSpec struct {
+	// +kubebuilder:validation:Enum=ClusterIP;NodePort;LoadBalancer
+	// +kubebuilder:default=NodePort
+	// +optional
+	Type corev1.ServiceType `json:"type,omitempty"`
+
+	// NodePort fixes the node port of a NodePort or LoadBalancer Service.
+	// A random port is allocated when omitted.
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=65535
+	// +optional
+	NodePort int32 `json:"nodePort,omitempty"`
+
+	// LoadBalancerIP requests a specific address from the load balancer
+	// +optional
+	LoadBalancerIP string `json:"loadBalancerIP,omitempty"`
+
+	// LoadBalancerSourceRanges restricts the clients of the load balancer
+	// +optional
+	LoadBalancerSourceRanges []string `json:"loadBalancerSourceRanges,omitempty"`
+
+	// ExternalTrafficPolicy of a NodePort or LoadBalancer Service
+	// +kubebuilder:validation:Enum=Cluster;Local
+	// +optional
+	ExternalTrafficPolicy corev1.ServiceExternalTrafficPolicyType `json:"externalTrafficPolicy,omitempty"`
+
+	// Annotations added to the Service, e.g. for cloud load balancers or MetalLB
+	// +optional
+	Annotations map[string]string `json:"annotations,omitempty"`
 }
 
+// SecurityProfile is a predefined set of privileges for the ITA container
+// +kubebuilder:validation:Enum=Privileged;Minimal;Restricted;Custom
+type SecurityProfile string
+
+const (
+	// SecurityProfilePrivileged runs the container privileged
+	SecurityProfilePrivileged SecurityProfile = "Privileged"
+	// SecurityProfileMinimal drops all capabilities except the ones allowed by
+	// the "baseline" Pod Security Standard that the services in the image need.
+	// It does not satisfy the "restricted" standard.
+	SecurityProfileMinimal SecurityProfile = "Minimal"
+	// SecurityProfileRestricted satisfies the "restricted" Pod Security Standard: the container
+	// runs as a non-root user without privilege escalation, capabilities other than NET_BIND_SERVICE
+	// and with the RuntimeDefault seccomp profile. The published ITA images run their services
+	// as root and do not start with it, it requires an image rebuilt to run as a non-root user.
+	SecurityProfileRestricted SecurityProfile = "Restricted"
+	// SecurityProfileCustom uses the given security contexts as they are
+	SecurityProfileCustom SecurityProfile = "Custom"
+)
+
+// SecurityContextSpec defines the security contexts of the ITA pod
+type SecurityContextSpec struct {
+	// +kubebuilder:default=Privileged
+	// +optional
+	Profile SecurityProfile `json:"profile,omitempty"`
+
+	// Container is the security context of the ITA container, used with the Custom profile
+	// +optional
+	Container *corev1.SecurityContext `json:"container,omitempty"`
+
+	// Pod is the security context of the ITA pod, used with the Custom profile
+	// +optional
+	Pod *corev1.PodSecurityContext `json:"pod,omitempty"`
+}
+
+// ImageSpec defines the ITA container image
+type ImageSpec struct {
+	// Registry hosting the image, e.g. ghcr.io
+	// +optional
+	Registry string `json:"registry,omitempty"`
+
+	// Repository of the image within the registry, e.g. exastro-suite/it-automation
+	// +optional
+	Repository string `json:"repository,omitempty"`
+
+	// TagTemplate builds the image tag. {version} and {language} are replaced
+	// with spec.version and spec.language, e.g. "{version}-ubi8-{language}".
+	// +optional
+	TagTemplate string `json:"tagTemplate,omitempty"`
+
+	// Digest pins the image and takes precedence over the tag
+	// +kubebuilder:validation:Pattern=`^sha256:[a-f0-9]{64}$`
+	// +optional
+	Digest string `json:"digest,omitempty"`
+
+	// +kubebuilder:validation:Enum=Always;Never;IfNotPresent
+	// +optional
+	PullPolicy corev1.PullPolicy `json:"pullPolicy,omitempty"`
+
+	// +optional
+	ImagePullSecrets []corev1.LocalObjectReference `json:"imagePullSecrets,omitempty"`
+
+	// Features the image supports beyond the published ITA images. They replace the
+	// operator-wide default features when given, even if empty.
+	// +optional
+	Features []ImageFeature `json:"features,omitempty"`
+}
+
+// ImageFeature is an interface of the container image the operator relies on for an optional
+// behaviour. The published ITA images provide none of them, so a behaviour needing one is only
+// accepted for an image declared to support it, e.g. an image rebuilt with its own entrypoint.
+// +kubebuilder:validation:Enum=Credentials;InitializationJob
+type ImageFeature string
+
+const (
+	// ImageFeatureCredentials means the image sets the password of the ITA administrator to
+	// EXASTRO_ADMIN_PASSWORD and the one of the application database user to EXASTRO_DB_PASSWORD
+	// when it initializes empty volumes
+	ImageFeatureCredentials ImageFeature = "Credentials"
+	// ImageFeatureInitializationJob means the entrypoint of the image initializes the volumes flagged
+	// by EXASTRO_AUTO_FILE_VOLUME_INIT and EXASTRO_AUTO_DATABASE_VOLUME_INIT and then runs the command
+	// given as its arguments, so that it exits once they are initialized. The volumes are then
+	// initialized by a Job while the frontend is stopped, instead of by the frontend when it starts.
+	// A failed Job is not retried and the frontend stays stopped until the Job is deleted.
+	// Without it, as with the published images, the frontend initializes the volumes when it starts,
+	// and the flags are only turned on until the volumes are marked initialized.
+	ImageFeatureInitializationJob ImageFeature = "InitializationJob"
+)
+
+// DefaultImageFeatures are the features of the operator-wide default image
+var DefaultImageFeatures []ImageFeature
+
+// HasImageFeature reports whether the image supports the feature
+func HasImageFeature(image *ImageSpec, feature ImageFeature) bool {
+	features := DefaultImageFeatures
+	if image != nil && image.Features != nil {
+		features = image.Features
+	}
+	for _, f := range features {
+		if f == feature {
+			return true
+		}
+	}
+	return false
+}
+
+// StorageSpec defines the PersistentVolumeClaims created by the operator for each volume
+type StorageSpec struct {
+	// +optional
+	File *VolumeClaimSpec `json:"file,omitempty"`
+
+	// +optional
+	Database *VolumeClaimSpec `json:"database,omitempty"`
+}
+
+// RetentionPolicy describes what happens to data when the resource it belongs to is deleted,
+// e.g. to an operator-managed PersistentVolumeClaim when its ITAutomationAllInOne is deleted
+// +kubebuilder:validation:Enum=Retain;Delete
+type RetentionPolicy string
+
+const (
+	// RetentionPolicyRetain keeps the claim and its data
+	RetentionPolicyRetain RetentionPolicy = "Retain"
+	// RetentionPolicyDelete garbage-collects the claim together with the custom resource
+	RetentionPolicyDelete RetentionPolicy = "Delete"
+)
+
+// VolumeClaimSpec defines a PersistentVolumeClaim created by the operator
+type VolumeClaimSpec struct {
+	// StorageClassName of the claim. The default storage class is used when omitted.
+	// +optional
+	StorageClassName *string `json:"storageClassName,omitempty"`
+
+	// Size of the volume. It can be increased later if the storage class allows volume expansion.
+	// +kubebuilder:default="10Gi"
+	// +optional
+	Size resource.Quantity `json:"size,omitempty"`
+
+	// +kubebuilder:default={"ReadWriteOnce"}
+	// +optional
+	AccessModes []corev1.PersistentVolumeAccessMode `json:"accessModes,omitempty"`
+
+	// +kubebuilder:default=Retain
+	// +optional
+	RetentionPolicy RetentionPolicy `json:"retentionPolicy,omitempty"`
+}
+
+// ITAutomationAllInOnePhase is a simple, high-level summary of where the instance is in its lifecycle
+type ITAutomationAllInOnePhase string
+
+const (
+	// PhasePending means the instance is waiting for its storage or its resources to be created
+	PhasePending ITAutomationAllInOnePhase = "Pending"
+	// PhaseDeploying means the frontend Deployment is rolling out
+	PhaseDeploying ITAutomationAllInOnePhase = "Deploying"
+	// PhaseRunning means the instance is available and fully rolled out
+	PhaseRunning ITAutomationAllInOnePhase = "Running"
+	// PhaseDegraded means the instance cannot reach or keep its desired state
+	PhaseDegraded ITAutomationAllInOnePhase = "Degraded"
+	// PhaseTerminating means the deletion policy is being applied
+	PhaseTerminating ITAutomationAllInOnePhase = "Terminating"
+	// PhasePaused means the operator does not touch the resources of the instance
+	PhasePaused ITAutomationAllInOnePhase = "Paused"
+	// PhaseSuspended means the frontend is scaled to zero by spec.suspended
+	PhaseSuspended ITAutomationAllInOnePhase = "Suspended"
+)
+
+// Condition types reported in ITAutomationAllInOneStatus.Conditions
+const (
+	// ConditionTypeAvailable is True when the frontend has the minimum number of available replicas
+	ConditionTypeAvailable = "Available"
+	// ConditionTypeProgressing is True while the frontend Deployment is being created or rolled out
+	ConditionTypeProgressing = "Progressing"
+	// ConditionTypeDegraded is True when reconciliation fails or the rollout is stuck
+	ConditionTypeDegraded = "Degraded"
+	// ConditionTypeStorageReady is True when all the PersistentVolumeClaims are bound
+	ConditionTypeStorageReady = "StorageReady"
+	// ConditionTypeAdmitted is False when an admission controller, e.g. Pod Security Admission,
+	// rejects the resources or the pods of the instance
+	ConditionTypeAdmitted = "Admitted"
+	// ConditionTypeDeletionPolicyApplied reports the progress of the deletion policy
+	// while the custom resource is being deleted
+	ConditionTypeDeletionPolicyApplied = "DeletionPolicyApplied"
+	// ConditionTypeDeletionBlocked is True while the deletion policy cannot be applied, e.g. because
+	// the claim of the final backup is missing or the Job failed, and the custom resource stays
+	ConditionTypeDeletionBlocked = "DeletionBlocked"
+	// ConditionTypePaused is True while spec.paused stops the reconciliation of the resources
+	ConditionTypePaused = "Paused"
+	// ConditionTypeSuspended is True when spec.suspended has scaled the frontend to zero
+	ConditionTypeSuspended = "Suspended"
+	// ConditionTypeFileVolumeInitialized is True once the initialization Job, or the frontend pod
+	// initializing it at startup, has completed. The volume is never initialized again unless the
+	// annotation ita-all-in-one.ita.exastro/reinitialize-file-volume on the custom resource is set
+	// to a new value.
+	ConditionTypeFileVolumeInitialized = "FileVolumeInitialized"
+	// ConditionTypeDatabaseVolumeInitialized is True once the database volume is initialized, the same
+	// way as the file volume. It is never initialized again unless the annotation ita-all-in-one.ita.exastro/reinitialize-database-volume
+	// on the custom resource is set to a new value.
+	ConditionTypeDatabaseVolumeInitialized = "DatabaseVolumeInitialized"
+)
+
 // ITAutomationAllInOneStatus defines the observed state of ITAutomationAllInOne
 type ITAutomationAllInOneStatus struct {
-	// INSERT ADDITIONAL STATUS FIELD - define observed state of cluster
-	// Important: Run "make" to regenerate code after modifying this file
+	// ObservedGeneration is the most recent generation observed by the controller
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+
+	// Phase is a summary of the conditions
+	// +optional
+	Phase ITAutomationAllInOnePhase `json:"phase,omitempty"`
+
+	// Conditions represent the latest available observations of the instance
+	// +optional
+	// +patchMergeKey=type
+	// +patchStrategy=merge
+	// +listType=map
+	// +listMapKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty" patchStrategy:"merge" patchMergeKey:"type"`
+
+	// Image is the container image of the fully rolled out frontend
+	// +optional
+	Image string `json:"image,omitempty"`
+
+	// Version is the ITA version of the fully rolled out frontend
+	// +optional
+	Version string `json:"version,omitempty"`
+
+	// URL is the address the ITA web console can be reached at
+	// +optional
+	URL string `json:"url,omitempty"`
+
+	// DatabaseAddress is the address the embedded database can be reached at inside the cluster
+	// when its Service is enabled
+	// +optional
+	DatabaseAddress string `json:"databaseAddress,omitempty"`
+
+	// LastSuccessfulBackupTime is when the most recent succeeded backup of the instance finished
+	// +optional
+	LastSuccessfulBackupTime *metav1.Time `json:"lastSuccessfulBackupTime,omitempty"`
+
+	// NextBackupTime is when the next scheduled backup is taken
+	// +optional
+	NextBackupTime *metav1.Time `json:"nextBackupTime,omitempty"`
+
+	// LastSkippedBackupTime is the most recent scheduled time no backup was taken at,
+	// because the previous scheduled backup was still running
+	// +optional
+	LastSkippedBackupTime *metav1.Time `json:"lastSkippedBackupTime,omitempty"`
+
+	// Upgrade reports the progress of the most recent change of version
+	// +optional
+	Upgrade *UpgradeStatus `json:"upgrade,omitempty"`
+
+	// UpgradeHistory records the most recent finished upgrades, oldest first
+	// +optional
+	UpgradeHistory []UpgradeStatus `json:"upgradeHistory,omitempty"`
+
+	// Credentials refers to the Secret holding the passwords of the instance
+	// +optional
+	Credentials *CredentialsStatus `json:"credentials,omitempty"`
+}
+
+// CredentialsStatus refers to the passwords of the ITA administrator and of the embedded database
+type CredentialsStatus struct {
+	// SecretName is the Secret in the same namespace holding the passwords
+	// under the keys admin-password and database-password
+	SecretName string `json:"secretName"`
+
+	// Generated tells whether the passwords were generated by the operator. Otherwise the
+	// Secret is given by spec.credentialsSecret, or holds the database password of the image
+	// and no administrator password.
+	// +optional
+	Generated bool `json:"generated,omitempty"`
+}
+
+// UpgradePhase is where an upgrade is in its lifecycle
+type UpgradePhase string
+
+const (
+	// UpgradePhaseRejected means the new version cannot be upgraded to from the running one
+	UpgradePhaseRejected UpgradePhase = "Rejected"
+	// UpgradePhaseBackingUp means the pre-upgrade backup is being taken
+	UpgradePhaseBackingUp UpgradePhase = "BackingUp"
+	// UpgradePhaseRollingOut means the new version is being rolled out
+	UpgradePhaseRollingOut UpgradePhase = "RollingOut"
+	// UpgradePhaseSucceeded means the new version is fully rolled out and ready
+	UpgradePhaseSucceeded UpgradePhase = "Succeeded"
+	// UpgradePhaseFailed means the pre-upgrade backup or the rollback failed
+	UpgradePhaseFailed UpgradePhase = "Failed"
+	// UpgradePhaseRollingBack means the new version did not become ready in time, and the previous
+	// version and its data are being restored
+	UpgradePhaseRollingBack UpgradePhase = "RollingBack"
+	// UpgradePhaseRolledBack means the previous version and its data are restored
+	UpgradePhaseRolledBack UpgradePhase = "RolledBack"
+)
+
+// UpgradeStatus reports the progress of an upgrade
+type UpgradeStatus struct {
+	// FromVersion is the version the instance ran before the upgrade
+	FromVersion string `json:"fromVersion"`
+
+	// ToVersion is the version the instance is upgraded to
+	ToVersion string `json:"toVersion"`
+
+	// Phase is where the upgrade is in its lifecycle
+	Phase UpgradePhase `json:"phase"`
+
+	// Message is a human readable description of the progress
+	// +optional
+	Message string `json:"message,omitempty"`
+
+	// BackupName is the ITAutomationBackup taken before the upgrade
+	// +optional
+	BackupName string `json:"backupName,omitempty"`
+
+	// RestoreName is the ITAutomationRestore which rolls the upgrade back
+	// +optional
+	RestoreName string `json:"restoreName,omitempty"`
+
+	// StartTime is when the upgrade started
+	// +optional
+	StartTime *metav1.Time `json:"startTime,omitempty"`
+
+	// RolloutStartTime is when the new version started rolling out
+	// +optional
+	RolloutStartTime *metav1.Time `json:"rolloutStartTime,omitempty"`
+
+	// CompletionTime is when the upgrade succeeded, failed, was rejected or rolled back
+	// +optional
+	CompletionTime *metav1.Time `json:"completionTime,omitempty"`
+}
+
+// IsFinished reports whether the upgrade will not make any further progress
+func (r *UpgradeStatus) IsFinished() bool {
+	return r.Phase == UpgradePhaseSucceeded || r.Phase == UpgradePhaseFailed || r.Phase == UpgradePhaseRejected ||
+		r.Phase == UpgradePhaseRolledBack
 }
 
 //+kubebuilder:object:root=true
 //+kubebuilder:subresource:status
 //+kubebuilder:printcolumn:name="Version",type=string,JSONPath=`.spec.version`
+//+kubebuilder:printcolumn:name="Running",type=string,JSONPath=`.status.version`
+//+kubebuilder:printcolumn:name="Phase",type=string,JSONPath=`.status.phase`
+//+kubebuilder:printcolumn:name="Available",type=string,JSONPath=`.status.conditions[?(@.type=="Available")].status`
+//+kubebuilder:printcolumn:name="Paused",type=boolean,JSONPath=`.spec.paused`,priority=1
+//+kubebuilder:printcolumn:name="Suspended",type=string,JSONPath=`.status.conditions[?(@.type=="Suspended")].status`,priority=1
+//+kubebuilder:printcolumn:name="Upgrade",type=string,JSONPath=`.status.upgrade.phase`,priority=1
+//+kubebuilder:printcolumn:name="URL",type=string,JSONPath=`.status.url`
+//+kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
 
 // ITAutomationAllInOne is the Schema for the itautomationallinones API
 type ITAutomationAllInOne struct {
@@ -72,6 +765,71 @@ type ITAutomationAllInOneList struct {
 	Items           []ITAutomationAllInOne `json:"items"`
 }
 
+// GetDeletionPolicy returns the deletion policy, defaulting to Retain
+func (r *ITAutomationAllInOne) GetDeletionPolicy() DeletionPolicy {
+	if r.Spec.Deletion == nil || r.Spec.Deletion.Policy == "" {
+		return DeletionPolicyRetain
+	}
+	return r.Spec.Deletion.Policy
+}
+
+// GetUpgradeBackupStorage returns how the pre-upgrade backup is stored,
+// or nil if there is no storage for it
+func (r *ITAutomationAllInOne) GetUpgradeBackupStorage() *BackupStorage {
+	if r.Spec.Upgrade != nil && r.Spec.Upgrade.Backup != nil {
+		return r.Spec.Upgrade.Backup
+	}
+	if r.Spec.BackupSchedule != nil {
+		return &r.Spec.BackupSchedule.BackupStorage
+	}
+	return nil
+}
+
+// GetRollbackPolicy returns the rollback policy of upgrades, defaulting to Never
+func (r *ITAutomationAllInOne) GetRollbackPolicy() RollbackPolicy {
+	if r.Spec.Upgrade == nil || r.Spec.Upgrade.RollbackPolicy == "" {
+		return RollbackPolicyNever
+	}
+	return r.Spec.Upgrade.RollbackPolicy
+}
+
+// GetUpgradeTimeout returns how long a new version may take to become ready
+func (r *ITAutomationAllInOne) GetUpgradeTimeout() time.Duration {
+	if r.Spec.Upgrade == nil || r.Spec.Upgrade.Timeout == nil {
+		return DefaultUpgradeTimeout
+	}
+	return r.Spec.Upgrade.Timeout.Duration
+}
+
+// GetFilePvcName returns the name of the claim used as the file volume
+func (r *ITAutomationAllInOne) GetFilePvcName() string {
+	if r.Spec.FilePvcName != "" {
+		return r.Spec.FilePvcName
+	}
+	return r.Name + "-file-volume"
+}
+
+// GetDatabasePvcName returns the name of the claim used as the database volume
+func (r *ITAutomationAllInOne) GetDatabasePvcName() string {
+	if r.Spec.DatabasePvcName != "" {
+		return r.Spec.DatabasePvcName
+	}
+	return r.Name + "-database-volume"
+}
+
+// GetDatabaseService returns the Service of the embedded database, or nil when it is not enabled
+func (r *ITAutomationAllInOne) GetDatabaseService() *DatabaseServiceSpec {
+	if r.Spec.Database == nil || r.Spec.Database.Service == nil || !r.Spec.Database.Service.Enabled {
+		return nil
+	}
+	return r.Spec.Database.Service
+}
+
+// GetPvcNames returns the names of the claims the instance mounts
+func (r *ITAutomationAllInOne) GetPvcNames() []string {
+	return []string{r.GetFilePvcName(), r.GetDatabasePvcName()}
+}
+
 func init() {
 	SchemeBuilder.Register(&ITAutomationAllInOne{}, &ITAutomationAllInOneList{})
 }
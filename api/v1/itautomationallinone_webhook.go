@@ -0,0 +1,440 @@
+/*
+Copyright 2021 NEC Corporation.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/robfig/cron/v3"
+	corev1 "k8s.io/api/core/v1"
+	apiequality "k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+)
+
+// log is for logging in this package.
+var itautomationallinonelog = logf.Log.WithName("itautomationallinone-resource")
+
+// webhookClient is used to look up other instances and claims in the cluster.
+// Checks which need it are skipped when it is nil.
+var webhookClient client.Reader
+
+// SupportedLanguages are the languages the ITA images are published for
+var SupportedLanguages = []string{"en", "ja"}
+
+// RouteTLSTerminationEdge is the only TLS termination of Routes that works with the plain HTTP port of the ITA image
+const RouteTLSTerminationEdge = "edge"
+
+// SupportedMinorVersions are the ITA release series the operator can deploy
+var SupportedMinorVersions = []string{"1.6", "1.7", "1.8", "1.9", "1.10"}
+
+func (r *ITAutomationAllInOne) SetupWebhookWithManager(mgr ctrl.Manager) error {
+	webhookClient = mgr.GetClient()
+
+	return ctrl.NewWebhookManagedBy(mgr).
+		For(r).
+		Complete()
+}
+
+//+kubebuilder:webhook:path=/validate-ita-all-in-one-ita-exastro-v1-itautomationallinone,mutating=false,failurePolicy=fail,sideEffects=None,groups=ita-all-in-one.ita.exastro,resources=itautomationallinones,verbs=create;update,versions=v1,name=vitautomationallinone.kb.io,admissionReviewVersions={v1,v1beta1}
+
+var _ webhook.Validator = &ITAutomationAllInOne{}
+
+// ValidateCreate implements webhook.Validator so a webhook will be registered for the type
+func (r *ITAutomationAllInOne) ValidateCreate() error {
+	itautomationallinonelog.Info("validate create", "name", r.Name)
+
+	allErrs := r.validateSpec()
+	allErrs = append(allErrs, r.validateClaims(nil)...)
+
+	return r.toInvalidError(allErrs)
+}
+
+// ValidateUpdate implements webhook.Validator so a webhook will be registered for the type
+func (r *ITAutomationAllInOne) ValidateUpdate(old runtime.Object) error {
+	itautomationallinonelog.Info("validate update", "name", r.Name)
+
+	oldResource, ok := old.(*ITAutomationAllInOne)
+	if !ok {
+		return apierrors.NewBadRequest(fmt.Sprintf("expected an ITAutomationAllInOne but got a %T", old))
+	}
+
+	// Metadata-only updates, such as the operator adding or removing its finalizer,
+	// must not be blocked by rules the instance was created before
+	if r.DeletionTimestamp != nil || apiequality.Semantic.DeepEqual(r.Spec, oldResource.Spec) {
+		return nil
+	}
+
+	allErrs := ratchetErrors(r.validateSpec(), oldResource.validateSpec())
+	allErrs = append(allErrs, r.validateClaims(oldResource)...)
+	allErrs = append(allErrs, r.validateStorageUpdate(oldResource)...)
+	allErrs = append(allErrs, r.validateUpgrade(oldResource)...)
+
+	return r.toInvalidError(allErrs)
+}
+
+// ValidateDelete implements webhook.Validator so a webhook will be registered for the type
+func (r *ITAutomationAllInOne) ValidateDelete() error {
+	return nil
+}
+
+func (r *ITAutomationAllInOne) toInvalidError(allErrs field.ErrorList) error {
+	if len(allErrs) == 0 {
+		return nil
+	}
+	return apierrors.NewInvalid(GroupVersion.WithKind("ITAutomationAllInOne").GroupKind(), r.Name, allErrs)
+}
+
+// ratchetErrors drops the errors which the old object already had, so that
+// only fields whose values changed are held to the current rules.
+func ratchetErrors(allErrs field.ErrorList, oldErrs field.ErrorList) field.ErrorList {
+	var newErrs field.ErrorList
+	for _, err := range allErrs {
+		found := false
+		for _, oldErr := range oldErrs {
+			if err.Type == oldErr.Type && err.Field == oldErr.Field && apiequality.Semantic.DeepEqual(err.BadValue, oldErr.BadValue) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			newErrs = append(newErrs, err)
+		}
+	}
+	return newErrs
+}
+
+func (r *ITAutomationAllInOne) validateSpec() field.ErrorList {
+	var allErrs field.ErrorList
+	specPath := field.NewPath("spec")
+
+	if !containsString(SupportedLanguages, r.Spec.Language) {
+		allErrs = append(allErrs, field.NotSupported(specPath.Child("language"), r.Spec.Language, SupportedLanguages))
+	}
+
+	if !isSupportedVersion(r.Spec.Version) {
+		allErrs = append(allErrs, field.Invalid(specPath.Child("version"), r.Spec.Version,
+			fmt.Sprintf("unknown ITA version, supported release series are %s", strings.Join(SupportedMinorVersions, ", "))))
+	}
+
+	if securityContext := r.Spec.SecurityContext; securityContext != nil {
+		securityContextPath := specPath.Child("securityContext")
+		if securityContext.Profile == SecurityProfileCustom && securityContext.Container == nil {
+			allErrs = append(allErrs, field.Required(securityContextPath.Child("container"), "is required with the Custom profile"))
+		}
+		if securityContext.Profile != SecurityProfileCustom && (securityContext.Container != nil || securityContext.Pod != nil) {
+			allErrs = append(allErrs, field.Invalid(securityContextPath.Child("profile"), securityContext.Profile,
+				"container and pod security contexts can only be given with the Custom profile"))
+		}
+	}
+
+	if service := r.Spec.Service; service != nil {
+		servicePath := specPath.Child("service")
+		if service.Type == corev1.ServiceTypeClusterIP {
+			if service.NodePort != 0 {
+				allErrs = append(allErrs, field.Invalid(servicePath.Child("nodePort"), service.NodePort, "cannot be set for a ClusterIP Service"))
+			}
+			if service.ExternalTrafficPolicy != "" {
+				allErrs = append(allErrs, field.Invalid(servicePath.Child("externalTrafficPolicy"), service.ExternalTrafficPolicy, "cannot be set for a ClusterIP Service"))
+			}
+		}
+		if service.Type != corev1.ServiceTypeLoadBalancer {
+			if service.LoadBalancerIP != "" {
+				allErrs = append(allErrs, field.Invalid(servicePath.Child("loadBalancerIP"), service.LoadBalancerIP, "can only be set for a LoadBalancer Service"))
+			}
+			if len(service.LoadBalancerSourceRanges) > 0 {
+				allErrs = append(allErrs, field.Invalid(servicePath.Child("loadBalancerSourceRanges"), service.LoadBalancerSourceRanges, "can only be set for a LoadBalancer Service"))
+			}
+		}
+	}
+
+	if route := r.Spec.Route; route != nil && route.TLSTermination != "" && route.TLSTermination != RouteTLSTerminationEdge {
+		allErrs = append(allErrs, field.NotSupported(specPath.Child("route", "tlsTermination"), route.TLSTermination, []string{RouteTLSTerminationEdge}))
+	}
+
+	if r.GetDeletionPolicy() == DeletionPolicyBackup && r.Spec.Deletion.BackupPvcName == "" {
+		allErrs = append(allErrs, field.Required(specPath.Child("deletion", "backupPvcName"), "is required with the Backup policy"))
+	}
+
+	if backupSchedule := r.Spec.BackupSchedule; backupSchedule != nil {
+		if _, err := cron.ParseStandard(backupSchedule.Schedule); err != nil {
+			allErrs = append(allErrs, field.Invalid(specPath.Child("backupSchedule", "schedule"), backupSchedule.Schedule, err.Error()))
+		}
+		if backupSchedule.GetMethod() == BackupMethodArchive && backupSchedule.Target == nil {
+			allErrs = append(allErrs, field.Required(specPath.Child("backupSchedule", "target"), "is required with the Archive method"))
+		}
+	}
+
+	if upgrade := r.Spec.Upgrade; upgrade != nil {
+		upgradePath := specPath.Child("upgrade")
+		if upgrade.Backup != nil && upgrade.Backup.GetMethod() == BackupMethodArchive && upgrade.Backup.Target == nil {
+			allErrs = append(allErrs, field.Required(upgradePath.Child("backup", "target"), "is required with the Archive method"))
+		}
+		if r.GetRollbackPolicy() == RollbackPolicyAutomatic {
+			// VolumeSnapshot backups are only restored to a new instance, so they cannot roll back the data
+			if upgrade.SkipBackup {
+				allErrs = append(allErrs, field.Invalid(upgradePath.Child("skipBackup"), upgrade.SkipBackup, "cannot be set with the Automatic rollback policy"))
+			} else if storage := r.GetUpgradeBackupStorage(); storage != nil && storage.GetMethod() != BackupMethodArchive {
+				allErrs = append(allErrs, field.Invalid(upgradePath.Child("rollbackPolicy"), upgrade.RollbackPolicy,
+					"requires the pre-upgrade backup to be taken with the Archive method"))
+			}
+		}
+		if upgrade.Timeout != nil && upgrade.Timeout.Duration <= 0 {
+			allErrs = append(allErrs, field.Invalid(upgradePath.Child("timeout"), upgrade.Timeout.Duration.String(), "must be positive"))
+		}
+	}
+
+	if r.GetFilePvcName() == r.GetDatabasePvcName() {
+		allErrs = append(allErrs, field.Invalid(specPath.Child("databasePvcName"), r.Spec.DatabasePvcName,
+			"must not be the same claim as the file volume"))
+	}
+
+	return allErrs
+}
+
+// validateClaims rejects claims which do not exist or are already used by another instance.
+// Only the claims that are new or changed are looked up on update.
+func (r *ITAutomationAllInOne) validateClaims(oldResource *ITAutomationAllInOne) field.ErrorList {
+	var allErrs field.ErrorList
+	specPath := field.NewPath("spec")
+
+	if oldResource != nil && oldResource.isRunning() {
+		if r.GetFilePvcName() != oldResource.GetFilePvcName() {
+			allErrs = append(allErrs, field.Forbidden(specPath.Child("filePvcName"),
+				fmt.Sprintf("cannot switch the file volume from %s to %s while the instance is %s", oldResource.GetFilePvcName(), r.GetFilePvcName(), oldResource.Status.Phase)))
+		}
+		if r.GetDatabasePvcName() != oldResource.GetDatabasePvcName() {
+			allErrs = append(allErrs, field.Forbidden(specPath.Child("databasePvcName"),
+				fmt.Sprintf("cannot switch the database volume from %s to %s while the instance is %s", oldResource.GetDatabasePvcName(), r.GetDatabasePvcName(), oldResource.Status.Phase)))
+		}
+		if len(allErrs) > 0 {
+			return allErrs
+		}
+	}
+
+	if webhookClient == nil {
+		return allErrs
+	}
+
+	ctx := context.Background()
+
+	claims := []struct {
+		path         *field.Path
+		explicitName string
+		name         string
+		oldName      string
+	}{
+		{specPath.Child("filePvcName"), r.Spec.FilePvcName, r.GetFilePvcName(), ""},
+		{specPath.Child("databasePvcName"), r.Spec.DatabasePvcName, r.GetDatabasePvcName(), ""},
+	}
+	if oldResource != nil {
+		claims[0].oldName = oldResource.GetFilePvcName()
+		claims[1].oldName = oldResource.GetDatabasePvcName()
+	}
+
+	instances := &ITAutomationAllInOneList{}
+	err := webhookClient.List(ctx, instances, client.InNamespace(r.Namespace))
+	if err != nil {
+		return append(allErrs, field.InternalError(specPath, err))
+	}
+
+	for _, claim := range claims {
+		if claim.name == claim.oldName {
+			continue
+		}
+
+		for _, instance := range instances.Items {
+			if instance.Name == r.Name {
+				continue
+			}
+			if containsString(instance.GetPvcNames(), claim.name) {
+				allErrs = append(allErrs, field.Invalid(claim.path, claim.name,
+					fmt.Sprintf("PersistentVolumeClaim is already used by ITAutomationAllInOne %s", instance.Name)))
+			}
+		}
+
+		// Claims created by the operator do not have to exist beforehand
+		if claim.explicitName == "" {
+			continue
+		}
+
+		pvc := &corev1.PersistentVolumeClaim{}
+		err := webhookClient.Get(ctx, types.NamespacedName{Namespace: r.Namespace, Name: claim.explicitName}, pvc)
+		if apierrors.IsNotFound(err) {
+			allErrs = append(allErrs, field.NotFound(claim.path, claim.explicitName))
+		} else if err != nil {
+			allErrs = append(allErrs, field.InternalError(claim.path, err))
+		}
+	}
+
+	return allErrs
+}
+
+// validateStorageUpdate rejects changes that cannot be applied to claims the operator has already created
+func (r *ITAutomationAllInOne) validateStorageUpdate(oldResource *ITAutomationAllInOne) field.ErrorList {
+	var allErrs field.ErrorList
+	storagePath := field.NewPath("spec", "storage")
+
+	var newStorage, oldStorage StorageSpec
+	if r.Spec.Storage != nil {
+		newStorage = *r.Spec.Storage
+	}
+	if oldResource.Spec.Storage != nil {
+		oldStorage = *oldResource.Spec.Storage
+	}
+
+	if oldResource.Spec.FilePvcName == "" && r.Spec.FilePvcName == "" {
+		allErrs = append(allErrs, validateVolumeClaimUpdate(storagePath.Child("file"), newStorage.File, oldStorage.File)...)
+	}
+	if oldResource.Spec.DatabasePvcName == "" && r.Spec.DatabasePvcName == "" {
+		allErrs = append(allErrs, validateVolumeClaimUpdate(storagePath.Child("database"), newStorage.Database, oldStorage.Database)...)
+	}
+
+	return allErrs
+}
+
+func validateVolumeClaimUpdate(path *field.Path, newSpec *VolumeClaimSpec, oldSpec *VolumeClaimSpec) field.ErrorList {
+	var allErrs field.ErrorList
+	if newSpec == nil || oldSpec == nil {
+		return allErrs
+	}
+
+	if !apiequality.Semantic.DeepEqual(newSpec.StorageClassName, oldSpec.StorageClassName) {
+		allErrs = append(allErrs, field.Forbidden(path.Child("storageClassName"), "cannot be changed once the claim is created"))
+	}
+	if !apiequality.Semantic.DeepEqual(newSpec.AccessModes, oldSpec.AccessModes) {
+		allErrs = append(allErrs, field.Forbidden(path.Child("accessModes"), "cannot be changed once the claim is created"))
+	}
+	if newSpec.Size.Cmp(oldSpec.Size) < 0 {
+		allErrs = append(allErrs, field.Forbidden(path.Child("size"),
+			fmt.Sprintf("cannot be decreased from %s to %s", oldSpec.Size.String(), newSpec.Size.String())))
+	}
+
+	return allErrs
+}
+
+// validateUpgrade rejects a change of the version the running instance cannot be upgraded with.
+// Nothing is checked before the first version is rolled out, nor when reverting to the running version.
+func (r *ITAutomationAllInOne) validateUpgrade(oldResource *ITAutomationAllInOne) field.ErrorList {
+	var allErrs field.ErrorList
+	running := oldResource.Status.Version
+	if r.Spec.Version == oldResource.Spec.Version || running == "" || r.Spec.Version == running {
+		return allErrs
+	}
+
+	if err := CheckUpgradePath(running, r.Spec.Version); err != nil {
+		allErrs = append(allErrs, field.Invalid(field.NewPath("spec", "version"), r.Spec.Version, err.Error()))
+	}
+
+	skipBackup := r.Spec.Upgrade != nil && r.Spec.Upgrade.SkipBackup
+	if !skipBackup && r.GetUpgradeBackupStorage() == nil {
+		allErrs = append(allErrs, field.Required(field.NewPath("spec", "upgrade", "backup"),
+			"is required to upgrade unless a backup schedule is configured or spec.upgrade.skipBackup is set"))
+	}
+
+	return allErrs
+}
+
+// CheckUpgradePath returns an error if the running version cannot be upgraded to the given one.
+// Downgrades are rejected, and release series cannot be skipped.
+func CheckUpgradePath(from string, to string) error {
+	fromVersion, err := parseVersion(from)
+	if err != nil {
+		return err
+	}
+	toVersion, err := parseVersion(to)
+	if err != nil {
+		return err
+	}
+
+	for i := range fromVersion {
+		if toVersion[i] != fromVersion[i] {
+			if toVersion[i] < fromVersion[i] {
+				return fmt.Errorf("downgrading from %s to %s is not supported", from, to)
+			}
+			break
+		}
+	}
+
+	fromIndex := minorVersionIndex(from)
+	toIndex := minorVersionIndex(to)
+	if fromIndex < 0 {
+		return fmt.Errorf("upgrading from %s is not supported", from)
+	}
+	if toIndex < 0 {
+		return fmt.Errorf("upgrading to %s is not supported", to)
+	}
+	if toIndex > fromIndex+1 {
+		return fmt.Errorf("upgrading from %s to %s skips a release series, upgrade to %s.x first", from, to, SupportedMinorVersions[fromIndex+1])
+	}
+
+	return nil
+}
+
+// parseVersion splits a version of the form "major.minor.patch"
+func parseVersion(version string) ([3]int, error) {
+	var parsed [3]int
+	parts := strings.Split(version, ".")
+	if len(parts) != len(parsed) {
+		return parsed, fmt.Errorf("version %s is not of the form major.minor.patch", version)
+	}
+	for i, part := range parts {
+		number, err := strconv.Atoi(part)
+		if err != nil {
+			return parsed, fmt.Errorf("version %s is not of the form major.minor.patch", version)
+		}
+		parsed[i] = number
+	}
+	return parsed, nil
+}
+
+// minorVersionIndex returns the index of the release series of the version in SupportedMinorVersions, or -1
+func minorVersionIndex(version string) int {
+	for i, minorVersion := range SupportedMinorVersions {
+		if strings.HasPrefix(version, minorVersion+".") {
+			return i
+		}
+	}
+	return -1
+}
+
+// isRunning reports whether the instance has got past the Pending phase
+func (r *ITAutomationAllInOne) isRunning() bool {
+	return r.Status.Phase != "" && r.Status.Phase != PhasePending
+}
+
+func isSupportedVersion(version string) bool {
+	return minorVersionIndex(version) >= 0
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
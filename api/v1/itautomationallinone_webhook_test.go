@@ -0,0 +1,474 @@
+/*
+Copyright 2021 NEC Corporation.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func newTestInstance(version string, language string) *ITAutomationAllInOne {
+	return &ITAutomationAllInOne{
+		ObjectMeta: metav1.ObjectMeta{Name: "ita", Namespace: "default"},
+		Spec: ITAutomationAllInOneSpec{
+			Version:  version,
+			Language: language,
+		},
+	}
+}
+
+func TestValidateUpdate(t *testing.T) {
+	now := metav1.Now()
+
+	tests := []struct {
+		name    string
+		old     *ITAutomationAllInOne
+		update  func(r *ITAutomationAllInOne)
+		wantErr bool
+	}{
+		{
+			name: "finalizer removed from an instance with an unsupported version",
+			old:  newTestInstance("1.5.0", "en"),
+			update: func(r *ITAutomationAllInOne) {
+				r.Finalizers = nil
+			},
+		},
+		{
+			name: "annotation added to an instance with an unsupported language",
+			old:  newTestInstance("1.9.0", "fr"),
+			update: func(r *ITAutomationAllInOne) {
+				r.Annotations = map[string]string{"ita-all-in-one.ita.exastro/quiesced-by": "backup"}
+			},
+		},
+		{
+			name: "instance being deleted",
+			old:  newTestInstance("1.5.0", "fr"),
+			update: func(r *ITAutomationAllInOne) {
+				r.DeletionTimestamp = &now
+				r.Spec.Paused = true
+			},
+		},
+		{
+			name: "unrelated spec change keeps an existing violation",
+			old:  newTestInstance("1.5.0", "en"),
+			update: func(r *ITAutomationAllInOne) {
+				r.Spec.Paused = true
+			},
+		},
+		{
+			name: "changed field is validated",
+			old:  newTestInstance("1.9.0", "en"),
+			update: func(r *ITAutomationAllInOne) {
+				r.Spec.Language = "fr"
+			},
+			wantErr: true,
+		},
+		{
+			name: "changed field is validated although another one is invalid",
+			old:  newTestInstance("1.5.0", "en"),
+			update: func(r *ITAutomationAllInOne) {
+				r.Spec.Language = "fr"
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.old.Finalizers = []string{"ita-all-in-one.ita.exastro/finalizer"}
+			r := tt.old.DeepCopy()
+			tt.update(r)
+
+			err := r.ValidateUpdate(tt.old)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ValidateUpdate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateSpec(t *testing.T) {
+	tests := []struct {
+		name      string
+		update    func(r *ITAutomationAllInOne)
+		wantField string
+	}{
+		{
+			name:   "valid instance",
+			update: func(r *ITAutomationAllInOne) {},
+		},
+		{
+			name:      "unsupported language",
+			update:    func(r *ITAutomationAllInOne) { r.Spec.Language = "fr" },
+			wantField: "spec.language",
+		},
+		{
+			name:      "unknown release series",
+			update:    func(r *ITAutomationAllInOne) { r.Spec.Version = "1.5.0" },
+			wantField: "spec.version",
+		},
+		{
+			name: "Custom profile without a container security context",
+			update: func(r *ITAutomationAllInOne) {
+				r.Spec.SecurityContext = &SecurityContextSpec{Profile: SecurityProfileCustom}
+			},
+			wantField: "spec.securityContext.container",
+		},
+		{
+			name: "security context given with another profile",
+			update: func(r *ITAutomationAllInOne) {
+				r.Spec.SecurityContext = &SecurityContextSpec{Profile: SecurityProfileMinimal, Container: &corev1.SecurityContext{}}
+			},
+			wantField: "spec.securityContext.profile",
+		},
+		{
+			name: "node port of a ClusterIP Service",
+			update: func(r *ITAutomationAllInOne) {
+				r.Spec.Service = &ServiceSpec{Type: corev1.ServiceTypeClusterIP, NodePort: 30080}
+			},
+			wantField: "spec.service.nodePort",
+		},
+		{
+			name: "load balancer IP of a NodePort Service",
+			update: func(r *ITAutomationAllInOne) {
+				r.Spec.Service = &ServiceSpec{Type: corev1.ServiceTypeNodePort, LoadBalancerIP: "192.0.2.1"}
+			},
+			wantField: "spec.service.loadBalancerIP",
+		},
+		{
+			name: "Backup deletion policy without a claim",
+			update: func(r *ITAutomationAllInOne) {
+				r.Spec.Deletion = &DeletionSpec{Policy: DeletionPolicyBackup}
+			},
+			wantField: "spec.deletion.backupPvcName",
+		},
+		{
+			name: "invalid backup schedule",
+			update: func(r *ITAutomationAllInOne) {
+				r.Spec.BackupSchedule = &BackupScheduleSpec{Schedule: "every day",
+					BackupStorage: BackupStorage{Target: &BackupTarget{PvcName: "backup"}}}
+			},
+			wantField: "spec.backupSchedule.schedule",
+		},
+		{
+			name: "scheduled archive without a target",
+			update: func(r *ITAutomationAllInOne) {
+				r.Spec.BackupSchedule = &BackupScheduleSpec{Schedule: "0 3 * * *"}
+			},
+			wantField: "spec.backupSchedule.target",
+		},
+		{
+			name: "database and file volume on the same claim",
+			update: func(r *ITAutomationAllInOne) {
+				r.Spec.FilePvcName = "ita-data"
+				r.Spec.DatabasePvcName = "ita-data"
+			},
+			wantField: "spec.databasePvcName",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newTestInstance("1.9.0", "en")
+			tt.update(r)
+
+			errs := r.validateSpec()
+			if tt.wantField == "" {
+				if len(errs) > 0 {
+					t.Errorf("validateSpec() = %v, want no error", errs)
+				}
+				return
+			}
+			if len(errs) != 1 || errs[0].Field != tt.wantField {
+				t.Errorf("validateSpec() = %v, want a single error on %s", errs, tt.wantField)
+			}
+		})
+	}
+}
+
+func TestValidateUpgrade(t *testing.T) {
+	archive := &BackupStorage{Target: &BackupTarget{PvcName: "backup"}}
+
+	tests := []struct {
+		name      string
+		running   string
+		from      string
+		to        string
+		upgrade   *UpgradeSpec
+		wantField []string
+	}{
+		{
+			name: "first version not rolled out yet",
+			from: "1.8.0",
+			to:   "1.10.0",
+		},
+		{
+			name:    "reverting to the running version",
+			running: "1.8.0",
+			from:    "1.9.0",
+			to:      "1.8.0",
+		},
+		{
+			name:    "next release series with a backup",
+			running: "1.8.0",
+			from:    "1.8.0",
+			to:      "1.9.0",
+			upgrade: &UpgradeSpec{Backup: archive},
+		},
+		{
+			name:    "backup skipped",
+			running: "1.8.0",
+			from:    "1.8.0",
+			to:      "1.8.1",
+			upgrade: &UpgradeSpec{SkipBackup: true},
+		},
+		{
+			name:      "no backup",
+			running:   "1.8.0",
+			from:      "1.8.0",
+			to:        "1.8.1",
+			wantField: []string{"spec.upgrade.backup"},
+		},
+		{
+			name:      "release series skipped",
+			running:   "1.8.0",
+			from:      "1.8.0",
+			to:        "1.10.0",
+			upgrade:   &UpgradeSpec{Backup: archive},
+			wantField: []string{"spec.version"},
+		},
+		{
+			name:      "downgrade without a backup",
+			running:   "1.9.1",
+			from:      "1.9.1",
+			to:        "1.9.0",
+			wantField: []string{"spec.version", "spec.upgrade.backup"},
+		},
+		{
+			name:    "reverting a rolled back upgrade",
+			running: "1.8.0",
+			from:    "1.9.0",
+			to:      "1.8.0",
+			upgrade: &UpgradeSpec{RollbackPolicy: RollbackPolicyAutomatic},
+		},
+		{
+			name:    "retargeting a rolled back upgrade checks the path from the running version",
+			running: "1.8.0",
+			from:    "1.9.0",
+			to:      "1.8.1",
+			upgrade: &UpgradeSpec{Backup: archive, RollbackPolicy: RollbackPolicyAutomatic},
+		},
+		{
+			name:      "retargeting a rolled back upgrade past the next release series",
+			running:   "1.8.0",
+			from:      "1.9.0",
+			to:        "1.10.0",
+			upgrade:   &UpgradeSpec{Backup: archive, RollbackPolicy: RollbackPolicyAutomatic},
+			wantField: []string{"spec.version"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			oldResource := newTestInstance(tt.from, "en")
+			oldResource.Status.Version = tt.running
+			r := newTestInstance(tt.to, "en")
+			r.Spec.Upgrade = tt.upgrade
+
+			errs := r.validateUpgrade(oldResource)
+			if len(errs) != len(tt.wantField) {
+				t.Fatalf("validateUpgrade() = %v, want errors on %v", errs, tt.wantField)
+			}
+			for i, err := range errs {
+				if err.Field != tt.wantField[i] {
+					t.Errorf("validateUpgrade() error %d is on %s, want %s", i, err.Field, tt.wantField[i])
+				}
+			}
+		})
+	}
+}
+
+func TestValidateSpecRollbackPolicy(t *testing.T) {
+	archive := &BackupStorage{Target: &BackupTarget{PvcName: "backup"}}
+	volumeSnapshot := &BackupStorage{Method: BackupMethodVolumeSnapshot}
+
+	tests := []struct {
+		name           string
+		upgrade        *UpgradeSpec
+		backupSchedule *BackupScheduleSpec
+		wantField      string
+	}{
+		{
+			name:    "automatic rollback to an archive",
+			upgrade: &UpgradeSpec{Backup: archive, RollbackPolicy: RollbackPolicyAutomatic},
+		},
+		{
+			name:           "automatic rollback to an archive of the backup schedule",
+			upgrade:        &UpgradeSpec{RollbackPolicy: RollbackPolicyAutomatic},
+			backupSchedule: &BackupScheduleSpec{Schedule: "0 3 * * *", BackupStorage: *archive},
+		},
+		{
+			name:      "automatic rollback without a backup",
+			upgrade:   &UpgradeSpec{SkipBackup: true, RollbackPolicy: RollbackPolicyAutomatic},
+			wantField: "spec.upgrade.skipBackup",
+		},
+		{
+			name:      "automatic rollback to VolumeSnapshots",
+			upgrade:   &UpgradeSpec{Backup: volumeSnapshot, RollbackPolicy: RollbackPolicyAutomatic},
+			wantField: "spec.upgrade.rollbackPolicy",
+		},
+		{
+			name:           "automatic rollback to VolumeSnapshots of the backup schedule",
+			upgrade:        &UpgradeSpec{RollbackPolicy: RollbackPolicyAutomatic},
+			backupSchedule: &BackupScheduleSpec{Schedule: "0 3 * * *", BackupStorage: *volumeSnapshot},
+			wantField:      "spec.upgrade.rollbackPolicy",
+		},
+		{
+			name:    "no rollback without a backup",
+			upgrade: &UpgradeSpec{SkipBackup: true, RollbackPolicy: RollbackPolicyNever},
+		},
+		{
+			name:    "no rollback to VolumeSnapshots",
+			upgrade: &UpgradeSpec{Backup: volumeSnapshot},
+		},
+		{
+			name:      "non-positive timeout",
+			upgrade:   &UpgradeSpec{Backup: archive, RollbackPolicy: RollbackPolicyAutomatic, Timeout: &metav1.Duration{}},
+			wantField: "spec.upgrade.timeout",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newTestInstance("1.9.0", "en")
+			r.Spec.Upgrade = tt.upgrade
+			r.Spec.BackupSchedule = tt.backupSchedule
+
+			errs := r.validateSpec()
+			if tt.wantField == "" {
+				if len(errs) != 0 {
+					t.Errorf("validateSpec() = %v, want no errors", errs)
+				}
+				return
+			}
+			if len(errs) != 1 || errs[0].Field != tt.wantField {
+				t.Errorf("validateSpec() = %v, want an error on %s", errs, tt.wantField)
+			}
+		})
+	}
+}
+
+func TestValidateSpecRouteTermination(t *testing.T) {
+	tests := []struct {
+		termination string
+		wantErr     bool
+	}{
+		{termination: ""},
+		{termination: "edge"},
+		{termination: "reencrypt", wantErr: true},
+		{termination: "passthrough", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.termination, func(t *testing.T) {
+			r := newTestInstance("1.9.0", "en")
+			r.Spec.Route = &RouteSpec{Enabled: true, TLSTermination: tt.termination}
+
+			errs := r.validateSpec()
+			if (len(errs) > 0) != tt.wantErr {
+				t.Errorf("validateSpec() = %v, wantErr %v", errs, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestHasImageFeature(t *testing.T) {
+	tests := []struct {
+		name            string
+		defaultFeatures []ImageFeature
+		image           *ImageSpec
+		want            bool
+	}{
+		{
+			name: "default image",
+		},
+		{
+			name:            "default image with the feature",
+			defaultFeatures: []ImageFeature{ImageFeatureCredentials},
+			want:            true,
+		},
+		{
+			name:  "image with the feature",
+			image: &ImageSpec{Repository: "example/ita", Features: []ImageFeature{ImageFeatureCredentials}},
+			want:  true,
+		},
+		{
+			name:            "image replacing the default features",
+			defaultFeatures: []ImageFeature{ImageFeatureCredentials},
+			image:           &ImageSpec{Repository: "example/ita", Features: []ImageFeature{}},
+		},
+		{
+			name:            "image keeping the default features",
+			defaultFeatures: []ImageFeature{ImageFeatureCredentials},
+			image:           &ImageSpec{Repository: "example/ita"},
+			want:            true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer func(features []ImageFeature) { DefaultImageFeatures = features }(DefaultImageFeatures)
+			DefaultImageFeatures = tt.defaultFeatures
+
+			if got := HasImageFeature(tt.image, ImageFeatureCredentials); got != tt.want {
+				t.Errorf("HasImageFeature() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckUpgradePath(t *testing.T) {
+	tests := []struct {
+		from    string
+		to      string
+		wantErr bool
+	}{
+		{from: "1.8.0", to: "1.8.0"},
+		{from: "1.8.0", to: "1.8.2"},
+		{from: "1.8.2", to: "1.9.0"},
+		{from: "1.9.3", to: "1.10.0"},
+		{from: "1.8.1", to: "1.8.0", wantErr: true},
+		{from: "1.9.0", to: "1.8.5", wantErr: true},
+		{from: "1.10.0", to: "1.9.0", wantErr: true},
+		{from: "1.8.0", to: "1.10.0", wantErr: true},
+		{from: "1.5.0", to: "1.6.0", wantErr: true},
+		{from: "1.10.0", to: "1.11.0", wantErr: true},
+		{from: "1.8", to: "1.9.0", wantErr: true},
+		{from: "1.8.0", to: "1.9.x", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.from+" to "+tt.to, func(t *testing.T) {
+			err := CheckUpgradePath(tt.from, tt.to)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("CheckUpgradePath() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,209 @@
+/*
+Copyright 2021 NEC Corporation.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// ITAutomationBackupSpec defines the desired state of ITAutomationBackup
+type ITAutomationBackupSpec struct {
+	// InstanceName is the name of the ITAutomationAllInOne in the same namespace to back up
+	// +kubebuilder:validation:MinLength=1
+	// +kubebuilder:validation:Required
+	InstanceName string `json:"instanceName,omitempty"`
+
+	BackupStorage `json:",inline"`
+
+	// RetentionPolicy decides whether the archive or the VolumeSnapshots are deleted together with the backup
+	// +kubebuilder:default=Retain
+	// +optional
+	RetentionPolicy RetentionPolicy `json:"retentionPolicy,omitempty"`
+}
+
+// BackupMethod decides how a backup is taken
+// +kubebuilder:validation:Enum=Archive;VolumeSnapshot
+type BackupMethod string
+
+const (
+	// BackupMethodArchive dumps the database of the running instance and archives it
+	// together with the file volume into the target claim
+	BackupMethodArchive BackupMethod = "Archive"
+	// BackupMethodVolumeSnapshot stops the instance briefly and takes CSI VolumeSnapshots of both
+	// of its claims. It requires the snapshot.storage.k8s.io API, and is restored to a new instance.
+	BackupMethodVolumeSnapshot BackupMethod = "VolumeSnapshot"
+)
+
+// BackupStorage defines how and where a backup is stored
+type BackupStorage struct {
+	// Method decides how the backup is taken
+	// +kubebuilder:default=Archive
+	// +optional
+	Method BackupMethod `json:"method,omitempty"`
+
+	// Target is where the archive is stored. It is required with the Archive method.
+	// +optional
+	Target *BackupTarget `json:"target,omitempty"`
+
+	// VolumeSnapshotClassName is the class of the VolumeSnapshots taken with the VolumeSnapshot
+	// method. The default class of the CSI driver is used when omitted.
+	// +optional
+	VolumeSnapshotClassName *string `json:"volumeSnapshotClassName,omitempty"`
+}
+
+// GetMethod returns the backup method, defaulting to Archive
+func (r *BackupStorage) GetMethod() BackupMethod {
+	if r.Method == "" {
+		return BackupMethodArchive
+	}
+	return r.Method
+}
+
+// BackupTarget defines where a backup archive is stored
+type BackupTarget struct {
+	// PvcName is the claim in the same namespace the archive is written to.
+	// It must be mountable on the node the instance runs on.
+	// +kubebuilder:validation:MinLength=1
+	// +kubebuilder:validation:Required
+	PvcName string `json:"pvcName,omitempty"`
+}
+
+// ITAutomationBackupPhase is a simple, high-level summary of where the backup is in its lifecycle
+type ITAutomationBackupPhase string
+
+const (
+	// BackupPhasePending means the backup is waiting for the instance to be running
+	BackupPhasePending ITAutomationBackupPhase = "Pending"
+	// BackupPhaseRunning means the backup Job is running, or the VolumeSnapshots are being taken
+	BackupPhaseRunning ITAutomationBackupPhase = "Running"
+	// BackupPhaseSucceeded means the archive is stored in the target, or the VolumeSnapshots are ready to use
+	BackupPhaseSucceeded ITAutomationBackupPhase = "Succeeded"
+	// BackupPhaseFailed means the backup Job or a VolumeSnapshot failed
+	BackupPhaseFailed ITAutomationBackupPhase = "Failed"
+)
+
+// Condition types reported in ITAutomationBackupStatus.Conditions
+const (
+	// ConditionTypeComplete is True when the archive is stored in the target, or the VolumeSnapshots are ready to use
+	ConditionTypeComplete = "Complete"
+)
+
+// ITAutomationBackupStatus defines the observed state of ITAutomationBackup
+type ITAutomationBackupStatus struct {
+	// ObservedGeneration is the most recent generation observed by the controller
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+
+	// Phase is a summary of the conditions
+	// +optional
+	Phase ITAutomationBackupPhase `json:"phase,omitempty"`
+
+	// Conditions represent the latest available observations of the backup
+	// +optional
+	// +patchMergeKey=type
+	// +patchStrategy=merge
+	// +listType=map
+	// +listMapKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty" patchStrategy:"merge" patchMergeKey:"type"`
+
+	// JobName is the name of the Job taking the backup
+	// +optional
+	JobName string `json:"jobName,omitempty"`
+
+	// StartTime is when the backup Job started
+	// +optional
+	StartTime *metav1.Time `json:"startTime,omitempty"`
+
+	// CompletionTime is when the backup Job finished
+	// +optional
+	CompletionTime *metav1.Time `json:"completionTime,omitempty"`
+
+	// Version is the ITA version of the instance the backup is taken from
+	// +optional
+	Version string `json:"version,omitempty"`
+
+	// Path is the location of the archive in the target claim
+	// +optional
+	Path string `json:"path,omitempty"`
+
+	// Size is the size of the archive, or the total restore size of the VolumeSnapshots, in bytes
+	// +optional
+	Size int64 `json:"size,omitempty"`
+
+	// Checksum is the SHA-256 digest of the archive in the form "sha256:<hex>"
+	// +optional
+	Checksum string `json:"checksum,omitempty"`
+
+	// VolumeSnapshots are the snapshots of the claims of the instance taken with the VolumeSnapshot method
+	// +optional
+	VolumeSnapshots []BackupVolumeSnapshot `json:"volumeSnapshots,omitempty"`
+}
+
+// BackupVolumeSnapshot records a VolumeSnapshot and the claim it is taken from,
+// so that a claim of the same kind can be provisioned from it
+type BackupVolumeSnapshot struct {
+	// Name of the VolumeSnapshot
+	Name string `json:"name"`
+
+	// VolumeName is the volume of the instance the snapshot is taken from, either file-volume or database-volume
+	VolumeName string `json:"volumeName"`
+
+	// StorageClassName of the claim the snapshot is taken from
+	// +optional
+	StorageClassName *string `json:"storageClassName,omitempty"`
+
+	// AccessModes of the claim the snapshot is taken from
+	// +optional
+	AccessModes []corev1.PersistentVolumeAccessMode `json:"accessModes,omitempty"`
+
+	// RestoreSize is the minimum size of a claim provisioned from the snapshot
+	// +optional
+	RestoreSize *resource.Quantity `json:"restoreSize,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+//+kubebuilder:printcolumn:name="Instance",type=string,JSONPath=`.spec.instanceName`
+//+kubebuilder:printcolumn:name="Method",type=string,JSONPath=`.spec.method`
+//+kubebuilder:printcolumn:name="Version",type=string,JSONPath=`.status.version`
+//+kubebuilder:printcolumn:name="Phase",type=string,JSONPath=`.status.phase`
+//+kubebuilder:printcolumn:name="Size",type=integer,JSONPath=`.status.size`
+//+kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
+
+// ITAutomationBackup is the Schema for the itautomationbackups API
+type ITAutomationBackup struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   ITAutomationBackupSpec   `json:"spec,omitempty"`
+	Status ITAutomationBackupStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// ITAutomationBackupList contains a list of ITAutomationBackup
+type ITAutomationBackupList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []ITAutomationBackup `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&ITAutomationBackup{}, &ITAutomationBackupList{})
+}
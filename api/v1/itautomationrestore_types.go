@@ -0,0 +1,129 @@
+/*
+Copyright 2021 NEC Corporation.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// ITAutomationRestoreSpec defines the desired state of ITAutomationRestore
+type ITAutomationRestoreSpec struct {
+	// BackupName is the name of the succeeded ITAutomationBackup in the same namespace to restore
+	// +kubebuilder:validation:MinLength=1
+	// +kubebuilder:validation:Required
+	BackupName string `json:"backupName,omitempty"`
+
+	// InstanceName is the name of the ITAutomationAllInOne in the same namespace the backup is
+	// restored onto. It may differ from the instance the backup was taken from; to restore to
+	// a new instance, create the ITAutomationAllInOne first so that its claims are created.
+	// A backup taken with the VolumeSnapshot method is restored to a new instance instead: the
+	// instance must not exist yet, its claims are provisioned from the snapshots under the default
+	// names, and the restore completes once the ITAutomationAllInOne is created and running.
+	// The instance takes the claims over as if it had created them, so they follow its storage spec
+	// and retention policy. Claims of an instance which is never created are left to be deleted by hand.
+	// The version of the instance must match the version recorded in the backup.
+	// +kubebuilder:validation:MinLength=1
+	// +kubebuilder:validation:Required
+	InstanceName string `json:"instanceName,omitempty"`
+}
+
+// ITAutomationRestorePhase is a simple, high-level summary of where the restore is in its lifecycle
+type ITAutomationRestorePhase string
+
+const (
+	// RestorePhasePending means the restore is waiting for the backup or the instance
+	RestorePhasePending ITAutomationRestorePhase = "Pending"
+	// RestorePhaseRunning means the instance is being restored
+	RestorePhaseRunning ITAutomationRestorePhase = "Running"
+	// RestorePhaseSucceeded means the instance is restored and running
+	RestorePhaseSucceeded ITAutomationRestorePhase = "Succeeded"
+	// RestorePhaseFailed means the restore cannot be completed. The instance is kept
+	// stopped if its volumes may be partially restored, until the restore is deleted.
+	RestorePhaseFailed ITAutomationRestorePhase = "Failed"
+)
+
+// Condition types reported in ITAutomationRestoreStatus.Conditions, one for each step
+const (
+	// ConditionTypeBackupValidated is True when the backup succeeded and its version matches the instance
+	ConditionTypeBackupValidated = "BackupValidated"
+	// ConditionTypeInstanceStopped is True when the frontend of the instance is scaled to zero
+	ConditionTypeInstanceStopped = "InstanceStopped"
+	// ConditionTypeFilesRestored is True when the file volume is replaced with the archived one
+	ConditionTypeFilesRestored = "FilesRestored"
+	// ConditionTypeInstanceStarted is True when the instance is running again
+	ConditionTypeInstanceStarted = "InstanceStarted"
+	// ConditionTypeDatabaseRestored is True when the database dump is imported
+	ConditionTypeDatabaseRestored = "DatabaseRestored"
+	// ConditionTypeClaimsProvisioned is True when the claims of the new instance are provisioned from VolumeSnapshots
+	ConditionTypeClaimsProvisioned = "ClaimsProvisioned"
+)
+
+// ITAutomationRestoreStatus defines the observed state of ITAutomationRestore
+type ITAutomationRestoreStatus struct {
+	// ObservedGeneration is the most recent generation observed by the controller
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+
+	// Phase is a summary of the conditions
+	// +optional
+	Phase ITAutomationRestorePhase `json:"phase,omitempty"`
+
+	// Conditions represent the progress of each step of the restore
+	// +optional
+	// +patchMergeKey=type
+	// +patchStrategy=merge
+	// +listType=map
+	// +listMapKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty" patchStrategy:"merge" patchMergeKey:"type"`
+
+	// StartTime is when the restore started
+	// +optional
+	StartTime *metav1.Time `json:"startTime,omitempty"`
+
+	// CompletionTime is when the restore finished
+	// +optional
+	CompletionTime *metav1.Time `json:"completionTime,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+//+kubebuilder:printcolumn:name="Backup",type=string,JSONPath=`.spec.backupName`
+//+kubebuilder:printcolumn:name="Instance",type=string,JSONPath=`.spec.instanceName`
+//+kubebuilder:printcolumn:name="Phase",type=string,JSONPath=`.status.phase`
+//+kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
+
+// ITAutomationRestore is the Schema for the itautomationrestores API
+type ITAutomationRestore struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   ITAutomationRestoreSpec   `json:"spec,omitempty"`
+	Status ITAutomationRestoreStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// ITAutomationRestoreList contains a list of ITAutomationRestore
+type ITAutomationRestoreList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []ITAutomationRestore `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&ITAutomationRestore{}, &ITAutomationRestoreList{})
+}
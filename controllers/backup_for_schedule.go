@@ -0,0 +1,253 @@
+/*
+Copyright 2021 NEC Corporation.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/robfig/cron/v3"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	itaallinonev1 "github.com/exastro-suite/it-automation-operator/api/v1"
+)
+
+const (
+	scheduledLabel          = "ita-all-in-one.ita.exastro/scheduled"
+	scheduledTimeAnnotation = "ita-all-in-one.ita.exastro/scheduled-time"
+)
+
+// Missed schedules older than this are skipped, e.g. after the operator was down.
+const backupScheduleStartingDeadline = time.Hour
+
+// BackupFactoryForSchedule creates the ITAutomationBackup of a scheduled time.
+// The backup is named after the time, so it is created only once.
+type BackupFactoryForSchedule struct {
+	k8sResourceFactoryBase
+	Reconciler     *ITAutomationAllInOneReconciler
+	CustomResource *itaallinonev1.ITAutomationAllInOne
+	ScheduledTime  time.Time
+}
+
+func newBackupFactoryForSchedule(reconciler *ITAutomationAllInOneReconciler, customResource *itaallinonev1.ITAutomationAllInOne, scheduledTime time.Time) *BackupFactoryForSchedule {
+	return &BackupFactoryForSchedule{
+		k8sResourceFactoryBase: newK8sResourceFactoryBase(customResource, reconciler.Scheme, fmt.Sprintf("%s-%d", customResource.Name, scheduledTime.Unix()/60), &itaallinonev1.ITAutomationBackup{}),
+		Reconciler:             reconciler,
+		CustomResource:         customResource,
+		ScheduledTime:          scheduledTime,
+	}
+}
+
+func (factory *BackupFactoryForSchedule) New() client.Object {
+	labels := mergeStringMaps(createLabels(factory.CustomResource), map[string]string{
+		scheduledLabel: "true",
+	})
+
+	// The backup is not owned by the instance, so that it can be restored after the instance is deleted.
+	return &itaallinonev1.ITAutomationBackup{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: factory.GetNamespace(),
+			Name:      factory.GetName(),
+			Labels:    labels,
+			Annotations: map[string]string{
+				scheduledTimeAnnotation: factory.ScheduledTime.UTC().Format(time.RFC3339),
+			},
+		},
+		Spec: itaallinonev1.ITAutomationBackupSpec{
+			InstanceName:    factory.CustomResource.Name,
+			BackupStorage:   *factory.CustomResource.Spec.BackupSchedule.BackupStorage.DeepCopy(),
+			RetentionPolicy: itaallinonev1.RetentionPolicyDelete,
+		},
+	}
+}
+
+// Merge leaves the backup untouched since it is never updated.
+func (factory *BackupFactoryForSchedule) Merge(k8sResource client.Object, desiredK8sResource client.Object) {
+}
+
+// ensureScheduledBackups takes the most recent missed backup and prunes old ones. It returns the
+// scheduled time it skipped, if any, and a result requeueing the reconciliation at the next scheduled time.
+func (reconciler *ITAutomationAllInOneReconciler) ensureScheduledBackups(ctx context.Context, customResource *itaallinonev1.ITAutomationAllInOne) (*metav1.Time, ctrl.Result, error) {
+	backupSchedule := customResource.Spec.BackupSchedule
+	if backupSchedule == nil {
+		return nil, ctrl.Result{}, nil
+	}
+
+	schedule, err := cron.ParseStandard(backupSchedule.Schedule)
+	if err != nil {
+		reconciler.Log.Error(err, "Failed to parse backup schedule", k8sResourceToLogParameters(customResource)...)
+		reconciler.Recorder.Eventf(customResource, corev1.EventTypeWarning, eventReasonInvalidBackupSchedule,
+			"Backup schedule %q is invalid: %v", backupSchedule.Schedule, err)
+		return nil, ctrl.Result{}, err
+	}
+
+	backups, err := reconciler.listScheduledBackups(ctx, customResource)
+	if err != nil {
+		return nil, ctrl.Result{}, err
+	}
+
+	now := time.Now()
+	earliest := customResource.CreationTimestamp.Time
+	for i := range backups {
+		if scheduledTime := getScheduledTime(&backups[i]); scheduledTime.After(earliest) {
+			earliest = scheduledTime
+		}
+	}
+	// A skipped run is not taken later on
+	if lastSkipped := customResource.Status.LastSkippedBackupTime; lastSkipped != nil && lastSkipped.Time.After(earliest) {
+		earliest = lastSkipped.Time
+	}
+	if deadline := now.Add(-backupScheduleStartingDeadline); earliest.Before(deadline) {
+		earliest = deadline
+	}
+
+	var missed time.Time
+	var skipped *metav1.Time
+	for next := schedule.Next(earliest); !next.After(now); next = schedule.Next(next) {
+		missed = next
+	}
+
+	if running := findRunningBackup(backups); !missed.IsZero() && running != nil && backupSchedule.GetConcurrencyPolicy() == itaallinonev1.BackupConcurrencyPolicyForbid {
+		reconciler.Log.Info("Skipping scheduled backup while the previous one is running", k8sResourceToLogParameters(running)...)
+		reconciler.Recorder.Eventf(customResource, corev1.EventTypeNormal, eventReasonBackupSkipped,
+			"Skipped the backup scheduled at %s since ITAutomationBackup %s is still running", missed.UTC().Format(time.RFC3339), running.Name)
+		skippedTime := metav1.NewTime(missed)
+		skipped = &skippedTime
+	} else if !missed.IsZero() {
+		backupFactory := newBackupFactoryForSchedule(reconciler, customResource, missed)
+		requeue, result, err := reconciler.ensureK8sResourceCreatedWithEvent(ctx, customResource, backupFactory)
+		if requeue {
+			return nil, result, err
+		}
+	}
+
+	if backupSchedule.Retention != nil {
+		for _, backup := range selectBackupsToPrune(backups, backupSchedule.Retention) {
+			reconciler.Log.Info("Pruning scheduled backup", k8sResourceToLogParameters(backup)...)
+
+			err = reconciler.Delete(ctx, backup)
+			if err != nil && !errors.IsNotFound(err) {
+				reconciler.Log.Error(err, "Failed to prune scheduled backup", k8sResourceToLogParameters(backup)...)
+				reconciler.Recorder.Eventf(customResource, corev1.EventTypeWarning, eventReasonFailedPrune,
+					"Failed to prune ITAutomationBackup %s: %v", backup.Name, err)
+				return skipped, ctrl.Result{}, err
+			}
+			reconciler.Recorder.Eventf(customResource, corev1.EventTypeNormal, eventReasonBackupPruned,
+				"Pruned ITAutomationBackup %s by the retention rules", backup.Name)
+		}
+	}
+
+	return skipped, ctrl.Result{RequeueAfter: schedule.Next(now).Sub(now)}, nil
+}
+
+func (reconciler *ITAutomationAllInOneReconciler) listScheduledBackups(ctx context.Context, customResource *itaallinonev1.ITAutomationAllInOne) ([]itaallinonev1.ITAutomationBackup, error) {
+	backupList := &itaallinonev1.ITAutomationBackupList{}
+	labels := mergeStringMaps(createLabels(customResource), map[string]string{
+		scheduledLabel: "true",
+	})
+	err := reconciler.List(ctx, backupList, client.InNamespace(customResource.Namespace), client.MatchingLabels(labels))
+	if err != nil {
+		return nil, err
+	}
+
+	var backups []itaallinonev1.ITAutomationBackup
+	for _, backup := range backupList.Items {
+		if backup.Spec.InstanceName == customResource.Name {
+			backups = append(backups, backup)
+		}
+	}
+
+	return backups, nil
+}
+
+// findRunningBackup returns a backup which has neither succeeded nor failed yet, or nil
+func findRunningBackup(backups []itaallinonev1.ITAutomationBackup) *itaallinonev1.ITAutomationBackup {
+	for i := range backups {
+		phase := backups[i].Status.Phase
+		if backups[i].DeletionTimestamp.IsZero() && phase != itaallinonev1.BackupPhaseSucceeded && phase != itaallinonev1.BackupPhaseFailed {
+			return &backups[i]
+		}
+	}
+	return nil
+}
+
+// selectBackupsToPrune returns the succeeded backups none of the retention rules keeps,
+// and the failed ones older than the most recent succeeded backup.
+// Backups which are still running are never pruned.
+func selectBackupsToPrune(backups []itaallinonev1.ITAutomationBackup, retention *itaallinonev1.BackupRetention) []*itaallinonev1.ITAutomationBackup {
+	sorted := make([]*itaallinonev1.ITAutomationBackup, 0, len(backups))
+	for i := range backups {
+		if backups[i].DeletionTimestamp.IsZero() {
+			sorted = append(sorted, &backups[i])
+		}
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		return getScheduledTime(sorted[i]).After(getScheduledTime(sorted[j]))
+	})
+
+	days := map[string]bool{}
+	weeks := map[string]bool{}
+	succeeded := 0
+	var pruned []*itaallinonev1.ITAutomationBackup
+	for _, backup := range sorted {
+		switch backup.Status.Phase {
+		case itaallinonev1.BackupPhaseSucceeded:
+		case itaallinonev1.BackupPhaseFailed:
+			if succeeded > 0 {
+				pruned = append(pruned, backup)
+			}
+			continue
+		default:
+			continue
+		}
+
+		// The most recent backup is always kept, since it records when the schedule last ran
+		scheduledTime := getScheduledTime(backup).UTC()
+		kept := succeeded == 0 || succeeded < int(retention.KeepLast)
+		if day := scheduledTime.Format("2006-01-02"); !days[day] && len(days) < int(retention.KeepDaily) {
+			days[day] = true
+			kept = true
+		}
+		year, week := scheduledTime.ISOWeek()
+		if key := fmt.Sprintf("%d-%d", year, week); !weeks[key] && len(weeks) < int(retention.KeepWeekly) {
+			weeks[key] = true
+			kept = true
+		}
+		succeeded++
+
+		if !kept {
+			pruned = append(pruned, backup)
+		}
+	}
+
+	return pruned
+}
+
+// getScheduledTime returns the time the backup was scheduled at, or its creation time
+func getScheduledTime(backup *itaallinonev1.ITAutomationBackup) time.Time {
+	scheduledTime, err := time.Parse(time.RFC3339, backup.Annotations[scheduledTimeAnnotation])
+	if err != nil {
+		return backup.CreationTimestamp.Time
+	}
+	return scheduledTime
+}
@@ -0,0 +1,118 @@
+/*
+Copyright 2021 NEC Corporation.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"reflect"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	itaallinonev1 "github.com/exastro-suite/it-automation-operator/api/v1"
+)
+
+func newTestBackup(name string, scheduledTime string, phase itaallinonev1.ITAutomationBackupPhase) itaallinonev1.ITAutomationBackup {
+	return itaallinonev1.ITAutomationBackup{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        name,
+			Annotations: map[string]string{scheduledTimeAnnotation: scheduledTime},
+		},
+		Status: itaallinonev1.ITAutomationBackupStatus{Phase: phase},
+	}
+}
+
+func TestSelectBackupsToPrune(t *testing.T) {
+	now := metav1.Now()
+	deleting := newTestBackup("deleting", "2021-06-07T03:00:00Z", itaallinonev1.BackupPhaseSucceeded)
+	deleting.DeletionTimestamp = &now
+
+	tests := []struct {
+		name       string
+		backups    []itaallinonev1.ITAutomationBackup
+		retention  itaallinonev1.BackupRetention
+		wantPruned []string
+	}{
+		{
+			name: "most recent backup is kept without rules",
+			backups: []itaallinonev1.ITAutomationBackup{
+				newTestBackup("old", "2021-06-09T03:00:00Z", itaallinonev1.BackupPhaseSucceeded),
+				newTestBackup("new", "2021-06-10T03:00:00Z", itaallinonev1.BackupPhaseSucceeded),
+			},
+			wantPruned: []string{"old"},
+		},
+		{
+			name: "keep last",
+			backups: []itaallinonev1.ITAutomationBackup{
+				newTestBackup("d", "2021-06-07T03:00:00Z", itaallinonev1.BackupPhaseSucceeded),
+				newTestBackup("a", "2021-06-10T03:00:00Z", itaallinonev1.BackupPhaseSucceeded),
+				newTestBackup("c", "2021-06-08T03:00:00Z", itaallinonev1.BackupPhaseSucceeded),
+				newTestBackup("b", "2021-06-09T03:00:00Z", itaallinonev1.BackupPhaseSucceeded),
+			},
+			retention:  itaallinonev1.BackupRetention{KeepLast: 2},
+			wantPruned: []string{"c", "d"},
+		},
+		{
+			name: "keep daily",
+			backups: []itaallinonev1.ITAutomationBackup{
+				newTestBackup("a", "2021-06-10T12:00:00Z", itaallinonev1.BackupPhaseSucceeded),
+				newTestBackup("b", "2021-06-10T06:00:00Z", itaallinonev1.BackupPhaseSucceeded),
+				newTestBackup("c", "2021-06-09T12:00:00Z", itaallinonev1.BackupPhaseSucceeded),
+				newTestBackup("d", "2021-06-09T06:00:00Z", itaallinonev1.BackupPhaseSucceeded),
+				newTestBackup("e", "2021-06-08T12:00:00Z", itaallinonev1.BackupPhaseSucceeded),
+			},
+			retention:  itaallinonev1.BackupRetention{KeepDaily: 2},
+			wantPruned: []string{"b", "d", "e"},
+		},
+		{
+			name: "keep weekly",
+			backups: []itaallinonev1.ITAutomationBackup{
+				newTestBackup("a", "2021-06-14T03:00:00Z", itaallinonev1.BackupPhaseSucceeded),
+				newTestBackup("b", "2021-06-09T03:00:00Z", itaallinonev1.BackupPhaseSucceeded),
+				newTestBackup("c", "2021-06-08T03:00:00Z", itaallinonev1.BackupPhaseSucceeded),
+				newTestBackup("d", "2021-06-01T03:00:00Z", itaallinonev1.BackupPhaseSucceeded),
+			},
+			retention:  itaallinonev1.BackupRetention{KeepWeekly: 2},
+			wantPruned: []string{"c", "d"},
+		},
+		{
+			name: "failed, running and deleting backups",
+			backups: []itaallinonev1.ITAutomationBackup{
+				newTestBackup("failed-after", "2021-06-11T03:00:00Z", itaallinonev1.BackupPhaseFailed),
+				newTestBackup("succeeded", "2021-06-10T03:00:00Z", itaallinonev1.BackupPhaseSucceeded),
+				newTestBackup("failed-before", "2021-06-09T03:00:00Z", itaallinonev1.BackupPhaseFailed),
+				newTestBackup("running", "2021-06-08T03:00:00Z", itaallinonev1.BackupPhaseRunning),
+				deleting,
+			},
+			retention:  itaallinonev1.BackupRetention{KeepLast: 5},
+			wantPruned: []string{"failed-before"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			retention := tt.retention
+			var pruned []string
+			for _, backup := range selectBackupsToPrune(tt.backups, &retention) {
+				pruned = append(pruned, backup.Name)
+			}
+
+			if !reflect.DeepEqual(pruned, tt.wantPruned) {
+				t.Errorf("selectBackupsToPrune() = %v, want %v", pruned, tt.wantPruned)
+			}
+		})
+	}
+}
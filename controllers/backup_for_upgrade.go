@@ -0,0 +1,69 @@
+/*
+Copyright 2021 NEC Corporation.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	itaallinonev1 "github.com/exastro-suite/it-automation-operator/api/v1"
+)
+
+// upgradeToLabel marks a pre-upgrade backup with the version the instance is upgraded to
+const upgradeToLabel = "ita-all-in-one.ita.exastro/upgrade-to"
+
+// BackupFactoryForUpgrade creates the ITAutomationBackup taken before the instance is
+// upgraded to spec.version. It is named after the version, so it is taken once per upgrade.
+type BackupFactoryForUpgrade struct {
+	k8sResourceFactoryBase
+	Reconciler     *ITAutomationAllInOneReconciler
+	CustomResource *itaallinonev1.ITAutomationAllInOne
+}
+
+func newBackupFactoryForUpgrade(reconciler *ITAutomationAllInOneReconciler, customResource *itaallinonev1.ITAutomationAllInOne) *BackupFactoryForUpgrade {
+	return &BackupFactoryForUpgrade{
+		k8sResourceFactoryBase: newK8sResourceFactoryBase(customResource, reconciler.Scheme, customResource.Name+"-upgrade-"+strings.ReplaceAll(customResource.Spec.Version, ".", "-"), &itaallinonev1.ITAutomationBackup{}),
+		Reconciler:             reconciler,
+		CustomResource:         customResource,
+	}
+}
+
+func (factory *BackupFactoryForUpgrade) New() client.Object {
+	labels := mergeStringMaps(createLabels(factory.CustomResource), map[string]string{
+		upgradeToLabel: factory.CustomResource.Spec.Version,
+	})
+
+	// The backup is not owned and retained, so that the running version can be restored after a failed upgrade
+	return &itaallinonev1.ITAutomationBackup{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: factory.GetNamespace(),
+			Name:      factory.GetName(),
+			Labels:    labels,
+		},
+		Spec: itaallinonev1.ITAutomationBackupSpec{
+			InstanceName:    factory.CustomResource.Name,
+			BackupStorage:   *factory.CustomResource.GetUpgradeBackupStorage().DeepCopy(),
+			RetentionPolicy: itaallinonev1.RetentionPolicyRetain,
+		},
+	}
+}
+
+// Merge leaves the backup untouched since it is never updated.
+func (factory *BackupFactoryForUpgrade) Merge(k8sResource client.Object, desiredK8sResource client.Object) {
+}
@@ -17,22 +17,239 @@ limitations under the License.
 package controllers
 
 import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"strings"
+
 	itaallinonev1 "github.com/exastro-suite/it-automation-operator/api/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 )
 
+const (
+	desiredStateHashAnnotation = "ita-all-in-one.ita.exastro/desired-state-hash"
+	// managedLabelsAnnotation and managedAnnotationsAnnotation list the keys set by the operator,
+	// so that keys which are no longer desired can be told apart from the ones added by others
+	managedLabelsAnnotation      = "ita-all-in-one.ita.exastro/managed-labels"
+	managedAnnotationsAnnotation = "ita-all-in-one.ita.exastro/managed-annotations"
+	versionLabel                 = "app.kubernetes.io/version"
+	componentLabel               = "app.kubernetes.io/component"
+	// quiescedByAnnotation names the backup or restore which keeps the instance stopped, see quiescedBy
+	quiescedByAnnotation = "ita-all-in-one.ita.exastro/quiesced-by"
+)
+
+const appName = "it-automation-all-in-one"
+
+const (
+	fileVolumeName     = "file-volume"
+	databaseVolumeName = "database-volume"
+)
+
+// Application database created by the ITA installer. Its password is the one of the image, unless
+// the image supports the Credentials feature, see newCredentialsSecretFactory.
+const (
+	databaseName = "ita_db"
+	databaseUser = "ita_db_user"
+	databasePort = 3306
+)
+
 type K8sResourceFactory interface {
 	GetName() string
 	GetNamespace() string
 	GetNamespaceName() types.NamespacedName
 	NewDefault() client.Object
 	New() client.Object
+	// Merge copies the desired state onto the live resource, keeping fields
+	// that are assigned by the API server or set by other controllers.
+	Merge(k8sResource client.Object, desiredK8sResource client.Object)
+}
+
+// K8sResourceDesiredStateAdjuster is implemented by factories whose desired state depends on the live
+// resource. AdjustDesired is called before the resources are compared, so that a live value the factory
+// keeps on Merge does not count as drift.
+type K8sResourceDesiredStateAdjuster interface {
+	AdjustDesired(k8sResource client.Object, desiredK8sResource client.Object)
+}
+
+// k8sResourceFactoryBase implements the part of K8sResourceFactory which is the same for every factory.
+// The resource is named by the factory and lives in the namespace of the custom resource it is created for.
+// Factories embed it and implement New and Merge.
+type k8sResourceFactoryBase struct {
+	owner  client.Object
+	scheme *runtime.Scheme
+	name   string
+	// object is an empty resource of the kind created by the factory
+	object client.Object
+}
+
+func newK8sResourceFactoryBase(owner client.Object, scheme *runtime.Scheme, name string, object client.Object) k8sResourceFactoryBase {
+	return k8sResourceFactoryBase{owner: owner, scheme: scheme, name: name, object: object}
+}
+
+func (base k8sResourceFactoryBase) GetName() string {
+	return base.name
+}
+
+func (base k8sResourceFactoryBase) GetNamespace() string {
+	return base.owner.GetNamespace()
+}
+
+func (base k8sResourceFactoryBase) GetNamespaceName() types.NamespacedName {
+	return types.NamespacedName{
+		Namespace: base.GetNamespace(),
+		Name:      base.GetName(),
+	}
+}
+
+func (base k8sResourceFactoryBase) NewDefault() client.Object {
+	return base.object.DeepCopyObject().(client.Object)
+}
+
+// setOwner sets the custom resource as the owner and controller of the resource
+func (base k8sResourceFactoryBase) setOwner(k8sResource client.Object) {
+	ctrl.SetControllerReference(base.owner, k8sResource, base.scheme)
 }
 
 func createLabels(customResource *itaallinonev1.ITAutomationAllInOne) map[string]string {
 	return map[string]string{
-		"app.kubernetes.io/name":     "it-automation-all-in-one",
+		"app.kubernetes.io/name":     appName,
 		"app.kubernetes.io/instance": customResource.Name,
 	}
 }
+
+// createJobLabels returns the labels of Jobs and their pods. They differ from the ones
+// of the instance, so that the frontend Deployment and Service do not select the pods.
+func createJobLabels(instanceName string, component string) map[string]string {
+	return map[string]string{
+		"app.kubernetes.io/name":     appName + "-job",
+		"app.kubernetes.io/instance": instanceName,
+		componentLabel:               component,
+	}
+}
+
+// setDesiredStateHash records a hash of the desired resource so that changes
+// which cannot be detected by a derivative comparison (e.g. removed list
+// entries or cleared fields) still trigger an update.
+func setDesiredStateHash(k8sResource client.Object) error {
+	data, err := json.Marshal(k8sResource)
+	if err != nil {
+		return err
+	}
+
+	hasher := fnv.New64a()
+	hasher.Write(data)
+
+	annotations := k8sResource.GetAnnotations()
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	annotations[desiredStateHashAnnotation] = fmt.Sprintf("%x", hasher.Sum64())
+	k8sResource.SetAnnotations(annotations)
+
+	return nil
+}
+
+// setManagedMetadataKeys records the label and annotation keys of the desired resource, see mergeObjectMeta.
+func setManagedMetadataKeys(k8sResource client.Object) {
+	annotations := k8sResource.GetAnnotations()
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	annotations[managedLabelsAnnotation] = joinMetadataKeys(k8sResource.GetLabels())
+	annotations[managedAnnotationsAnnotation] = joinMetadataKeys(annotations)
+	k8sResource.SetAnnotations(annotations)
+}
+
+// mergeObjectMeta copies labels, annotations and owner references of the
+// desired resource onto the live resource without dropping the ones added by others.
+// Keys which were set by the operator but are no longer desired are removed.
+func mergeObjectMeta(k8sResource client.Object, desiredK8sResource client.Object) {
+	managedAnnotations := k8sResource.GetAnnotations()
+	labels := removeUndesiredKeys(k8sResource.GetLabels(), managedAnnotations[managedLabelsAnnotation], desiredK8sResource.GetLabels())
+	annotations := removeUndesiredKeys(k8sResource.GetAnnotations(), managedAnnotations[managedAnnotationsAnnotation], desiredK8sResource.GetAnnotations())
+
+	k8sResource.SetLabels(mergeStringMaps(labels, desiredK8sResource.GetLabels()))
+	k8sResource.SetAnnotations(mergeStringMaps(annotations, desiredK8sResource.GetAnnotations()))
+	k8sResource.SetOwnerReferences(mergeOwnerReferences(k8sResource.GetOwnerReferences(), desiredK8sResource.GetOwnerReferences()))
+}
+
+func joinMetadataKeys(values map[string]string) string {
+	keys := []string{}
+	for key := range values {
+		if key == managedLabelsAnnotation || key == managedAnnotationsAnnotation || key == desiredStateHashAnnotation {
+			continue
+		}
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	return strings.Join(keys, ",")
+}
+
+// removeUndesiredKeys returns a copy of values without the managed keys which are not desired anymore.
+func removeUndesiredKeys(values map[string]string, managedKeys string, desired map[string]string) map[string]string {
+	if managedKeys == "" {
+		return values
+	}
+
+	kept := map[string]string{}
+	for key, value := range values {
+		kept[key] = value
+	}
+	for _, key := range strings.Split(managedKeys, ",") {
+		if _, ok := desired[key]; !ok {
+			delete(kept, key)
+		}
+	}
+
+	return kept
+}
+
+func mergeStringMaps(base map[string]string, overrides map[string]string) map[string]string {
+	if len(base) == 0 && len(overrides) == 0 {
+		return nil
+	}
+
+	merged := map[string]string{}
+	for key, value := range base {
+		merged[key] = value
+	}
+	for key, value := range overrides {
+		merged[key] = value
+	}
+
+	return merged
+}
+
+func mergeOwnerReferences(base []metav1.OwnerReference, overrides []metav1.OwnerReference) []metav1.OwnerReference {
+	merged := append([]metav1.OwnerReference{}, base...)
+	for _, override := range overrides {
+		found := false
+		for i := range merged {
+			if merged[i].UID == override.UID {
+				merged[i] = override
+				found = true
+				break
+			}
+		}
+		if !found {
+			merged = append(merged, override)
+		}
+	}
+
+	return merged
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
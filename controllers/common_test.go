@@ -0,0 +1,71 @@
+/*
+Copyright 2021 NEC Corporation.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+
+	itaallinonev1 "github.com/exastro-suite/it-automation-operator/api/v1"
+)
+
+// newTestScheme returns a scheme of the kinds the controllers create, for fake clients and owner references
+func newTestScheme(t *testing.T) *runtime.Scheme {
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	if err := itaallinonev1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	return scheme
+}
+
+// newTestRunningInstance returns an instance named "ita" running version 1.9.0
+func newTestRunningInstance() *itaallinonev1.ITAutomationAllInOne {
+	return &itaallinonev1.ITAutomationAllInOne{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "ita", UID: "instance-uid"},
+		Spec:       itaallinonev1.ITAutomationAllInOneSpec{Version: "1.9.0", Language: "ja"},
+		Status: itaallinonev1.ITAutomationAllInOneStatus{Phase: itaallinonev1.PhaseRunning, Version: "1.9.0",
+			Image: "ghcr.io/exastro-suite/it-automation:1.9.0-ubi8-ja"},
+	}
+}
+
+// newTestFrontendPod returns a pod of the instance, ready if it has an IP address
+func newTestFrontendPod(instance *itaallinonev1.ITAutomationAllInOne, podIP string) *corev1.Pod {
+	k8sPod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: instance.Namespace, Name: instance.Name + "-frontend", Labels: createLabels(instance)}}
+	if podIP != "" {
+		k8sPod.Status.PodIP = podIP
+		k8sPod.Status.Conditions = []corev1.PodCondition{{Type: corev1.PodReady, Status: corev1.ConditionTrue}}
+	}
+	return k8sPod
+}
+
+// recordedEvents returns the events recorded so far, one per line
+func recordedEvents(recorder *record.FakeRecorder) string {
+	var events []string
+	for len(recorder.Events) > 0 {
+		events = append(events, <-recorder.Events)
+	}
+	return strings.Join(events, "\n")
+}
@@ -0,0 +1,73 @@
+/*
+Copyright 2021 NEC Corporation.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"strconv"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	itaallinonev1 "github.com/exastro-suite/it-automation-operator/api/v1"
+)
+
+// ConfigMapFactoryForInitialization creates the ConfigMap the ITA container reads the
+// initialization flags of its volumes from. Unlike environment variables in the pod template,
+// a change of the ConfigMap does not restart the pod, so the flags are turned off once the
+// volumes are initialized without another restart. The flags stay off for images supporting
+// ImageFeatureInitializationJob, whose volumes are initialized by JobFactoryForInitialization.
+type ConfigMapFactoryForInitialization struct {
+	k8sResourceFactoryBase
+	Reconciler     *ITAutomationAllInOneReconciler
+	CustomResource *itaallinonev1.ITAutomationAllInOne
+	// PendingVolumes are the names of the volumes the container initializes when it starts
+	PendingVolumes []string
+}
+
+func newConfigMapFactoryForInitialization(reconciler *ITAutomationAllInOneReconciler, customResource *itaallinonev1.ITAutomationAllInOne) *ConfigMapFactoryForInitialization {
+	return &ConfigMapFactoryForInitialization{
+		k8sResourceFactoryBase: newK8sResourceFactoryBase(customResource, reconciler.Scheme, customResource.Name+"-volume-initialization", &corev1.ConfigMap{}),
+		Reconciler:             reconciler,
+		CustomResource:         customResource,
+	}
+}
+
+func (factory *ConfigMapFactoryForInitialization) New() client.Object {
+	k8sConfigMap := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: factory.GetNamespace(),
+			Name:      factory.GetName(),
+			Labels:    createLabels(factory.CustomResource),
+		},
+		Data: map[string]string{
+			"EXASTRO_AUTO_FILE_VOLUME_INIT":     strconv.FormatBool(containsString(factory.PendingVolumes, fileVolumeName)),
+			"EXASTRO_AUTO_DATABASE_VOLUME_INIT": strconv.FormatBool(containsString(factory.PendingVolumes, databaseVolumeName)),
+		},
+	}
+
+	factory.setOwner(k8sConfigMap)
+
+	return k8sConfigMap
+}
+
+func (factory *ConfigMapFactoryForInitialization) Merge(k8sResource client.Object, desiredK8sResource client.Object) {
+	k8sConfigMap := k8sResource.(*corev1.ConfigMap)
+	desiredK8sConfigMap := desiredK8sResource.(*corev1.ConfigMap)
+
+	k8sConfigMap.Data = desiredK8sConfigMap.Data
+}
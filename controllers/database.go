@@ -0,0 +1,61 @@
+/*
+Copyright 2021 NEC Corporation.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"strconv"
+
+	corev1 "k8s.io/api/core/v1"
+
+	itaallinonev1 "github.com/exastro-suite/it-automation-operator/api/v1"
+)
+
+// createDatabaseClientEnv returns the environment variables the backup and restore Jobs run
+// the MariaDB client with. The database is reached through the given pod of the instance.
+func createDatabaseClientEnv(instance *itaallinonev1.ITAutomationAllInOne, databasePod *corev1.Pod) []corev1.EnvVar {
+	return []corev1.EnvVar{
+		{
+			Name:  "DATABASE_HOST",
+			Value: databasePod.Status.PodIP,
+		},
+		{
+			Name:  "DATABASE_PORT",
+			Value: strconv.Itoa(databasePort),
+		},
+		{
+			Name:  "DATABASE_NAME",
+			Value: databaseName,
+		},
+		{
+			Name:  "DATABASE_USER",
+			Value: databaseUser,
+		},
+		createSecretEnvVar("MYSQL_PWD", credentialsSecretName(instance), databasePasswordKey),
+	}
+}
+
+func createSecretEnvVar(name string, secretName string, key string) corev1.EnvVar {
+	return corev1.EnvVar{
+		Name: name,
+		ValueFrom: &corev1.EnvVarSource{
+			SecretKeyRef: &corev1.SecretKeySelector{
+				LocalObjectReference: corev1.LocalObjectReference{Name: secretName},
+				Key:                  key,
+			},
+		},
+	}
+}
@@ -17,66 +17,79 @@ limitations under the License.
 package controllers
 
 import (
-	"fmt"
-
 	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/apimachinery/pkg/types"
-	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 
 	itaallinonev1 "github.com/exastro-suite/it-automation-operator/api/v1"
 )
 
+const frontendContainerName = "it-automation"
+
 type DeploymentFactoryForFrontend struct {
+	k8sResourceFactoryBase
 	Reconciler     *ITAutomationAllInOneReconciler
 	CustomResource *itaallinonev1.ITAutomationAllInOne
+	// Version to deploy, which lags behind spec.version while an upgrade is held back.
+	// spec.version is deployed when empty.
+	Version string
+	// Stopped scales the frontend to zero while a Job initializes its volumes
+	Stopped bool
 }
 
-func (factory *DeploymentFactoryForFrontend) GetName() string {
-	return factory.CustomResource.Name + "-frontend"
-}
-
-func (factory *DeploymentFactoryForFrontend) GetNamespace() string {
-	return factory.CustomResource.Namespace
-}
-
-func (factory *DeploymentFactoryForFrontend) GetNamespaceName() types.NamespacedName {
-	return types.NamespacedName{
-		Namespace: factory.GetNamespace(),
-		Name:      factory.GetName(),
+func newDeploymentFactoryForFrontend(reconciler *ITAutomationAllInOneReconciler, customResource *itaallinonev1.ITAutomationAllInOne) *DeploymentFactoryForFrontend {
+	return &DeploymentFactoryForFrontend{
+		k8sResourceFactoryBase: newK8sResourceFactoryBase(customResource, reconciler.Scheme, customResource.Name+"-frontend", &appsv1.Deployment{}),
+		Reconciler:             reconciler,
+		CustomResource:         customResource,
 	}
 }
 
-func (factory *DeploymentFactoryForFrontend) NewDefault() client.Object {
-	return &appsv1.Deployment{}
-}
-
 func (factory *DeploymentFactoryForFrontend) New() client.Object {
+	version := factory.Version
+	if version == "" {
+		version = factory.CustomResource.Spec.Version
+	}
+
 	labels := createLabels(factory.CustomResource)
-	replicas := int32(1)
-	privileged := true
+	versionLabels := mergeStringMaps(labels, map[string]string{
+		versionLabel: version,
+	})
+	replicas := desiredReplicas(factory.CustomResource)
+	if factory.Stopped {
+		replicas = 0
+	}
+	securityContext, podSecurityContext := createSecurityContexts(factory.CustomResource)
+	startupProbe, readinessProbe, livenessProbe := createProbes(factory.CustomResource)
+	pullPolicy, pullSecrets := imagePullSettings(factory.CustomResource)
+	initializationConfigMapFactory := newConfigMapFactoryForInitialization(factory.Reconciler, factory.CustomResource)
 
 	k8sDeployment := &appsv1.Deployment{
 		ObjectMeta: metav1.ObjectMeta{
 			Namespace: factory.GetNamespace(),
 			Name:      factory.GetName(),
+			Labels:    versionLabels,
 		},
 		Spec: appsv1.DeploymentSpec{
 			Replicas: &replicas,
 			Selector: &metav1.LabelSelector{
 				MatchLabels: labels,
 			},
+			// The old pod has to release the ReadWriteOnce claims before the new one starts
+			Strategy: appsv1.DeploymentStrategy{
+				Type: appsv1.RecreateDeploymentStrategyType,
+			},
 			Template: corev1.PodTemplateSpec{
 				ObjectMeta: metav1.ObjectMeta{
-					Labels: labels,
+					Labels: versionLabels,
 				},
 				Spec: corev1.PodSpec{
 					Containers: []corev1.Container{
 						{
-							Name:  "it-automation",
-							Image: fmt.Sprintf("ghcr.io/exastro-suite/it-automation:%s-ubi8-%s", factory.CustomResource.Spec.Version, factory.CustomResource.Spec.Language),
+							Name:            frontendContainerName,
+							Image:           resolveImage(factory.CustomResource, version, factory.Reconciler.ImageDefaults),
+							ImagePullPolicy: pullPolicy,
 							Ports: []corev1.ContainerPort{
 								{
 									Name:          "http",
@@ -84,49 +97,51 @@ func (factory *DeploymentFactoryForFrontend) New() client.Object {
 								},
 								{
 									Name:          "mysql",
-									ContainerPort: 3306,
+									ContainerPort: databasePort,
 								},
 							},
-							Env: []corev1.EnvVar{
-								{
-									Name:  "EXASTRO_AUTO_FILE_VOLUME_INIT",
-									Value: "true",
-								},
+							// The initialization flags of the volumes
+							EnvFrom: []corev1.EnvFromSource{
 								{
-									Name:  "EXASTRO_AUTO_DATABASE_VOLUME_INIT",
-									Value: "true",
+									ConfigMapRef: &corev1.ConfigMapEnvSource{
+										LocalObjectReference: corev1.LocalObjectReference{Name: initializationConfigMapFactory.GetName()},
+									},
 								},
 							},
-							SecurityContext: &corev1.SecurityContext{
-								Privileged: &privileged,
-							},
+							Env:             createCredentialsContainerEnv(factory.CustomResource),
+							SecurityContext: securityContext,
+							StartupProbe:    startupProbe,
+							ReadinessProbe:  readinessProbe,
+							LivenessProbe:   livenessProbe,
 							VolumeMounts: []corev1.VolumeMount{
 								{
-									Name:      "file-volume",
+									Name:      fileVolumeName,
 									MountPath: "/exastro-file-volume",
 								},
 								{
-									Name:      "database-volume",
+									Name:      databaseVolumeName,
 									MountPath: "/exastro-database-volume",
 								},
 							},
 						},
 					},
-					RestartPolicy: "Always",
+					RestartPolicy:    "Always",
+					ImagePullSecrets: pullSecrets,
+					SecurityContext:  podSecurityContext,
 					Volumes: []corev1.Volume{
 						{
-							Name: "file-volume",
+							Name: fileVolumeName,
 							VolumeSource: corev1.VolumeSource{
 								PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{
-									ClaimName: factory.CustomResource.Spec.FilePvcName,
+									ClaimName: factory.CustomResource.GetFilePvcName(),
 								},
 							},
 						},
 						{
-							Name: "database-volume",
+							Name: databaseVolumeName,
 							VolumeSource: corev1.VolumeSource{
 								PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{
-									ClaimName: factory.CustomResource.Spec.DatabasePvcName,
+									ClaimName: factory.CustomResource.GetDatabasePvcName(),
 								},
 							},
 						},
@@ -136,8 +151,43 @@ func (factory *DeploymentFactoryForFrontend) New() client.Object {
 		},
 	}
 
-	// Set resource as the owner and controller
-	ctrl.SetControllerReference(factory.CustomResource, k8sDeployment, factory.Reconciler.Scheme)
+	// The pods are replaced when a volume is reinitialized, either by the container when it starts or by a Job
+	templateAnnotations := map[string]string{}
+	for _, volumeName := range instanceVolumeNames(factory.CustomResource) {
+		if request := factory.CustomResource.Annotations[reinitializeAnnotationPrefix+volumeName]; request != "" {
+			templateAnnotations[reinitializeAnnotationPrefix+volumeName] = request
+		}
+	}
+	if len(templateAnnotations) > 0 {
+		k8sDeployment.Spec.Template.Annotations = templateAnnotations
+	}
+
+	applyPodTemplate(factory.CustomResource, &k8sDeployment.Spec.Template.Spec)
+
+	factory.setOwner(k8sDeployment)
 
 	return k8sDeployment
 }
+
+// desiredReplicas stops the instance while the deletion policy is applied, while a backup
+// or a restore holds its volumes, or while it is suspended
+func desiredReplicas(customResource *itaallinonev1.ITAutomationAllInOne) int32 {
+	if !customResource.DeletionTimestamp.IsZero() || customResource.Annotations[quiescedByAnnotation] != "" || customResource.Spec.Suspended {
+		return 0
+	}
+	return 1
+}
+
+func (factory *DeploymentFactoryForFrontend) Merge(k8sResource client.Object, desiredK8sResource client.Object) {
+	k8sDeployment := k8sResource.(*appsv1.Deployment)
+	desiredK8sDeployment := desiredK8sResource.(*appsv1.Deployment)
+
+	// Keep pod template annotations such as the one set by "kubectl rollout restart"
+	templateAnnotations := mergeStringMaps(k8sDeployment.Spec.Template.Annotations, desiredK8sDeployment.Spec.Template.Annotations)
+
+	// The selector is immutable, so it is left untouched.
+	k8sDeployment.Spec.Replicas = desiredK8sDeployment.Spec.Replicas
+	k8sDeployment.Spec.Strategy = desiredK8sDeployment.Spec.Strategy
+	k8sDeployment.Spec.Template = desiredK8sDeployment.Spec.Template
+	k8sDeployment.Spec.Template.Annotations = templateAnnotations
+}
@@ -0,0 +1,72 @@
+/*
+Copyright 2021 NEC Corporation.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestDesiredReplicas(t *testing.T) {
+	deletionTimestamp := metav1.Now()
+
+	tests := []struct {
+		name              string
+		annotations       map[string]string
+		deletionTimestamp *metav1.Time
+		suspended         bool
+		want              int32
+	}{
+		{
+			name: "running",
+			want: 1,
+		},
+		{
+			name:        "stopped by a restore",
+			annotations: map[string]string{quiescedByAnnotation: quiescedBy(restoreKind, "restore")},
+			want:        0,
+		},
+		{
+			name:        "empty annotation does not stop it",
+			annotations: map[string]string{quiescedByAnnotation: ""},
+			want:        1,
+		},
+		{
+			name:              "deleting",
+			deletionTimestamp: &deletionTimestamp,
+			want:              0,
+		},
+		{
+			name:      "suspended",
+			suspended: true,
+			want:      0,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			instance := newTestRunningInstance()
+			instance.Annotations = tt.annotations
+			instance.DeletionTimestamp = tt.deletionTimestamp
+			instance.Spec.Suspended = tt.suspended
+			if got := desiredReplicas(instance); got != tt.want {
+				t.Errorf("desiredReplicas() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,93 @@
+/*
+Copyright 2021 NEC Corporation.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+
+	itaallinonev1 "github.com/exastro-suite/it-automation-operator/api/v1"
+)
+
+const (
+	DefaultImageRegistry    = "ghcr.io"
+	DefaultImageRepository  = "exastro-suite/it-automation"
+	DefaultImageTagTemplate = "{version}-ubi8-{language}"
+)
+
+// ImageDefaults are the operator-wide defaults of the ITA container image.
+// Empty fields fall back to the Default* constants.
+type ImageDefaults struct {
+	Registry    string
+	Repository  string
+	TagTemplate string
+}
+
+func resolveImage(customResource *itaallinonev1.ITAutomationAllInOne, version string, defaults ImageDefaults) string {
+	return resolveImageSpec(customResource.Spec.Image, version, customResource.Spec.Language, defaults)
+}
+
+func resolveImageSpec(image *itaallinonev1.ImageSpec, version string, language string, defaults ImageDefaults) string {
+	registry := firstNonEmpty(defaults.Registry, DefaultImageRegistry)
+	repository := firstNonEmpty(defaults.Repository, DefaultImageRepository)
+	tagTemplate := firstNonEmpty(defaults.TagTemplate, DefaultImageTagTemplate)
+	digest := ""
+
+	if image != nil {
+		registry = firstNonEmpty(image.Registry, registry)
+		repository = firstNonEmpty(image.Repository, repository)
+		tagTemplate = firstNonEmpty(image.TagTemplate, tagTemplate)
+		digest = image.Digest
+	}
+
+	name := repository
+	if registry != "" {
+		name = strings.TrimSuffix(registry, "/") + "/" + repository
+	}
+
+	if digest != "" {
+		return name + "@" + digest
+	}
+
+	tag := strings.NewReplacer(
+		"{version}", version,
+		"{language}", language,
+	).Replace(tagTemplate)
+
+	return name + ":" + tag
+}
+
+func imagePullSettings(customResource *itaallinonev1.ITAutomationAllInOne) (corev1.PullPolicy, []corev1.LocalObjectReference) {
+	return imageSpecPullSettings(customResource.Spec.Image)
+}
+
+func imageSpecPullSettings(image *itaallinonev1.ImageSpec) (corev1.PullPolicy, []corev1.LocalObjectReference) {
+	if image == nil {
+		return "", nil
+	}
+	return image.PullPolicy, image.ImagePullSecrets
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, value := range values {
+		if value != "" {
+			return value
+		}
+	}
+	return ""
+}
@@ -0,0 +1,61 @@
+/*
+Copyright 2021 NEC Corporation.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"testing"
+
+	itaallinonev1 "github.com/exastro-suite/it-automation-operator/api/v1"
+)
+
+func TestResolveImageSpec(t *testing.T) {
+	tests := []struct {
+		name     string
+		image    *itaallinonev1.ImageSpec
+		defaults ImageDefaults
+		want     string
+	}{
+		{
+			name: "built-in defaults",
+			want: "ghcr.io/exastro-suite/it-automation:1.9.0-ubi8-ja",
+		},
+		{
+			name:     "operator-wide defaults",
+			defaults: ImageDefaults{Registry: "registry.example.com/", Repository: "mirror/ita", TagTemplate: "{language}-{version}"},
+			want:     "registry.example.com/mirror/ita:ja-1.9.0",
+		},
+		{
+			name:     "instance overrides the operator-wide defaults field by field",
+			image:    &itaallinonev1.ImageSpec{Repository: "team/ita"},
+			defaults: ImageDefaults{Registry: "registry.example.com", TagTemplate: "{version}"},
+			want:     "registry.example.com/team/ita:1.9.0",
+		},
+		{
+			name:  "digest replaces the tag",
+			image: &itaallinonev1.ImageSpec{Digest: "sha256:0123456789abcdef"},
+			want:  "ghcr.io/exastro-suite/it-automation@sha256:0123456789abcdef",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := resolveImageSpec(tt.image, "1.9.0", "ja", tt.defaults); got != tt.want {
+				t.Errorf("resolveImageSpec() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
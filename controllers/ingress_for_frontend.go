@@ -0,0 +1,113 @@
+/*
+Copyright 2021 NEC Corporation.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	itaallinonev1 "github.com/exastro-suite/it-automation-operator/api/v1"
+)
+
+type IngressFactoryForFrontend struct {
+	k8sResourceFactoryBase
+	Reconciler     *ITAutomationAllInOneReconciler
+	CustomResource *itaallinonev1.ITAutomationAllInOne
+}
+
+func newIngressFactoryForFrontend(reconciler *ITAutomationAllInOneReconciler, customResource *itaallinonev1.ITAutomationAllInOne) *IngressFactoryForFrontend {
+	return &IngressFactoryForFrontend{
+		k8sResourceFactoryBase: newK8sResourceFactoryBase(customResource, reconciler.Scheme, customResource.Name+"-frontend", &networkingv1.Ingress{}),
+		Reconciler:             reconciler,
+		CustomResource:         customResource,
+	}
+}
+
+func (factory *IngressFactoryForFrontend) New() client.Object {
+	labels := createLabels(factory.CustomResource)
+	ingress := factory.CustomResource.Spec.Ingress
+	serviceFactory := newServiceFactoryForFrontend(factory.Reconciler, factory.CustomResource)
+
+	k8sIngress := &networkingv1.Ingress{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:   factory.GetNamespace(),
+			Name:        factory.GetName(),
+			Labels:      labels,
+			Annotations: ingress.Annotations,
+		},
+		Spec: createIngressSpec(ingress, serviceFactory.GetName()),
+	}
+
+	factory.setOwner(k8sIngress)
+
+	return k8sIngress
+}
+
+func (factory *IngressFactoryForFrontend) Merge(k8sResource client.Object, desiredK8sResource client.Object) {
+	k8sIngress := k8sResource.(*networkingv1.Ingress)
+	desiredK8sIngress := desiredK8sResource.(*networkingv1.Ingress)
+
+	k8sIngress.Spec = desiredK8sIngress.Spec
+}
+
+// createIngressSpec routes the host and the path of the spec to the http port of the Service
+func createIngressSpec(ingress *itaallinonev1.IngressSpec, serviceName string) networkingv1.IngressSpec {
+	path := ingress.Path
+	if path == "" {
+		path = "/"
+	}
+	pathType := networkingv1.PathTypePrefix
+
+	ingressSpec := networkingv1.IngressSpec{
+		IngressClassName: ingress.IngressClassName,
+		Rules: []networkingv1.IngressRule{
+			{
+				Host: ingress.Host,
+				IngressRuleValue: networkingv1.IngressRuleValue{
+					HTTP: &networkingv1.HTTPIngressRuleValue{
+						Paths: []networkingv1.HTTPIngressPath{
+							{
+								Path:     path,
+								PathType: &pathType,
+								Backend: networkingv1.IngressBackend{
+									Service: &networkingv1.IngressServiceBackend{
+										Name: serviceName,
+										Port: networkingv1.ServiceBackendPort{
+											Name: "http",
+										},
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+
+	if ingress.TLSSecretName != "" {
+		ingressSpec.TLS = []networkingv1.IngressTLS{
+			{
+				Hosts:      []string{ingress.Host},
+				SecretName: ingress.TLSSecretName,
+			},
+		}
+	}
+
+	return ingressSpec
+}
@@ -0,0 +1,81 @@
+/*
+Copyright 2021 NEC Corporation.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"testing"
+
+	networkingv1 "k8s.io/api/networking/v1"
+	apiequality "k8s.io/apimachinery/pkg/api/equality"
+
+	itaallinonev1 "github.com/exastro-suite/it-automation-operator/api/v1"
+)
+
+func TestCreateIngressSpec(t *testing.T) {
+	className := "nginx"
+
+	tests := []struct {
+		name      string
+		ingress   *itaallinonev1.IngressSpec
+		wantPath  string
+		wantClass *string
+		wantTLS   []networkingv1.IngressTLS
+	}{
+		{
+			name:     "root path without TLS",
+			ingress:  &itaallinonev1.IngressSpec{Host: "ita.example.com"},
+			wantPath: "/",
+		},
+		{
+			name:      "path and ingress class",
+			ingress:   &itaallinonev1.IngressSpec{Host: "ita.example.com", Path: "/ita", IngressClassName: &className},
+			wantPath:  "/ita",
+			wantClass: &className,
+		},
+		{
+			name:     "TLS for the host",
+			ingress:  &itaallinonev1.IngressSpec{Host: "ita.example.com", TLSSecretName: "ita-tls"},
+			wantPath: "/",
+			wantTLS:  []networkingv1.IngressTLS{{Hosts: []string{"ita.example.com"}, SecretName: "ita-tls"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ingressSpec := createIngressSpec(tt.ingress, "ita-frontend")
+
+			if !apiequality.Semantic.DeepEqual(ingressSpec.IngressClassName, tt.wantClass) {
+				t.Errorf("ingress class = %v, want %v", ingressSpec.IngressClassName, tt.wantClass)
+			}
+			if !apiequality.Semantic.DeepEqual(ingressSpec.TLS, tt.wantTLS) {
+				t.Errorf("TLS = %v, want %v", ingressSpec.TLS, tt.wantTLS)
+			}
+			if len(ingressSpec.Rules) != 1 || ingressSpec.Rules[0].Host != tt.ingress.Host {
+				t.Fatalf("rules = %v, want a single rule for %s", ingressSpec.Rules, tt.ingress.Host)
+			}
+
+			paths := ingressSpec.Rules[0].HTTP.Paths
+			if len(paths) != 1 || paths[0].Path != tt.wantPath || *paths[0].PathType != networkingv1.PathTypePrefix {
+				t.Fatalf("paths = %v, want a single prefix %s", paths, tt.wantPath)
+			}
+			backend := paths[0].Backend.Service
+			if backend == nil || backend.Name != "ita-frontend" || backend.Port.Name != "http" {
+				t.Errorf("backend = %v, want the http port of ita-frontend", backend)
+			}
+		})
+	}
+}
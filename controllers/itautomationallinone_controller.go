@@ -21,11 +21,20 @@ import (
 
 	"github.com/go-logr/logr"
 	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
 	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
 	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
 
 	itaallinonev1 "github.com/exastro-suite/it-automation-operator/api/v1"
 )
@@ -33,8 +42,13 @@ import (
 // ITAutomationAllInOneReconciler reconciles a ITAutomationAllInOne object
 type ITAutomationAllInOneReconciler struct {
 	client.Client
-	Log    logr.Logger
-	Scheme *runtime.Scheme
+	Log           logr.Logger
+	Scheme        *runtime.Scheme
+	ImageDefaults ImageDefaults
+	// RouteAvailable tells whether the cluster serves OpenShift Routes
+	RouteAvailable bool
+	// Recorder records the actions taken on an instance as events of the custom resource
+	Recorder record.EventRecorder
 }
 
 //+kubebuilder:rbac:groups=ita-all-in-one.ita.exastro,resources=itautomationallinones,verbs=get;list;watch;create;update;patch;delete
@@ -42,6 +56,17 @@ type ITAutomationAllInOneReconciler struct {
 //+kubebuilder:rbac:groups=ita-all-in-one.ita.exastro,resources=itautomationallinones/finalizers,verbs=update
 //+kubebuilder:rbac:groups=apps,resources=deployments,verbs=get;list;watch;create;update;delete
 //+kubebuilder:rbac:groups=core,resources=services,verbs=get;list;watch;create;update;delete
+//+kubebuilder:rbac:groups=networking.k8s.io,resources=ingresses,verbs=get;list;watch;create;update;delete
+//+kubebuilder:rbac:groups=networking.k8s.io,resources=networkpolicies,verbs=get;list;watch;create;update;delete
+//+kubebuilder:rbac:groups=route.openshift.io,resources=routes,verbs=get;list;watch;create;update;delete
+//+kubebuilder:rbac:groups=route.openshift.io,resources=routes/custom-host,verbs=create
+//+kubebuilder:rbac:groups=core,resources=persistentvolumeclaims,verbs=get;list;watch;create;update;delete
+//+kubebuilder:rbac:groups=core,resources=nodes,verbs=get;list;watch
+//+kubebuilder:rbac:groups=core,resources=pods,verbs=get;list;watch
+//+kubebuilder:rbac:groups=batch,resources=jobs,verbs=get;list;watch;create;update;delete
+//+kubebuilder:rbac:groups=core,resources=events,verbs=create;patch
+//+kubebuilder:rbac:groups=core,resources=secrets,verbs=get;list;watch;create;update;delete
+//+kubebuilder:rbac:groups=core,resources=configmaps,verbs=get;list;watch;create;update;delete
 
 func (reconciler *ITAutomationAllInOneReconciler) Reconcile(ctx context.Context, request ctrl.Request) (ctrl.Result, error) {
 	customResource := &itaallinonev1.ITAutomationAllInOne{}
@@ -50,19 +75,138 @@ func (reconciler *ITAutomationAllInOneReconciler) Reconcile(ctx context.Context,
 		return result, err
 	}
 
-	frontendDeploymentFactory := &DeploymentFactoryForFrontend{CustomResource: customResource, Reconciler: reconciler}
-	requeue, result, err = reconciler.ensureK8sResource(ctx, request, frontendDeploymentFactory)
+	if !customResource.DeletionTimestamp.IsZero() {
+		return reconciler.finalize(ctx, customResource)
+	}
+
+	// A paused instance is only observed, so that its resources can be changed by hand
+	if customResource.Spec.Paused {
+		return reconciler.updateStatus(ctx, customResource, ctrl.Result{}, nil)
+	}
+
+	requeue, result, err = reconciler.ensureFinalizer(ctx, customResource)
 	if requeue {
 		return result, err
 	}
 
-	frontendServiceFactory := &ServiceFactoryForFrontend{CustomResource: customResource, Reconciler: reconciler}
-	requeue, result, err = reconciler.ensureK8sResource(ctx, request, frontendServiceFactory)
+	requeue, result, err = reconciler.ensureCredentials(ctx, customResource)
 	if requeue {
-		return result, err
+		return reconciler.updateStatus(ctx, customResource, result, err)
+	}
+
+	if customResource.Spec.FilePvcName == "" {
+		fileVolumeClaimFactory := newPersistentVolumeClaimFactoryForVolume(reconciler, customResource, fileVolumeName)
+		if customResource.Spec.Storage != nil {
+			fileVolumeClaimFactory.Storage = customResource.Spec.Storage.File
+		}
+		requeue, result, err = reconciler.ensureK8sResource(ctx, customResource, fileVolumeClaimFactory)
+		if requeue {
+			return reconciler.updateStatus(ctx, customResource, result, err)
+		}
+	}
+
+	if customResource.Spec.DatabasePvcName == "" {
+		databaseVolumeClaimFactory := newPersistentVolumeClaimFactoryForVolume(reconciler, customResource, databaseVolumeName)
+		if customResource.Spec.Storage != nil {
+			databaseVolumeClaimFactory.Storage = customResource.Spec.Storage.Database
+		}
+		requeue, result, err = reconciler.ensureK8sResource(ctx, customResource, databaseVolumeClaimFactory)
+		if requeue {
+			return reconciler.updateStatus(ctx, customResource, result, err)
+		}
+	}
+
+	version, err := reconciler.resolveDeploymentVersion(ctx, customResource)
+	if err != nil {
+		return reconciler.updateStatus(ctx, customResource, ctrl.Result{}, err)
+	}
+
+	requeue, result, err = reconciler.ensureVolumeInitialization(ctx, customResource, version)
+	if requeue {
+		return reconciler.updateStatus(ctx, customResource, result, err)
+	}
+
+	frontendDeploymentFactory := newDeploymentFactoryForFrontend(reconciler, customResource)
+	frontendDeploymentFactory.Version = version
+	requeue, result, err = reconciler.ensureK8sResource(ctx, customResource, frontendDeploymentFactory)
+	if requeue {
+		return reconciler.updateStatus(ctx, customResource, result, err)
+	}
+
+	requeue, result, err = reconciler.ensureVolumesInitialized(ctx, customResource)
+	if requeue {
+		return reconciler.updateStatus(ctx, customResource, result, err)
+	}
+
+	frontendServiceFactory := newServiceFactoryForFrontend(reconciler, customResource)
+	requeue, result, err = reconciler.ensureK8sResource(ctx, customResource, frontendServiceFactory)
+	if requeue {
+		return reconciler.updateStatus(ctx, customResource, result, err)
+	}
+
+	requeue, result, err = reconciler.ensureDatabaseService(ctx, customResource)
+	if requeue {
+		return reconciler.updateStatus(ctx, customResource, result, err)
+	}
+
+	frontendIngressFactory := newIngressFactoryForFrontend(reconciler, customResource)
+	if customResource.Spec.Ingress != nil {
+		requeue, result, err = reconciler.ensureK8sResource(ctx, customResource, frontendIngressFactory)
+	} else {
+		requeue, result, err = reconciler.ensureK8sResourceDeleted(ctx, customResource, frontendIngressFactory)
+	}
+	if requeue {
+		return reconciler.updateStatus(ctx, customResource, result, err)
 	}
 
-	return ctrl.Result{}, nil
+	if reconciler.RouteAvailable {
+		frontendRouteFactory := newRouteFactoryForFrontend(reconciler, customResource)
+		if isRouteEnabled(customResource) {
+			requeue, result, err = reconciler.ensureK8sResource(ctx, customResource, frontendRouteFactory)
+		} else {
+			requeue, result, err = reconciler.ensureK8sResourceDeleted(ctx, customResource, frontendRouteFactory)
+		}
+		if requeue {
+			return reconciler.updateStatus(ctx, customResource, result, err)
+		}
+	} else if isRouteEnabled(customResource) {
+		reconciler.Log.Info("Route is enabled but the cluster does not serve the route.openshift.io API. Ignoring", k8sResourceToLogParameters(customResource)...)
+		reconciler.Recorder.Event(customResource, corev1.EventTypeWarning, eventReasonRouteUnavailable,
+			"Route is enabled but the cluster does not serve the route.openshift.io API")
+	}
+
+	// The data of a suspended instance does not change, so no backup is scheduled
+	if customResource.Spec.Suspended {
+		return reconciler.updateStatus(ctx, customResource, ctrl.Result{}, nil)
+	}
+
+	skippedBackupTime, result, err := reconciler.ensureScheduledBackups(ctx, customResource)
+	return reconciler.updateStatusWithSkippedBackup(ctx, customResource, skippedBackupTime, result, err)
+}
+
+func isRouteEnabled(customResource *itaallinonev1.ITAutomationAllInOne) bool {
+	return customResource.Spec.Route != nil && customResource.Spec.Route.Enabled
+}
+
+// ensureDatabaseService exposes the embedded database when requested. The NetworkPolicy is created
+// before and deleted after the Service, so that the database is never exposed without it.
+func (reconciler *ITAutomationAllInOneReconciler) ensureDatabaseService(ctx context.Context, customResource *itaallinonev1.ITAutomationAllInOne) (bool, ctrl.Result, error) {
+	databaseNetworkPolicyFactory := newNetworkPolicyFactoryForDatabase(reconciler, customResource)
+	databaseServiceFactory := newServiceFactoryForDatabase(reconciler, customResource)
+
+	if customResource.GetDatabaseService() != nil {
+		requeue, result, err := reconciler.ensureK8sResource(ctx, customResource, databaseNetworkPolicyFactory)
+		if requeue {
+			return requeue, result, err
+		}
+		return reconciler.ensureK8sResource(ctx, customResource, databaseServiceFactory)
+	}
+
+	requeue, result, err := reconciler.ensureK8sResourceDeleted(ctx, customResource, databaseServiceFactory)
+	if requeue {
+		return requeue, result, err
+	}
+	return reconciler.ensureK8sResourceDeleted(ctx, customResource, databaseNetworkPolicyFactory)
 }
 
 func (reconciler *ITAutomationAllInOneReconciler) fetchCustomResource(ctx context.Context, request ctrl.Request, customResource *itaallinonev1.ITAutomationAllInOne) (bool, ctrl.Result, error) {
@@ -83,29 +227,116 @@ func (reconciler *ITAutomationAllInOneReconciler) fetchCustomResource(ctx contex
 	return makeReturnValuesContinue()
 }
 
-func (reconciler *ITAutomationAllInOneReconciler) ensureK8sResource(ctx context.Context, request ctrl.Request, k8sResourceFactory K8sResourceFactory) (bool, ctrl.Result, error) {
+func (reconciler *ITAutomationAllInOneReconciler) ensureK8sResource(ctx context.Context, customResource *itaallinonev1.ITAutomationAllInOne, k8sResourceFactory K8sResourceFactory) (bool, ctrl.Result, error) {
+	return convergeK8sResource(ctx, reconciler.Client, reconciler.Log, reconciler.Recorder, customResource, k8sResourceFactory)
+}
+
+func (reconciler *ITAutomationAllInOneReconciler) ensureK8sResourceDeleted(ctx context.Context, customResource *itaallinonev1.ITAutomationAllInOne, k8sResourceFactory K8sResourceFactory) (bool, ctrl.Result, error) {
+	return deleteControlledK8sResource(ctx, reconciler.Client, reconciler.Log, reconciler.Recorder, customResource, k8sResourceFactory)
+}
+
+// convergeK8sResource creates the resource or brings it back to the desired state, and records
+// what it did on the custom resource.
+func convergeK8sResource(ctx context.Context, c client.Client, log logr.Logger, recorder record.EventRecorder, customResource client.Object, k8sResourceFactory K8sResourceFactory) (bool, ctrl.Result, error) {
 	k8sResource := k8sResourceFactory.NewDefault()
-	err := reconciler.Get(ctx, k8sResourceFactory.GetNamespaceName(), k8sResource)
+	err := c.Get(ctx, k8sResourceFactory.GetNamespaceName(), k8sResource)
 	if err != nil && errors.IsNotFound(err) {
 		k8sResource = k8sResourceFactory.New()
+		setManagedMetadataKeys(k8sResource)
+		err = setDesiredStateHash(k8sResource)
+		if err != nil {
+			log.Error(err, "Failed to calculate hash of resource", k8sResourceToLogParameters(k8sResource)...)
+			return makeReturnValuesRequeueWithError(err)
+		}
 
-		reconciler.Log.Info("Creating resource", k8sResourceToLogParameters(k8sResource)...)
+		log.Info("Creating resource", k8sResourceToLogParameters(k8sResource)...)
 
-		err = reconciler.Create(ctx, k8sResource)
+		err = c.Create(ctx, k8sResource)
 		if err != nil {
-			reconciler.Log.Error(err, "Failed to create resource", k8sResourceToLogParameters(k8sResource)...)
+			log.Error(err, "Failed to create resource", k8sResourceToLogParameters(k8sResource)...)
+			recorder.Eventf(customResource, corev1.EventTypeWarning, eventReasonFailedCreate, "Failed to create %s: %v",
+				describeK8sResource(c.Scheme(), k8sResource, k8sResource.GetName()), err)
 			return makeReturnValuesRequeueWithError(err)
 		}
 
+		recorder.Eventf(customResource, corev1.EventTypeNormal, eventReasonCreated, "Created %s",
+			describeK8sResource(c.Scheme(), k8sResource, k8sResource.GetName()))
 		return makeReturnValuesRequeue()
 	} else if err != nil {
-		reconciler.Log.Error(err, "Failed to get resource", k8sResourceToLogParameters(k8sResource)...)
+		log.Error(err, "Failed to get resource", k8sResourceToLogParameters(k8sResource)...)
 		return makeReturnValuesRequeueWithError(err)
 	}
 
-	reconciler.Log.Info("Resource is found", k8sResourceToLogParameters(k8sResource)...)
+	desiredK8sResource := k8sResourceFactory.New()
+	setManagedMetadataKeys(desiredK8sResource)
+	err = setDesiredStateHash(desiredK8sResource)
+	if err != nil {
+		log.Error(err, "Failed to calculate hash of resource", k8sResourceToLogParameters(desiredK8sResource)...)
+		return makeReturnValuesRequeueWithError(err)
+	}
+	if adjuster, ok := k8sResourceFactory.(K8sResourceDesiredStateAdjuster); ok {
+		adjuster.AdjustDesired(k8sResource, desiredK8sResource)
+	}
 
-	return makeReturnValuesContinue()
+	// Fields left empty in the desired resource are ignored, so that values
+	// defaulted by the API server or set by other controllers do not count as drift.
+	if equality.Semantic.DeepDerivative(desiredK8sResource, k8sResource) {
+		log.Info("Resource is up to date", k8sResourceToLogParameters(k8sResource)...)
+		return makeReturnValuesContinue()
+	}
+
+	mergeObjectMeta(k8sResource, desiredK8sResource)
+	k8sResourceFactory.Merge(k8sResource, desiredK8sResource)
+
+	log.Info("Updating resource", k8sResourceToLogParameters(k8sResource)...)
+
+	err = c.Update(ctx, k8sResource)
+	if err != nil {
+		if errors.IsConflict(err) {
+			log.Info("Resource was modified concurrently. Retrying", k8sResourceToLogParameters(k8sResource)...)
+			return makeReturnValuesRequeue()
+		}
+
+		log.Error(err, "Failed to update resource", k8sResourceToLogParameters(k8sResource)...)
+		recorder.Eventf(customResource, corev1.EventTypeWarning, eventReasonFailedUpdate, "Failed to update %s: %v",
+			describeK8sResource(c.Scheme(), k8sResource, k8sResource.GetName()), err)
+		return makeReturnValuesRequeueWithError(err)
+	}
+
+	recorder.Eventf(customResource, corev1.EventTypeNormal, eventReasonUpdated, "Updated %s",
+		describeK8sResource(c.Scheme(), k8sResource, k8sResource.GetName()))
+	return makeReturnValuesRequeue()
+}
+
+// deleteControlledK8sResource deletes a resource which is no longer enabled in the spec.
+// Resources that are not controlled by the custom resource are left untouched.
+func deleteControlledK8sResource(ctx context.Context, c client.Client, log logr.Logger, recorder record.EventRecorder, customResource client.Object, k8sResourceFactory K8sResourceFactory) (bool, ctrl.Result, error) {
+	k8sResource := k8sResourceFactory.NewDefault()
+	err := c.Get(ctx, k8sResourceFactory.GetNamespaceName(), k8sResource)
+	if err != nil && errors.IsNotFound(err) {
+		return makeReturnValuesContinue()
+	} else if err != nil {
+		log.Error(err, "Failed to get resource", k8sResourceToLogParameters(k8sResource)...)
+		return makeReturnValuesRequeueWithError(err)
+	}
+
+	if !metav1.IsControlledBy(k8sResource, customResource) {
+		return makeReturnValuesContinue()
+	}
+
+	log.Info("Deleting resource", k8sResourceToLogParameters(k8sResource)...)
+
+	err = c.Delete(ctx, k8sResource)
+	if err != nil && !errors.IsNotFound(err) {
+		log.Error(err, "Failed to delete resource", k8sResourceToLogParameters(k8sResource)...)
+		recorder.Eventf(customResource, corev1.EventTypeWarning, eventReasonFailedDelete, "Failed to delete %s: %v",
+			describeK8sResource(c.Scheme(), k8sResource, k8sResource.GetName()), err)
+		return makeReturnValuesRequeueWithError(err)
+	}
+
+	recorder.Eventf(customResource, corev1.EventTypeNormal, eventReasonDeleted, "Deleted %s",
+		describeK8sResource(c.Scheme(), k8sResource, k8sResource.GetName()))
+	return makeReturnValuesRequeue()
 }
 
 func k8sResourceToLogParameters(k8sResource client.Object) []interface{} {
@@ -136,9 +367,30 @@ func makeReturnValuesContinue() (bool, ctrl.Result, error) {
 
 // SetupWithManager sets up the controller with the Manager.
 func (reconciler *ITAutomationAllInOneReconciler) SetupWithManager(mgr ctrl.Manager) error {
-	return ctrl.NewControllerManagedBy(mgr).
+	builder := ctrl.NewControllerManagedBy(mgr).
 		For(&itaallinonev1.ITAutomationAllInOne{}).
 		Owns(&appsv1.Deployment{}).
 		Owns(&corev1.Service{}).
-		Complete(reconciler)
+		Owns(&corev1.PersistentVolumeClaim{}).
+		Owns(&corev1.Secret{}).
+		Owns(&corev1.ConfigMap{}).
+		Owns(&networkingv1.Ingress{}).
+		Owns(&networkingv1.NetworkPolicy{}).
+		Owns(&batchv1.Job{}).
+		Owns(&itaallinonev1.ITAutomationRestore{})
+
+	if reconciler.RouteAvailable {
+		builder = builder.Owns(newRoute())
+	}
+
+	// Backups are not owned by the instance, but their completion is reported in its status
+	builder = builder.Watches(&source.Kind{Type: &itaallinonev1.ITAutomationBackup{}},
+		handler.EnqueueRequestsFromMapFunc(func(object client.Object) []reconcile.Request {
+			backup := object.(*itaallinonev1.ITAutomationBackup)
+			return []reconcile.Request{
+				{NamespacedName: types.NamespacedName{Namespace: backup.Namespace, Name: backup.Spec.InstanceName}},
+			}
+		}))
+
+	return builder.Complete(reconciler)
 }
@@ -0,0 +1,123 @@
+/*
+Copyright 2021 NEC Corporation.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+
+	itaallinonev1 "github.com/exastro-suite/it-automation-operator/api/v1"
+)
+
+// Reasons of the events recorded on the custom resource. The phases of an upgrade are
+// recorded with the reason "Upgrade" followed by the phase, e.g. UpgradeSucceeded.
+const (
+	eventReasonCreated               = "Created"
+	eventReasonUpdated               = "Updated"
+	eventReasonDeleted               = "Deleted"
+	eventReasonFailedCreate          = "FailedCreate"
+	eventReasonFailedUpdate          = "FailedUpdate"
+	eventReasonFailedDelete          = "FailedDelete"
+	eventReasonRouteUnavailable      = "RouteUnavailable"
+	eventReasonInvalidBackupSchedule = "InvalidBackupSchedule"
+	eventReasonBackupPruned          = "BackupPruned"
+	eventReasonFailedPrune           = "FailedPrune"
+	eventReasonBackupSkipped         = "BackupSkipped"
+	eventReasonVolumeInitialized     = "VolumeInitialized"
+	eventReasonInitializationFailed  = "InitializationFailed"
+	eventReasonDeletionBlocked       = "DeletionBlocked"
+	eventReasonDeletionPolicySkipped = "DeletionPolicySkipped"
+	eventReasonInstanceStopped       = "InstanceStopped"
+	eventReasonInstanceStarted       = "InstanceStarted"
+)
+
+// Reasons of the events recorded on backups and restores, and on the instance they act on
+const (
+	eventReasonBackupStarted    = "BackupStarted"
+	eventReasonBackupCompleted  = "BackupCompleted"
+	eventReasonBackupFailed     = "BackupFailed"
+	eventReasonRestoreStarted   = "RestoreStarted"
+	eventReasonRestoreCompleted = "RestoreCompleted"
+	eventReasonRestoreFailed    = "RestoreFailed"
+)
+
+// ensureK8sResourceCreatedWithEvent creates a resource which is never updated afterwards like
+// ensureK8sResourceCreated, and records its creation on the custom resource.
+func (reconciler *ITAutomationAllInOneReconciler) ensureK8sResourceCreatedWithEvent(ctx context.Context, customResource *itaallinonev1.ITAutomationAllInOne, k8sResourceFactory K8sResourceFactory) (bool, ctrl.Result, error) {
+	requeue, result, err := ensureK8sResourceCreated(ctx, reconciler.Client, reconciler.Log, k8sResourceFactory)
+	if err != nil {
+		reconciler.Recorder.Eventf(customResource, corev1.EventTypeWarning, eventReasonFailedCreate, "Failed to create %s: %v",
+			describeK8sResource(reconciler.Scheme, k8sResourceFactory.NewDefault(), k8sResourceFactory.GetName()), err)
+	} else if requeue {
+		reconciler.Recorder.Eventf(customResource, corev1.EventTypeNormal, eventReasonCreated, "Created %s",
+			describeK8sResource(reconciler.Scheme, k8sResourceFactory.NewDefault(), k8sResourceFactory.GetName()))
+	}
+
+	return requeue, result, err
+}
+
+// recordUpgradeEvent records the phase the upgrade has moved to. Upgrades which did not
+// reach the new version are recorded as warnings.
+func (reconciler *ITAutomationAllInOneReconciler) recordUpgradeEvent(customResource *itaallinonev1.ITAutomationAllInOne, previous *itaallinonev1.UpgradeStatus) {
+	upgrade := customResource.Status.Upgrade
+	if upgrade == nil || upgrade.Phase == "" {
+		return
+	}
+	if previous != nil && previous.ToVersion == upgrade.ToVersion && previous.Phase == upgrade.Phase {
+		return
+	}
+
+	eventType := corev1.EventTypeNormal
+	switch upgrade.Phase {
+	case itaallinonev1.UpgradePhaseRejected, itaallinonev1.UpgradePhaseFailed,
+		itaallinonev1.UpgradePhaseRollingBack, itaallinonev1.UpgradePhaseRolledBack:
+		eventType = corev1.EventTypeWarning
+	}
+
+	reconciler.Recorder.Eventf(customResource, eventType, "Upgrade"+string(upgrade.Phase), "Upgrade from %s to %s: %s",
+		upgrade.FromVersion, upgrade.ToVersion, upgrade.Message)
+}
+
+// recordInstanceEvent records the event on the object and on the instance it acts on.
+// The instance is looked up so that the event refers to its UID, and is skipped when it is gone.
+func recordInstanceEvent(ctx context.Context, reader client.Reader, recorder record.EventRecorder, object client.Object, instanceName string, eventType string, reason string, messageFmt string, args ...interface{}) {
+	recorder.Eventf(object, eventType, reason, messageFmt, args...)
+
+	instance := &itaallinonev1.ITAutomationAllInOne{}
+	err := reader.Get(ctx, types.NamespacedName{Namespace: object.GetNamespace(), Name: instanceName}, instance)
+	if err != nil {
+		return
+	}
+	recorder.Eventf(instance, eventType, reason, messageFmt, args...)
+}
+
+// describeK8sResource returns the kind and the name of a resource for the message of an event
+func describeK8sResource(scheme *runtime.Scheme, k8sResource runtime.Object, name string) string {
+	gvk, err := apiutil.GVKForObject(k8sResource, scheme)
+	if err != nil {
+		return name
+	}
+	return fmt.Sprintf("%s %s", gvk.Kind, name)
+}
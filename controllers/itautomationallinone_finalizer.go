@@ -0,0 +1,272 @@
+/*
+Copyright 2021 NEC Corporation.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-logr/logr"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	itaallinonev1 "github.com/exastro-suite/it-automation-operator/api/v1"
+)
+
+const finalizerName = "ita-all-in-one.ita.exastro/finalizer"
+
+// Pods are not watched, so the termination of the instance is polled.
+const podTerminationRecheckInterval = 5 * time.Second
+
+func (reconciler *ITAutomationAllInOneReconciler) ensureFinalizer(ctx context.Context, customResource *itaallinonev1.ITAutomationAllInOne) (bool, ctrl.Result, error) {
+	if controllerutil.ContainsFinalizer(customResource, finalizerName) {
+		return makeReturnValuesContinue()
+	}
+
+	reconciler.Log.Info("Adding finalizer", k8sResourceToLogParameters(customResource)...)
+
+	controllerutil.AddFinalizer(customResource, finalizerName)
+	err := reconciler.Update(ctx, customResource)
+	if err != nil {
+		if errors.IsConflict(err) {
+			reconciler.Log.Info("Custom resource was modified concurrently. Retrying", k8sResourceToLogParameters(customResource)...)
+			return makeReturnValuesRequeue()
+		}
+
+		reconciler.Log.Error(err, "Failed to add finalizer", k8sResourceToLogParameters(customResource)...)
+		return makeReturnValuesRequeueWithError(err)
+	}
+
+	return makeReturnValuesRequeue()
+}
+
+// finalize applies the deletion policy and removes the finalizer once it is done.
+// The instance is stopped before the volumes are touched, so that the database is consistent.
+func (reconciler *ITAutomationAllInOneReconciler) finalize(ctx context.Context, customResource *itaallinonev1.ITAutomationAllInOne) (ctrl.Result, error) {
+	if !controllerutil.ContainsFinalizer(customResource, finalizerName) {
+		return ctrl.Result{}, nil
+	}
+
+	plan, err := reconciler.planDeletion(ctx, customResource)
+	if err != nil {
+		return reconciler.updateStatus(ctx, customResource, ctrl.Result{}, err)
+	}
+	if plan.MissingClaim != "" {
+		reconciler.Recorder.Eventf(customResource, corev1.EventTypeWarning, eventReasonDeletionPolicySkipped,
+			"PersistentVolumeClaim %s is gone, the %s policy is not applied and the remaining data is retained", plan.MissingClaim, customResource.GetDeletionPolicy())
+	}
+	if plan.MissingBackupClaim != "" {
+		reconciler.Recorder.Eventf(customResource, corev1.EventTypeWarning, eventReasonDeletionBlocked,
+			"PersistentVolumeClaim %s for the final backup is not found", plan.MissingBackupClaim)
+		return reconciler.updateStatus(ctx, customResource, ctrl.Result{RequeueAfter: storageRecheckInterval}, nil)
+	}
+
+	jobFactory := plan.JobFactory
+	if jobFactory != nil {
+		frontendDeploymentFactory := newDeploymentFactoryForFrontend(reconciler, customResource)
+		requeue, result, err := reconciler.ensureK8sResource(ctx, customResource, frontendDeploymentFactory)
+		if requeue {
+			return reconciler.updateStatus(ctx, customResource, result, err)
+		}
+
+		podsExist, err := hasFrontendPods(ctx, reconciler.Client, customResource)
+		if err != nil {
+			return reconciler.updateStatus(ctx, customResource, ctrl.Result{}, err)
+		}
+		if podsExist {
+			return reconciler.updateStatus(ctx, customResource, ctrl.Result{RequeueAfter: podTerminationRecheckInterval}, nil)
+		}
+
+		requeue, result, err = reconciler.ensureK8sResourceCreatedWithEvent(ctx, customResource, jobFactory)
+		if requeue {
+			return reconciler.updateStatus(ctx, customResource, result, err)
+		}
+
+		k8sJob := &batchv1.Job{}
+		err = reconciler.Get(ctx, jobFactory.GetNamespaceName(), k8sJob)
+		if err != nil {
+			return reconciler.updateStatus(ctx, customResource, ctrl.Result{}, err)
+		}
+
+		// A failed Job blocks the deletion. It is retried when the Job is deleted.
+		if isJobFailed(k8sJob) {
+			reconciler.Recorder.Eventf(customResource, corev1.EventTypeWarning, eventReasonDeletionBlocked,
+				"Job %s failed: %s. Delete the Job to retry", k8sJob.Name, findJobCondition(k8sJob, batchv1.JobFailed).Message)
+		}
+		if !isJobSucceeded(k8sJob) {
+			return reconciler.updateStatus(ctx, customResource, ctrl.Result{}, nil)
+		}
+	}
+
+	reconciler.Log.Info("Deletion policy is applied. Removing finalizer", k8sResourceToLogParameters(customResource)...)
+
+	controllerutil.RemoveFinalizer(customResource, finalizerName)
+	err = reconciler.Update(ctx, customResource)
+	if err != nil {
+		if errors.IsConflict(err) {
+			reconciler.Log.Info("Custom resource was modified concurrently. Retrying", k8sResourceToLogParameters(customResource)...)
+			return ctrl.Result{Requeue: true}, nil
+		}
+
+		reconciler.Log.Error(err, "Failed to remove finalizer", k8sResourceToLogParameters(customResource)...)
+		return ctrl.Result{}, err
+	}
+
+	return ctrl.Result{}, nil
+}
+
+// newDeletionJobFactory returns the factory of the Job which applies the deletion policy,
+// or nil when the data is retained.
+func newDeletionJobFactory(reconciler *ITAutomationAllInOneReconciler, customResource *itaallinonev1.ITAutomationAllInOne) *JobFactoryForVolumes {
+	switch customResource.GetDeletionPolicy() {
+	case itaallinonev1.DeletionPolicyWipe:
+		factory := newJobFactoryForVolumes(reconciler, customResource, volumeOperationWipe)
+		factory.Script = createWipeScript(customResource)
+		return factory
+	case itaallinonev1.DeletionPolicyBackup:
+		factory := newJobFactoryForVolumes(reconciler, customResource, volumeOperationFinalBackup)
+		factory.Script = createFinalBackupScript(customResource)
+		factory.BackupPvcName = customResource.Spec.Deletion.BackupPvcName
+		return factory
+	}
+
+	return nil
+}
+
+// deletionPlan tells how the deletion policy is applied
+type deletionPlan struct {
+	// JobFactory creates the Job applying the policy, or is nil when the data is retained
+	JobFactory *JobFactoryForVolumes
+	// MissingClaim is a volume of the instance which is gone. There is nothing left
+	// to back up or wipe then, so the data is retained.
+	MissingClaim string
+	// MissingBackupClaim is the claim of the final backup when it is not found
+	MissingBackupClaim string
+}
+
+// planDeletion checks the claims the deletion policy needs before the Job is created,
+// since a Job which cannot mount them would never complete.
+func (reconciler *ITAutomationAllInOneReconciler) planDeletion(ctx context.Context, customResource *itaallinonev1.ITAutomationAllInOne) (deletionPlan, error) {
+	plan := deletionPlan{JobFactory: newDeletionJobFactory(reconciler, customResource)}
+	if plan.JobFactory == nil {
+		return plan, nil
+	}
+
+	// The claims are not needed anymore once the Job is done
+	k8sJob := &batchv1.Job{}
+	err := reconciler.Get(ctx, plan.JobFactory.GetNamespaceName(), k8sJob)
+	if err == nil && isJobSucceeded(k8sJob) {
+		return plan, nil
+	} else if err != nil && !errors.IsNotFound(err) {
+		return plan, err
+	}
+
+	missing, err := findMissingClaim(ctx, reconciler.Client, customResource.Namespace, customResource.GetPvcNames())
+	if err != nil {
+		return plan, err
+	}
+	if missing != "" {
+		return deletionPlan{MissingClaim: missing}, nil
+	}
+
+	if plan.JobFactory.BackupPvcName != "" {
+		missing, err = findMissingClaim(ctx, reconciler.Client, customResource.Namespace, []string{plan.JobFactory.BackupPvcName})
+		if err != nil {
+			return plan, err
+		}
+		plan.MissingBackupClaim = missing
+	}
+
+	return plan, nil
+}
+
+// findMissingClaim returns the first of the claims which does not exist or is being deleted
+func findMissingClaim(ctx context.Context, reader client.Reader, namespace string, names []string) (string, error) {
+	for _, name := range names {
+		k8sPvc := &corev1.PersistentVolumeClaim{}
+		err := reader.Get(ctx, types.NamespacedName{Namespace: namespace, Name: name}, k8sPvc)
+		if errors.IsNotFound(err) {
+			return name, nil
+		} else if err != nil {
+			return "", err
+		}
+		if !k8sPvc.DeletionTimestamp.IsZero() {
+			return name, nil
+		}
+	}
+
+	return "", nil
+}
+
+func hasFrontendPods(ctx context.Context, reader client.Reader, customResource *itaallinonev1.ITAutomationAllInOne) (bool, error) {
+	k8sPods := &corev1.PodList{}
+	err := reader.List(ctx, k8sPods, client.InNamespace(customResource.Namespace), client.MatchingLabels(createLabels(customResource)))
+	if err != nil {
+		return false, err
+	}
+
+	return len(k8sPods.Items) > 0, nil
+}
+
+// ensureK8sResourceCreated creates a resource which is never updated afterwards, e.g. a Job.
+// It is shared by the reconcilers of all kinds.
+func ensureK8sResourceCreated(ctx context.Context, c client.Client, log logr.Logger, k8sResourceFactory K8sResourceFactory) (bool, ctrl.Result, error) {
+	k8sResource := k8sResourceFactory.NewDefault()
+	err := c.Get(ctx, k8sResourceFactory.GetNamespaceName(), k8sResource)
+	if err == nil {
+		return makeReturnValuesContinue()
+	} else if !errors.IsNotFound(err) {
+		log.Error(err, "Failed to get resource", k8sResourceToLogParameters(k8sResource)...)
+		return makeReturnValuesRequeueWithError(err)
+	}
+
+	k8sResource = k8sResourceFactory.New()
+
+	log.Info("Creating resource", k8sResourceToLogParameters(k8sResource)...)
+
+	err = c.Create(ctx, k8sResource)
+	if err != nil {
+		log.Error(err, "Failed to create resource", k8sResourceToLogParameters(k8sResource)...)
+		return makeReturnValuesRequeueWithError(err)
+	}
+
+	return makeReturnValuesRequeue()
+}
+
+func isJobSucceeded(k8sJob *batchv1.Job) bool {
+	return findJobCondition(k8sJob, batchv1.JobComplete) != nil
+}
+
+func isJobFailed(k8sJob *batchv1.Job) bool {
+	return findJobCondition(k8sJob, batchv1.JobFailed) != nil
+}
+
+func findJobCondition(k8sJob *batchv1.Job, conditionType batchv1.JobConditionType) *batchv1.JobCondition {
+	for i := range k8sJob.Status.Conditions {
+		condition := &k8sJob.Status.Conditions[i]
+		if condition.Type == conditionType && condition.Status == corev1.ConditionTrue {
+			return condition
+		}
+	}
+
+	return nil
+}
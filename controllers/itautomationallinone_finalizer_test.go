@@ -0,0 +1,299 @@
+/*
+Copyright 2021 NEC Corporation.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	itaallinonev1 "github.com/exastro-suite/it-automation-operator/api/v1"
+)
+
+// newTestDeletedInstance returns an instance being deleted with the given deletion policy
+func newTestDeletedInstance(policy itaallinonev1.DeletionPolicy, backupPvcName string) *itaallinonev1.ITAutomationAllInOne {
+	deletionTimestamp := metav1.Now()
+	return &itaallinonev1.ITAutomationAllInOne{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "ita", UID: "instance-uid",
+			Finalizers: []string{finalizerName}, DeletionTimestamp: &deletionTimestamp},
+		Spec: itaallinonev1.ITAutomationAllInOneSpec{
+			Deletion: &itaallinonev1.DeletionSpec{Policy: policy, BackupPvcName: backupPvcName},
+		},
+	}
+}
+
+func newTestClaim(name string, deleting bool) *corev1.PersistentVolumeClaim {
+	k8sPvc := &corev1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: name},
+		Status:     corev1.PersistentVolumeClaimStatus{Phase: corev1.ClaimBound},
+	}
+	if deleting {
+		deletionTimestamp := metav1.Now()
+		k8sPvc.DeletionTimestamp = &deletionTimestamp
+		k8sPvc.Finalizers = []string{"kubernetes.io/pvc-protection"}
+	}
+	return k8sPvc
+}
+
+func newTestJob(name string, conditionType batchv1.JobConditionType, message string) *batchv1.Job {
+	return &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: name},
+		Status: batchv1.JobStatus{Conditions: []batchv1.JobCondition{
+			{Type: conditionType, Status: corev1.ConditionTrue, Message: message},
+		}},
+	}
+}
+
+func TestPlanDeletion(t *testing.T) {
+	tests := []struct {
+		name                   string
+		policy                 itaallinonev1.DeletionPolicy
+		backupPvcName          string
+		objects                []client.Object
+		wantJob                string
+		wantMissingClaim       string
+		wantMissingBackupClaim string
+	}{
+		{
+			name:    "retained data needs no Job",
+			policy:  itaallinonev1.DeletionPolicyRetain,
+			objects: []client.Object{newTestClaim("ita-file-volume", false), newTestClaim("ita-database-volume", false)},
+		},
+		{
+			name:    "volumes are wiped",
+			policy:  itaallinonev1.DeletionPolicyWipe,
+			objects: []client.Object{newTestClaim("ita-file-volume", false), newTestClaim("ita-database-volume", false)},
+			wantJob: "ita-wipe",
+		},
+		{
+			name:             "missing claim retains the remaining data",
+			policy:           itaallinonev1.DeletionPolicyWipe,
+			objects:          []client.Object{newTestClaim("ita-file-volume", false)},
+			wantMissingClaim: "ita-database-volume",
+		},
+		{
+			name:             "claim being deleted counts as missing",
+			policy:           itaallinonev1.DeletionPolicyWipe,
+			objects:          []client.Object{newTestClaim("ita-file-volume", true), newTestClaim("ita-database-volume", false)},
+			wantMissingClaim: "ita-file-volume",
+		},
+		{
+			name:          "volumes are backed up",
+			policy:        itaallinonev1.DeletionPolicyBackup,
+			backupPvcName: "final-backup",
+			objects: []client.Object{newTestClaim("ita-file-volume", false), newTestClaim("ita-database-volume", false),
+				newTestClaim("final-backup", false)},
+			wantJob: "ita-final-backup",
+		},
+		{
+			name:                   "missing backup claim blocks the backup",
+			policy:                 itaallinonev1.DeletionPolicyBackup,
+			backupPvcName:          "final-backup",
+			objects:                []client.Object{newTestClaim("ita-file-volume", false), newTestClaim("ita-database-volume", false)},
+			wantJob:                "ita-final-backup",
+			wantMissingBackupClaim: "final-backup",
+		},
+		{
+			name:    "claims are not needed once the Job succeeded",
+			policy:  itaallinonev1.DeletionPolicyWipe,
+			objects: []client.Object{newTestJob("ita-wipe", batchv1.JobComplete, "")},
+			wantJob: "ita-wipe",
+		},
+		{
+			name:             "failed Job still needs the claims",
+			policy:           itaallinonev1.DeletionPolicyWipe,
+			objects:          []client.Object{newTestJob("ita-wipe", batchv1.JobFailed, "BackoffLimitExceeded")},
+			wantMissingClaim: "ita-file-volume",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			scheme := newTestScheme(t)
+			c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(tt.objects...).Build()
+			reconciler := &ITAutomationAllInOneReconciler{Client: c, Log: logr.Discard(), Scheme: scheme}
+
+			plan, err := reconciler.planDeletion(context.Background(), newTestDeletedInstance(tt.policy, tt.backupPvcName))
+			if err != nil {
+				t.Fatal(err)
+			}
+			job := ""
+			if plan.JobFactory != nil {
+				job = plan.JobFactory.GetName()
+			}
+			if job != tt.wantJob {
+				t.Errorf("Job = %q, want %q", job, tt.wantJob)
+			}
+			if plan.MissingClaim != tt.wantMissingClaim {
+				t.Errorf("missing claim = %q, want %q", plan.MissingClaim, tt.wantMissingClaim)
+			}
+			if plan.MissingBackupClaim != tt.wantMissingBackupClaim {
+				t.Errorf("missing backup claim = %q, want %q", plan.MissingBackupClaim, tt.wantMissingBackupClaim)
+			}
+		})
+	}
+}
+
+func TestFinalize(t *testing.T) {
+	claims := []client.Object{newTestClaim("ita-file-volume", false), newTestClaim("ita-database-volume", false)}
+	frontendPod := newTestFrontendPod(newTestRunningInstance(), "")
+
+	tests := []struct {
+		name             string
+		policy           itaallinonev1.DeletionPolicy
+		backupPvcName    string
+		objects          []client.Object
+		wantRequeueAfter time.Duration
+		wantFinalizer    bool
+		wantJobCreated   string
+		wantEvent        string
+	}{
+		{
+			name:   "retained data is released at once",
+			policy: itaallinonev1.DeletionPolicyRetain,
+		},
+		{
+			name:           "wipe Job is created once the frontend is stopped",
+			policy:         itaallinonev1.DeletionPolicyWipe,
+			objects:        claims,
+			wantFinalizer:  true,
+			wantJobCreated: "ita-wipe",
+		},
+		{
+			name:             "running frontend delays the wipe",
+			policy:           itaallinonev1.DeletionPolicyWipe,
+			objects:          append([]client.Object{frontendPod}, claims...),
+			wantRequeueAfter: podTerminationRecheckInterval,
+			wantFinalizer:    true,
+		},
+		{
+			name:          "failed Job blocks the deletion",
+			policy:        itaallinonev1.DeletionPolicyWipe,
+			objects:       append([]client.Object{newTestJob("ita-wipe", batchv1.JobFailed, "BackoffLimitExceeded")}, claims...),
+			wantFinalizer: true,
+			wantEvent:     eventReasonDeletionBlocked,
+		},
+		{
+			name:    "succeeded Job releases the instance",
+			policy:  itaallinonev1.DeletionPolicyWipe,
+			objects: []client.Object{newTestJob("ita-wipe", batchv1.JobComplete, "")},
+		},
+		{
+			name:      "missing claim skips the policy",
+			policy:    itaallinonev1.DeletionPolicyWipe,
+			objects:   claims[:1],
+			wantEvent: eventReasonDeletionPolicySkipped,
+		},
+		{
+			name:             "missing backup claim blocks the deletion",
+			policy:           itaallinonev1.DeletionPolicyBackup,
+			backupPvcName:    "final-backup",
+			objects:          claims,
+			wantRequeueAfter: storageRecheckInterval,
+			wantFinalizer:    true,
+			wantEvent:        eventReasonDeletionBlocked,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			scheme := newTestScheme(t)
+			instance := newTestDeletedInstance(tt.policy, tt.backupPvcName)
+			c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(append([]client.Object{instance}, tt.objects...)...).Build()
+			recorder := record.NewFakeRecorder(100)
+			reconciler := &ITAutomationAllInOneReconciler{Client: c, Log: logr.Discard(), Scheme: scheme, Recorder: recorder}
+
+			// Resources created on the way requeue at once, like the manager would
+			var result ctrl.Result
+			for i := 0; i < 10; i++ {
+				if err := c.Get(ctx, client.ObjectKeyFromObject(instance), instance); err != nil {
+					t.Fatal(err)
+				}
+				var err error
+				result, err = reconciler.finalize(ctx, instance)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !result.Requeue {
+					break
+				}
+			}
+
+			if result.RequeueAfter != tt.wantRequeueAfter {
+				t.Errorf("requeue after = %v, want %v", result.RequeueAfter, tt.wantRequeueAfter)
+			}
+			if err := c.Get(ctx, client.ObjectKeyFromObject(instance), instance); err != nil {
+				t.Fatal(err)
+			}
+			if hasFinalizer := controllerutil.ContainsFinalizer(instance, finalizerName); hasFinalizer != tt.wantFinalizer {
+				t.Errorf("finalizer = %v, want %v", hasFinalizer, tt.wantFinalizer)
+			}
+
+			k8sJobs := &batchv1.JobList{}
+			if err := c.List(ctx, k8sJobs); err != nil {
+				t.Fatal(err)
+			}
+			for _, k8sJob := range k8sJobs.Items {
+				if len(k8sJob.Status.Conditions) == 0 && k8sJob.Name != tt.wantJobCreated {
+					t.Errorf("Job %s is created, want %q", k8sJob.Name, tt.wantJobCreated)
+				}
+			}
+			if tt.wantJobCreated != "" {
+				k8sJob := &batchv1.Job{}
+				err := c.Get(ctx, client.ObjectKey{Namespace: "default", Name: tt.wantJobCreated}, k8sJob)
+				if errors.IsNotFound(err) {
+					t.Errorf("Job %s is not created", tt.wantJobCreated)
+				} else if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if tt.wantJobCreated != "" || tt.wantRequeueAfter == podTerminationRecheckInterval {
+				// The frontend is stopped before the volumes are touched
+				k8sDeployment := &appsv1.Deployment{}
+				if err := c.Get(ctx, newDeploymentFactoryForFrontend(reconciler, instance).GetNamespaceName(), k8sDeployment); err != nil {
+					t.Fatal(err)
+				}
+				if replicas := k8sDeployment.Spec.Replicas; replicas == nil || *replicas != 0 {
+					t.Errorf("frontend replicas = %v, want 0", replicas)
+				}
+			}
+
+			events := recordedEvents(recorder)
+			if tt.wantEvent != "" && !strings.Contains(events, " "+tt.wantEvent+" ") {
+				t.Errorf("events = %q, want %s", events, tt.wantEvent)
+			}
+			if tt.wantEvent == "" && strings.Contains(events, "Deletion") {
+				t.Errorf("events = %q, want no deletion event", events)
+			}
+		})
+	}
+}
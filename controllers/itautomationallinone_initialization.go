@@ -0,0 +1,334 @@
+/*
+Copyright 2021 NEC Corporation.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	itaallinonev1 "github.com/exastro-suite/it-automation-operator/api/v1"
+)
+
+const (
+	// volumeInitializedAtAnnotation marks a claim whose volume has been initialized
+	volumeInitializedAtAnnotation = "ita-all-in-one.ita.exastro/initialized-at"
+	// volumeInitializationAnnotation records the reinitialization request a volume was last initialized for
+	volumeInitializationAnnotation = "ita-all-in-one.ita.exastro/initialization"
+	// reinitializeAnnotationPrefix followed by the name of a volume is set on the custom resource by the user.
+	// The volume is initialized again whenever the value differs from the one recorded on its claim.
+	reinitializeAnnotationPrefix = "ita-all-in-one.ita.exastro/reinitialize-"
+)
+
+// instanceVolumeNames returns the volumes the frontend of the instance mounts
+func instanceVolumeNames(customResource *itaallinonev1.ITAutomationAllInOne) []string {
+	return []string{fileVolumeName, databaseVolumeName}
+}
+
+func volumeClaimName(customResource *itaallinonev1.ITAutomationAllInOne, volumeName string) string {
+	if volumeName == databaseVolumeName {
+		return customResource.GetDatabasePvcName()
+	}
+	return customResource.GetFilePvcName()
+}
+
+// needsInitialization reports whether the volume of the claim is initialized when the frontend starts next
+func needsInitialization(customResource *itaallinonev1.ITAutomationAllInOne, volumeName string, k8sPvc *corev1.PersistentVolumeClaim) bool {
+	if _, found := k8sPvc.Annotations[volumeInitializedAtAnnotation]; !found {
+		return true
+	}
+	request := customResource.Annotations[reinitializeAnnotationPrefix+volumeName]
+	return request != "" && request != k8sPvc.Annotations[volumeInitializationAnnotation]
+}
+
+// ensureVolumeInitialization initializes the volumes which are not initialized yet or are requested
+// to be initialized again. Volumes of an instance which has already run without being marked were
+// initialized on every start before, so they are marked instead. Volumes which are emptied afterwards
+// are never initialized again without a request.
+func (reconciler *ITAutomationAllInOneReconciler) ensureVolumeInitialization(ctx context.Context, customResource *itaallinonev1.ITAutomationAllInOne, version string) (bool, ctrl.Result, error) {
+	var pendingVolumes []string
+	for _, volumeName := range instanceVolumeNames(customResource) {
+		k8sPvc := &corev1.PersistentVolumeClaim{}
+		err := reconciler.Get(ctx, types.NamespacedName{Namespace: customResource.Namespace, Name: volumeClaimName(customResource, volumeName)}, k8sPvc)
+		if errors.IsNotFound(err) {
+			pendingVolumes = append(pendingVolumes, volumeName)
+			continue
+		} else if err != nil {
+			reconciler.Log.Error(err, "Failed to get resource", k8sResourceToLogParameters(k8sPvc)...)
+			return makeReturnValuesRequeueWithError(err)
+		}
+
+		if !needsInitialization(customResource, volumeName, k8sPvc) {
+			continue
+		}
+
+		_, marked := k8sPvc.Annotations[volumeInitializedAtAnnotation]
+		if !marked && customResource.Status.Version != "" && customResource.Annotations[reinitializeAnnotationPrefix+volumeName] == "" {
+			return reconciler.markVolumeInitialized(ctx, customResource, volumeName, k8sPvc)
+		}
+
+		pendingVolumes = append(pendingVolumes, volumeName)
+	}
+
+	// The frontend initializes the volumes when it starts unless a Job does
+	initializedByJob := itaallinonev1.HasImageFeature(customResource.Spec.Image, itaallinonev1.ImageFeatureInitializationJob)
+	configMapFactory := newConfigMapFactoryForInitialization(reconciler, customResource)
+	if !initializedByJob {
+		configMapFactory.PendingVolumes = pendingVolumes
+	}
+	requeue, result, err := reconciler.ensureK8sResource(ctx, customResource, configMapFactory)
+	if requeue || !initializedByJob {
+		return requeue, result, err
+	}
+
+	jobFactory := newJobFactoryForInitialization(reconciler, customResource)
+	jobFactory.Version = version
+	jobFactory.PendingVolumes = pendingVolumes
+	return reconciler.ensureInitializationJob(ctx, customResource, jobFactory)
+}
+
+// ensureInitializationJob stops the frontend and runs the Job initializing the pending volumes.
+// The volumes are marked once the Job has succeeded. A failed Job is kept, so that its pod can be
+// inspected, and the volumes stay pending until it is deleted.
+func (reconciler *ITAutomationAllInOneReconciler) ensureInitializationJob(ctx context.Context, customResource *itaallinonev1.ITAutomationAllInOne, jobFactory *JobFactoryForInitialization) (bool, ctrl.Result, error) {
+	k8sJob := &batchv1.Job{}
+	err := reconciler.Get(ctx, jobFactory.GetNamespaceName(), k8sJob)
+	jobFound := err == nil
+	if jobFound && (len(jobFactory.PendingVolumes) == 0 || !jobFactory.isCurrent(k8sJob)) {
+		return reconciler.deleteInitializationJob(ctx, customResource, k8sJob)
+	} else if err != nil && !errors.IsNotFound(err) {
+		reconciler.Log.Error(err, "Failed to get resource", k8sResourceToLogParameters(k8sJob)...)
+		return makeReturnValuesRequeueWithError(err)
+	}
+	if len(jobFactory.PendingVolumes) == 0 {
+		return makeReturnValuesContinue()
+	}
+
+	// The frontend releases the claims before the Job mounts them
+	frontendDeploymentFactory := newDeploymentFactoryForFrontend(reconciler, customResource)
+	frontendDeploymentFactory.Version = jobFactory.Version
+	frontendDeploymentFactory.Stopped = true
+	requeue, result, err := reconciler.ensureK8sResource(ctx, customResource, frontendDeploymentFactory)
+	if requeue {
+		return requeue, result, err
+	}
+
+	if !jobFound {
+		podsExist, err := hasFrontendPods(ctx, reconciler.Client, customResource)
+		if err != nil {
+			reconciler.Log.Error(err, "Failed to list pods", k8sResourceToLogParameters(customResource)...)
+			return makeReturnValuesRequeueWithError(err)
+		}
+		if podsExist {
+			return true, ctrl.Result{RequeueAfter: podTerminationRecheckInterval}, nil
+		}
+
+		return reconciler.ensureK8sResourceCreatedWithEvent(ctx, customResource, jobFactory)
+	}
+
+	switch {
+	case isJobSucceeded(k8sJob):
+		// The volumes are marked one by one, the Job stays current until all of them are
+		return reconciler.markVolumeInitializedByName(ctx, customResource, jobFactory.PendingVolumes[0])
+	case isJobFailed(k8sJob):
+		reconciler.Recorder.Eventf(customResource, corev1.EventTypeWarning, eventReasonInitializationFailed,
+			"Job %s failed: %s. Delete the Job to retry", k8sJob.Name, findJobCondition(k8sJob, batchv1.JobFailed).Message)
+		return makeReturnValuesStop()
+	default:
+		return makeReturnValuesStop()
+	}
+}
+
+// deleteInitializationJob deletes the Job together with its pod, which mounts the claims
+func (reconciler *ITAutomationAllInOneReconciler) deleteInitializationJob(ctx context.Context, customResource *itaallinonev1.ITAutomationAllInOne, k8sJob *batchv1.Job) (bool, ctrl.Result, error) {
+	if !metav1.IsControlledBy(k8sJob, customResource) {
+		return makeReturnValuesContinue()
+	}
+
+	reconciler.Log.Info("Deleting resource", k8sResourceToLogParameters(k8sJob)...)
+
+	err := reconciler.Delete(ctx, k8sJob, client.PropagationPolicy(metav1.DeletePropagationBackground))
+	if err != nil && !errors.IsNotFound(err) {
+		reconciler.Log.Error(err, "Failed to delete resource", k8sResourceToLogParameters(k8sJob)...)
+		return makeReturnValuesRequeueWithError(err)
+	}
+
+	return makeReturnValuesRequeue()
+}
+
+// ensureVolumesInitialized marks the volumes which the frontend has initialized when it started, for
+// images without ImageFeatureInitializationJob. The pod must have been created for the current requests
+// and passed its startup probe, which only succeeds once ITA serves the console from the volumes.
+func (reconciler *ITAutomationAllInOneReconciler) ensureVolumesInitialized(ctx context.Context, customResource *itaallinonev1.ITAutomationAllInOne) (bool, ctrl.Result, error) {
+	if itaallinonev1.HasImageFeature(customResource.Spec.Image, itaallinonev1.ImageFeatureInitializationJob) {
+		return makeReturnValuesContinue()
+	}
+
+	startedPod, err := findStartedPod(ctx, reconciler.Client, customResource)
+	if err != nil {
+		reconciler.Log.Error(err, "Failed to list pods", k8sResourceToLogParameters(customResource)...)
+		return makeReturnValuesRequeueWithError(err)
+	}
+	if startedPod == nil {
+		return makeReturnValuesContinue()
+	}
+
+	for _, volumeName := range instanceVolumeNames(customResource) {
+		annotation := reinitializeAnnotationPrefix + volumeName
+		if startedPod.Annotations[annotation] != customResource.Annotations[annotation] {
+			continue
+		}
+
+		k8sPvc := &corev1.PersistentVolumeClaim{}
+		err := reconciler.Get(ctx, types.NamespacedName{Namespace: customResource.Namespace, Name: volumeClaimName(customResource, volumeName)}, k8sPvc)
+		if errors.IsNotFound(err) {
+			continue
+		} else if err != nil {
+			reconciler.Log.Error(err, "Failed to get resource", k8sResourceToLogParameters(k8sPvc)...)
+			return makeReturnValuesRequeueWithError(err)
+		}
+
+		if needsInitialization(customResource, volumeName, k8sPvc) {
+			return reconciler.markVolumeInitialized(ctx, customResource, volumeName, k8sPvc)
+		}
+	}
+
+	return makeReturnValuesContinue()
+}
+
+func (reconciler *ITAutomationAllInOneReconciler) markVolumeInitializedByName(ctx context.Context, customResource *itaallinonev1.ITAutomationAllInOne, volumeName string) (bool, ctrl.Result, error) {
+	k8sPvc := &corev1.PersistentVolumeClaim{}
+	err := reconciler.Get(ctx, types.NamespacedName{Namespace: customResource.Namespace, Name: volumeClaimName(customResource, volumeName)}, k8sPvc)
+	if err != nil {
+		reconciler.Log.Error(err, "Failed to get resource", k8sResourceToLogParameters(k8sPvc)...)
+		return makeReturnValuesRequeueWithError(err)
+	}
+
+	return reconciler.markVolumeInitialized(ctx, customResource, volumeName, k8sPvc)
+}
+
+func (reconciler *ITAutomationAllInOneReconciler) markVolumeInitialized(ctx context.Context, customResource *itaallinonev1.ITAutomationAllInOne, volumeName string, k8sPvc *corev1.PersistentVolumeClaim) (bool, ctrl.Result, error) {
+	k8sPvc.Annotations = mergeStringMaps(k8sPvc.Annotations, map[string]string{
+		volumeInitializedAtAnnotation:  time.Now().UTC().Format(time.RFC3339),
+		volumeInitializationAnnotation: customResource.Annotations[reinitializeAnnotationPrefix+volumeName],
+	})
+
+	reconciler.Log.Info("Marking volume as initialized", k8sResourceToLogParameters(k8sPvc)...)
+
+	err := reconciler.Update(ctx, k8sPvc)
+	if err != nil {
+		if errors.IsConflict(err) {
+			reconciler.Log.Info("Resource was modified concurrently. Retrying", k8sResourceToLogParameters(k8sPvc)...)
+			return makeReturnValuesRequeue()
+		}
+
+		reconciler.Log.Error(err, "Failed to mark volume as initialized", k8sResourceToLogParameters(k8sPvc)...)
+		return makeReturnValuesRequeueWithError(err)
+	}
+
+	reconciler.Recorder.Eventf(customResource, corev1.EventTypeNormal, eventReasonVolumeInitialized, "Volume %s on PersistentVolumeClaim %s is initialized",
+		volumeName, k8sPvc.Name)
+	return makeReturnValuesRequeue()
+}
+
+// observeVolumeInitialization reports for each volume whether it is initialized
+func (reconciler *ITAutomationAllInOneReconciler) observeVolumeInitialization(ctx context.Context, customResource *itaallinonev1.ITAutomationAllInOne, status *itaallinonev1.ITAutomationAllInOneStatus) error {
+	conditionTypes := map[string]string{
+		fileVolumeName:     itaallinonev1.ConditionTypeFileVolumeInitialized,
+		databaseVolumeName: itaallinonev1.ConditionTypeDatabaseVolumeInitialized,
+	}
+
+	initializer := "the frontend when it starts"
+	var failedJob *batchv1.Job
+	if itaallinonev1.HasImageFeature(customResource.Spec.Image, itaallinonev1.ImageFeatureInitializationJob) {
+		jobFactory := newJobFactoryForInitialization(reconciler, customResource)
+		initializer = "Job " + jobFactory.GetName()
+
+		k8sJob := &batchv1.Job{}
+		err := reconciler.Get(ctx, jobFactory.GetNamespaceName(), k8sJob)
+		if err == nil && isJobFailed(k8sJob) {
+			failedJob = k8sJob
+		} else if err != nil && !errors.IsNotFound(err) {
+			return err
+		}
+	}
+
+	for _, volumeName := range instanceVolumeNames(customResource) {
+		conditionType := conditionTypes[volumeName]
+		claimName := volumeClaimName(customResource, volumeName)
+
+		k8sPvc := &corev1.PersistentVolumeClaim{}
+		err := reconciler.Get(ctx, types.NamespacedName{Namespace: customResource.Namespace, Name: claimName}, k8sPvc)
+		if errors.IsNotFound(err) {
+			setCondition(customResource, status, conditionType, metav1.ConditionFalse, "ClaimNotFound",
+				fmt.Sprintf("PersistentVolumeClaim %s is not found", claimName))
+			continue
+		} else if err != nil {
+			return err
+		}
+
+		initializedAt, marked := k8sPvc.Annotations[volumeInitializedAtAnnotation]
+		switch {
+		case failedJob != nil && needsInitialization(customResource, volumeName, k8sPvc):
+			setCondition(customResource, status, conditionType, metav1.ConditionFalse, "InitializationFailed",
+				fmt.Sprintf("Job %s failed: %s. Delete the Job to retry", failedJob.Name, findJobCondition(failedJob, batchv1.JobFailed).Message))
+		case !marked:
+			setCondition(customResource, status, conditionType, metav1.ConditionFalse, "Initializing",
+				fmt.Sprintf("Volume on PersistentVolumeClaim %s is initialized by %s", k8sPvc.Name, initializer))
+		case needsInitialization(customResource, volumeName, k8sPvc):
+			setCondition(customResource, status, conditionType, metav1.ConditionFalse, "ReinitializationRequested",
+				fmt.Sprintf("Volume on PersistentVolumeClaim %s is being initialized again on request by %s", k8sPvc.Name, initializer))
+		default:
+			setCondition(customResource, status, conditionType, metav1.ConditionTrue, "Initialized",
+				fmt.Sprintf("Volume on PersistentVolumeClaim %s was initialized at %s", k8sPvc.Name, initializedAt))
+		}
+	}
+
+	return nil
+}
+
+// findStartedPod returns a frontend pod of the instance whose ITA container has passed
+// its startup probe, or nil if there is none
+func findStartedPod(ctx context.Context, reader client.Reader, customResource *itaallinonev1.ITAutomationAllInOne) (*corev1.Pod, error) {
+	k8sPods := &corev1.PodList{}
+	err := reader.List(ctx, k8sPods, client.InNamespace(customResource.Namespace), client.MatchingLabels(createLabels(customResource)))
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range k8sPods.Items {
+		k8sPod := &k8sPods.Items[i]
+		if k8sPod.DeletionTimestamp != nil {
+			continue
+		}
+		for _, containerStatus := range k8sPod.Status.ContainerStatuses {
+			if containerStatus.Name == frontendContainerName && containerStatus.Started != nil && *containerStatus.Started {
+				return k8sPod, nil
+			}
+		}
+	}
+
+	return nil, nil
+}
@@ -0,0 +1,766 @@
+/*
+Copyright 2021 NEC Corporation.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/robfig/cron/v3"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	itaallinonev1 "github.com/exastro-suite/it-automation-operator/api/v1"
+)
+
+// Storage is not watched, so the status is refreshed periodically until it becomes ready.
+const storageRecheckInterval = 30 * time.Second
+
+// updateStatus derives the status of the custom resource from the resources it owns
+// and passes the given result of the reconciliation through.
+func (reconciler *ITAutomationAllInOneReconciler) updateStatus(ctx context.Context, customResource *itaallinonev1.ITAutomationAllInOne, result ctrl.Result, reconcileErr error) (ctrl.Result, error) {
+	return reconciler.updateStatusWithSkippedBackup(ctx, customResource, nil, result, reconcileErr)
+}
+
+// updateStatusWithSkippedBackup updates the status like updateStatus, recording the scheduled
+// time of a skipped backup if one is given.
+func (reconciler *ITAutomationAllInOneReconciler) updateStatusWithSkippedBackup(ctx context.Context, customResource *itaallinonev1.ITAutomationAllInOne, skippedBackupTime *metav1.Time, result ctrl.Result, reconcileErr error) (ctrl.Result, error) {
+	status := customResource.Status.DeepCopy()
+	status.ObservedGeneration = customResource.Generation
+
+	storageReady, err := reconciler.observeStorage(ctx, customResource, status)
+	if err != nil {
+		return reconciler.statusUpdateFailed(customResource, result, reconcileErr, err)
+	}
+
+	err = reconciler.observeVolumeInitialization(ctx, customResource, status)
+	if err != nil {
+		return reconciler.statusUpdateFailed(customResource, result, reconcileErr, err)
+	}
+
+	deploymentFactory := newDeploymentFactoryForFrontend(reconciler, customResource)
+	k8sDeployment := &appsv1.Deployment{}
+	err = reconciler.Get(ctx, deploymentFactory.GetNamespaceName(), k8sDeployment)
+	if errors.IsNotFound(err) {
+		k8sDeployment = nil
+	} else if err != nil {
+		return reconciler.statusUpdateFailed(customResource, result, reconcileErr, err)
+	}
+
+	available, progressing := observeDeployment(customResource, k8sDeployment, status)
+
+	err = reconciler.observeUpgrade(ctx, customResource, k8sDeployment, available && !progressing, status)
+	if err != nil {
+		return reconciler.statusUpdateFailed(customResource, result, reconcileErr, err)
+	}
+
+	observeAdmission(customResource, k8sDeployment, reconcileErr, status)
+	degraded := observeDegradation(customResource, k8sDeployment, reconcileErr, status)
+	paused := observePause(customResource, status)
+	suspended := observeSuspension(customResource, k8sDeployment, status)
+
+	terminating, err := reconciler.observeDeletion(ctx, customResource, status)
+	if err != nil {
+		return reconciler.statusUpdateFailed(customResource, result, reconcileErr, err)
+	}
+
+	switch {
+	case terminating:
+		status.Phase = itaallinonev1.PhaseTerminating
+	case paused:
+		status.Phase = itaallinonev1.PhasePaused
+	case degraded:
+		status.Phase = itaallinonev1.PhaseDegraded
+	case suspended:
+		status.Phase = itaallinonev1.PhaseSuspended
+	case available && !progressing:
+		status.Phase = itaallinonev1.PhaseRunning
+	case !storageReady || k8sDeployment == nil:
+		status.Phase = itaallinonev1.PhasePending
+	default:
+		status.Phase = itaallinonev1.PhaseDeploying
+	}
+
+	url, err := reconciler.observeURL(ctx, customResource)
+	if err != nil {
+		return reconciler.statusUpdateFailed(customResource, result, reconcileErr, err)
+	}
+	status.URL = url
+
+	databaseAddress, err := reconciler.observeDatabaseAddress(ctx, customResource)
+	if err != nil {
+		return reconciler.statusUpdateFailed(customResource, result, reconcileErr, err)
+	}
+	status.DatabaseAddress = databaseAddress
+
+	err = reconciler.observeBackups(ctx, customResource, skippedBackupTime, status)
+	if err != nil {
+		return reconciler.statusUpdateFailed(customResource, result, reconcileErr, err)
+	}
+
+	err = reconciler.observeCredentials(ctx, customResource, status)
+	if err != nil {
+		return reconciler.statusUpdateFailed(customResource, result, reconcileErr, err)
+	}
+
+	if !storageReady && reconcileErr == nil && !result.Requeue &&
+		(result.RequeueAfter == 0 || result.RequeueAfter > storageRecheckInterval) {
+		result.RequeueAfter = storageRecheckInterval
+	}
+
+	// Nothing changes when the rollout times out, so the reconciliation is scheduled for then
+	if deadline, found := rollbackDeadline(customResource, status.Upgrade); found && reconcileErr == nil && !result.Requeue {
+		remaining := time.Until(deadline) + time.Second
+		if remaining > 0 && (result.RequeueAfter == 0 || result.RequeueAfter > remaining) {
+			result.RequeueAfter = remaining
+		}
+	}
+
+	if equality.Semantic.DeepEqual(status, &customResource.Status) {
+		return result, reconcileErr
+	}
+
+	// Events are recorded once the status is stored, so that a retried update does not repeat them
+	previousUpgrade := customResource.Status.Upgrade
+	customResource.Status = *status
+	err = reconciler.Status().Update(ctx, customResource)
+	if err != nil {
+		if errors.IsConflict(err) {
+			reconciler.Log.Info("Custom resource was modified concurrently. Retrying status update", k8sResourceToLogParameters(customResource)...)
+			return ctrl.Result{Requeue: true}, reconcileErr
+		}
+		return reconciler.statusUpdateFailed(customResource, result, reconcileErr, err)
+	}
+
+	reconciler.recordUpgradeEvent(customResource, previousUpgrade)
+
+	return result, reconcileErr
+}
+
+func (reconciler *ITAutomationAllInOneReconciler) statusUpdateFailed(customResource *itaallinonev1.ITAutomationAllInOne, result ctrl.Result, reconcileErr error, err error) (ctrl.Result, error) {
+	reconciler.Log.Error(err, "Failed to update status", k8sResourceToLogParameters(customResource)...)
+	if reconcileErr != nil {
+		return result, reconcileErr
+	}
+	return result, err
+}
+
+func (reconciler *ITAutomationAllInOneReconciler) observeStorage(ctx context.Context, customResource *itaallinonev1.ITAutomationAllInOne, status *itaallinonev1.ITAutomationAllInOneStatus) (bool, error) {
+	var notReady []string
+	reason := "ClaimsBound"
+	for _, claimName := range customResource.GetPvcNames() {
+		k8sPvc := &corev1.PersistentVolumeClaim{}
+		err := reconciler.Get(ctx, types.NamespacedName{Namespace: customResource.Namespace, Name: claimName}, k8sPvc)
+		if errors.IsNotFound(err) {
+			notReady = append(notReady, fmt.Sprintf("%s is not found", claimName))
+			reason = "ClaimNotFound"
+			continue
+		} else if err != nil {
+			return false, err
+		}
+
+		if k8sPvc.Status.Phase != corev1.ClaimBound {
+			notReady = append(notReady, fmt.Sprintf("%s is %s", claimName, k8sPvc.Status.Phase))
+			if reason != "ClaimNotFound" {
+				reason = "ClaimNotBound"
+			}
+		}
+	}
+
+	if len(notReady) > 0 {
+		setCondition(customResource, status, itaallinonev1.ConditionTypeStorageReady, metav1.ConditionFalse, reason,
+			"PersistentVolumeClaims are not ready: "+strings.Join(notReady, ", "))
+		return false, nil
+	}
+
+	setCondition(customResource, status, itaallinonev1.ConditionTypeStorageReady, metav1.ConditionTrue, reason,
+		"All PersistentVolumeClaims are bound")
+	return true, nil
+}
+
+func observeDeployment(customResource *itaallinonev1.ITAutomationAllInOne, k8sDeployment *appsv1.Deployment, status *itaallinonev1.ITAutomationAllInOneStatus) (bool, bool) {
+	if k8sDeployment == nil {
+		setCondition(customResource, status, itaallinonev1.ConditionTypeAvailable, metav1.ConditionFalse, "DeploymentNotFound",
+			"Frontend Deployment has not been created yet")
+		setCondition(customResource, status, itaallinonev1.ConditionTypeProgressing, metav1.ConditionTrue, "Creating",
+			"Frontend Deployment is being created")
+		return false, true
+	}
+
+	replicas := int32(1)
+	if k8sDeployment.Spec.Replicas != nil {
+		replicas = *k8sDeployment.Spec.Replicas
+	}
+	deploymentStatus := k8sDeployment.Status
+
+	available := deploymentStatus.AvailableReplicas >= replicas && replicas > 0
+	if available {
+		setCondition(customResource, status, itaallinonev1.ConditionTypeAvailable, metav1.ConditionTrue, "MinimumReplicasAvailable",
+			fmt.Sprintf("%d of %d replicas are available", deploymentStatus.AvailableReplicas, replicas))
+	} else {
+		setCondition(customResource, status, itaallinonev1.ConditionTypeAvailable, metav1.ConditionFalse, "MinimumReplicasUnavailable",
+			fmt.Sprintf("%d of %d replicas are available", deploymentStatus.AvailableReplicas, replicas))
+	}
+
+	progressing := deploymentStatus.ObservedGeneration < k8sDeployment.Generation ||
+		deploymentStatus.UpdatedReplicas < replicas ||
+		deploymentStatus.Replicas > deploymentStatus.UpdatedReplicas ||
+		deploymentStatus.AvailableReplicas < replicas
+	if progressing {
+		setCondition(customResource, status, itaallinonev1.ConditionTypeProgressing, metav1.ConditionTrue, "RollingOut",
+			fmt.Sprintf("%d of %d replicas are updated", deploymentStatus.UpdatedReplicas, replicas))
+	} else {
+		setCondition(customResource, status, itaallinonev1.ConditionTypeProgressing, metav1.ConditionFalse, "RolloutComplete",
+			"Frontend Deployment is fully rolled out")
+
+		for _, container := range k8sDeployment.Spec.Template.Spec.Containers {
+			if container.Name == frontendContainerName {
+				status.Image = container.Image
+			}
+		}
+		status.Version = k8sDeployment.Spec.Template.Labels[versionLabel]
+	}
+
+	return available, progressing
+}
+
+func observeDegradation(customResource *itaallinonev1.ITAutomationAllInOne, k8sDeployment *appsv1.Deployment, reconcileErr error, status *itaallinonev1.ITAutomationAllInOneStatus) bool {
+	if reconcileErr != nil {
+		setCondition(customResource, status, itaallinonev1.ConditionTypeDegraded, metav1.ConditionTrue, "ReconcileError", reconcileErr.Error())
+		return true
+	}
+
+	if upgrade := status.Upgrade; upgrade != nil && upgrade.ToVersion == customResource.Spec.Version && upgrade.RestoreName != "" {
+		setCondition(customResource, status, itaallinonev1.ConditionTypeDegraded, metav1.ConditionTrue, "UpgradeRolledBack", upgrade.Message)
+		return true
+	}
+
+	if k8sDeployment != nil {
+		for _, condition := range k8sDeployment.Status.Conditions {
+			if condition.Type == appsv1.DeploymentProgressing && condition.Status == corev1.ConditionFalse {
+				setCondition(customResource, status, itaallinonev1.ConditionTypeDegraded, metav1.ConditionTrue, condition.Reason, condition.Message)
+				return true
+			}
+			if condition.Type == appsv1.DeploymentReplicaFailure && condition.Status == corev1.ConditionTrue {
+				setCondition(customResource, status, itaallinonev1.ConditionTypeDegraded, metav1.ConditionTrue, condition.Reason, condition.Message)
+				return true
+			}
+		}
+	}
+
+	setCondition(customResource, status, itaallinonev1.ConditionTypeDegraded, metav1.ConditionFalse, "AsExpected",
+		"Instance is reconciled as expected")
+	return false
+}
+
+func observePause(customResource *itaallinonev1.ITAutomationAllInOne, status *itaallinonev1.ITAutomationAllInOneStatus) bool {
+	if customResource.Spec.Paused && customResource.DeletionTimestamp.IsZero() {
+		setCondition(customResource, status, itaallinonev1.ConditionTypePaused, metav1.ConditionTrue, "Paused",
+			"Reconciliation is paused, so the resources of the instance are not updated")
+		return true
+	}
+
+	setCondition(customResource, status, itaallinonev1.ConditionTypePaused, metav1.ConditionFalse, "NotPaused",
+		"Resources of the instance are reconciled")
+	return false
+}
+
+// observeSuspension reports whether the suspended frontend has scaled down. The phase is Suspended
+// as soon as the instance is suspended, so that it is not reported as degraded while scaling down.
+func observeSuspension(customResource *itaallinonev1.ITAutomationAllInOne, k8sDeployment *appsv1.Deployment, status *itaallinonev1.ITAutomationAllInOneStatus) bool {
+	if !customResource.Spec.Suspended {
+		setCondition(customResource, status, itaallinonev1.ConditionTypeSuspended, metav1.ConditionFalse, "NotSuspended",
+			"Instance is not suspended")
+		return false
+	}
+
+	if k8sDeployment != nil && (k8sDeployment.Status.Replicas > 0 || k8sDeployment.Spec.Replicas == nil || *k8sDeployment.Spec.Replicas > 0) {
+		setCondition(customResource, status, itaallinonev1.ConditionTypeSuspended, metav1.ConditionFalse, "ScalingDown",
+			fmt.Sprintf("Frontend is scaling down, %d replicas are left", k8sDeployment.Status.Replicas))
+		return true
+	}
+
+	setCondition(customResource, status, itaallinonev1.ConditionTypeSuspended, metav1.ConditionTrue, "ScaledDown",
+		"Frontend is scaled to zero, and the claims and the Service are kept")
+	return true
+}
+
+// observeAdmission surfaces rejections of the resources themselves and of the pods
+// the ReplicaSet fails to create, e.g. because of Pod Security Admission.
+func observeAdmission(customResource *itaallinonev1.ITAutomationAllInOne, k8sDeployment *appsv1.Deployment, reconcileErr error, status *itaallinonev1.ITAutomationAllInOneStatus) {
+	if reconcileErr != nil && (errors.IsForbidden(reconcileErr) || errors.IsInvalid(reconcileErr)) {
+		setCondition(customResource, status, itaallinonev1.ConditionTypeAdmitted, metav1.ConditionFalse, "AdmissionRejected", reconcileErr.Error())
+		return
+	}
+
+	if k8sDeployment != nil {
+		for _, condition := range k8sDeployment.Status.Conditions {
+			if condition.Type == appsv1.DeploymentReplicaFailure && condition.Status == corev1.ConditionTrue && condition.Reason == "FailedCreate" {
+				setCondition(customResource, status, itaallinonev1.ConditionTypeAdmitted, metav1.ConditionFalse, "AdmissionRejected", condition.Message)
+				return
+			}
+		}
+	}
+
+	setCondition(customResource, status, itaallinonev1.ConditionTypeAdmitted, metav1.ConditionTrue, "Admitted",
+		"Resources and pods are admitted")
+}
+
+// observeDeletion reports the progress of the deletion policy while the custom resource is being deleted
+func (reconciler *ITAutomationAllInOneReconciler) observeDeletion(ctx context.Context, customResource *itaallinonev1.ITAutomationAllInOne, status *itaallinonev1.ITAutomationAllInOneStatus) (bool, error) {
+	if customResource.DeletionTimestamp.IsZero() {
+		meta.RemoveStatusCondition(&status.Conditions, itaallinonev1.ConditionTypeDeletionPolicyApplied)
+		meta.RemoveStatusCondition(&status.Conditions, itaallinonev1.ConditionTypeDeletionBlocked)
+		return false, nil
+	}
+
+	plan, err := reconciler.planDeletion(ctx, customResource)
+	if err != nil {
+		return true, err
+	}
+
+	if plan.MissingBackupClaim != "" {
+		message := fmt.Sprintf("PersistentVolumeClaim %s for the final backup is not found", plan.MissingBackupClaim)
+		setCondition(customResource, status, itaallinonev1.ConditionTypeDeletionPolicyApplied, metav1.ConditionFalse, "BackupClaimNotFound", message)
+		setCondition(customResource, status, itaallinonev1.ConditionTypeDeletionBlocked, metav1.ConditionTrue, "BackupClaimNotFound", message)
+		return true, nil
+	}
+
+	if plan.JobFactory == nil {
+		message := "Volumes are retained"
+		if plan.MissingClaim != "" {
+			message = fmt.Sprintf("PersistentVolumeClaim %s is gone, the remaining data is retained", plan.MissingClaim)
+		}
+		setCondition(customResource, status, itaallinonev1.ConditionTypeDeletionPolicyApplied, metav1.ConditionTrue, "DataRetained", message)
+		setCondition(customResource, status, itaallinonev1.ConditionTypeDeletionBlocked, metav1.ConditionFalse, "DataRetained", message)
+		return true, nil
+	}
+
+	k8sJob := &batchv1.Job{}
+	err = reconciler.Get(ctx, plan.JobFactory.GetNamespaceName(), k8sJob)
+	if errors.IsNotFound(err) {
+		message := "Waiting for the instance to stop before applying the " + string(customResource.GetDeletionPolicy()) + " policy"
+		setCondition(customResource, status, itaallinonev1.ConditionTypeDeletionPolicyApplied, metav1.ConditionFalse, "StoppingInstance", message)
+		setCondition(customResource, status, itaallinonev1.ConditionTypeDeletionBlocked, metav1.ConditionFalse, "StoppingInstance", message)
+		return true, nil
+	} else if err != nil {
+		return true, err
+	}
+
+	switch {
+	case isJobSucceeded(k8sJob):
+		message := fmt.Sprintf("Job %s succeeded", k8sJob.Name)
+		setCondition(customResource, status, itaallinonev1.ConditionTypeDeletionPolicyApplied, metav1.ConditionTrue, "JobSucceeded", message)
+		setCondition(customResource, status, itaallinonev1.ConditionTypeDeletionBlocked, metav1.ConditionFalse, "JobSucceeded", message)
+	case isJobFailed(k8sJob):
+		message := fmt.Sprintf("Job %s failed: %s. Delete the Job to retry", k8sJob.Name, findJobCondition(k8sJob, batchv1.JobFailed).Message)
+		setCondition(customResource, status, itaallinonev1.ConditionTypeDeletionPolicyApplied, metav1.ConditionFalse, "JobFailed", message)
+		setCondition(customResource, status, itaallinonev1.ConditionTypeDeletionBlocked, metav1.ConditionTrue, "JobFailed", message)
+	default:
+		message := fmt.Sprintf("Job %s is running", k8sJob.Name)
+		setCondition(customResource, status, itaallinonev1.ConditionTypeDeletionPolicyApplied, metav1.ConditionFalse, "JobRunning", message)
+		setCondition(customResource, status, itaallinonev1.ConditionTypeDeletionBlocked, metav1.ConditionFalse, "JobRunning", message)
+	}
+
+	return true, nil
+}
+
+// observeUpgrade reports the progress of the most recent change of spec.version. It mirrors
+// the decisions of resolveDeploymentVersion, and keeps the record once the upgrade succeeded.
+func (reconciler *ITAutomationAllInOneReconciler) observeUpgrade(ctx context.Context, customResource *itaallinonev1.ITAutomationAllInOne, k8sDeployment *appsv1.Deployment, rolledOut bool, status *itaallinonev1.ITAutomationAllInOneStatus) error {
+	running := customResource.Status.Version
+	desired := customResource.Spec.Version
+
+	upgrade := status.Upgrade
+	var previousPhase itaallinonev1.UpgradePhase
+	if upgrade != nil && upgrade.ToVersion == desired {
+		previousPhase = upgrade.Phase
+	}
+	if upgrade == nil || upgrade.ToVersion != desired {
+		if running == "" || running == desired {
+			// An upgrade in progress is dropped when the version is reverted
+			if upgrade != nil && !upgrade.IsFinished() {
+				status.Upgrade = nil
+			}
+			return nil
+		}
+		now := metav1.Now()
+		upgrade = &itaallinonev1.UpgradeStatus{FromVersion: running, ToVersion: desired, StartTime: &now}
+	}
+	if upgrade.Phase == itaallinonev1.UpgradePhaseSucceeded {
+		return nil
+	}
+
+	deployedVersion := ""
+	if k8sDeployment != nil {
+		deployedVersion = k8sDeployment.Spec.Template.Labels[versionLabel]
+	}
+
+	rollbackFactory := newRestoreFactoryForRollback(reconciler, customResource)
+	rollback := &itaallinonev1.ITAutomationRestore{}
+	err := reconciler.Get(ctx, rollbackFactory.GetNamespaceName(), rollback)
+	if errors.IsNotFound(err) {
+		rollback = nil
+	} else if err != nil {
+		return err
+	}
+
+	upgrade.RestoreName = ""
+	upgradeErr := itaallinonev1.CheckUpgradePath(upgrade.FromVersion, desired)
+	switch {
+	case rollback != nil:
+		upgrade.RestoreName = rollback.Name
+		timedOut := fmt.Sprintf("Version %s did not become ready within %s", desired, customResource.GetUpgradeTimeout())
+		switch rollback.Status.Phase {
+		case itaallinonev1.RestorePhaseSucceeded:
+			finishUpgrade(upgrade, itaallinonev1.UpgradePhaseRolledBack,
+				fmt.Sprintf("%s, so version %s and its data are restored. Delete ITAutomationRestore %s to retry", timedOut, upgrade.FromVersion, rollback.Name))
+		case itaallinonev1.RestorePhaseFailed:
+			finishUpgrade(upgrade, itaallinonev1.UpgradePhaseFailed,
+				fmt.Sprintf("%s, and rolling back to version %s failed. See ITAutomationRestore %s", timedOut, upgrade.FromVersion, rollback.Name))
+		default:
+			setUpgradePhase(upgrade, itaallinonev1.UpgradePhaseRollingBack,
+				fmt.Sprintf("%s. Rolling back to version %s", timedOut, upgrade.FromVersion))
+		}
+	case deployedVersion == desired && rolledOut:
+		finishUpgrade(upgrade, itaallinonev1.UpgradePhaseSucceeded, fmt.Sprintf("Version %s is rolled out", desired))
+	case deployedVersion == desired:
+		setUpgradePhase(upgrade, itaallinonev1.UpgradePhaseRollingOut, fmt.Sprintf("Waiting for version %s to become ready", desired))
+	case upgradeErr != nil:
+		finishUpgrade(upgrade, itaallinonev1.UpgradePhaseRejected, upgradeErr.Error())
+	case customResource.Spec.Upgrade != nil && customResource.Spec.Upgrade.SkipBackup:
+		setUpgradePhase(upgrade, itaallinonev1.UpgradePhaseRollingOut, fmt.Sprintf("Rolling out version %s without a backup", desired))
+	case customResource.GetUpgradeBackupStorage() == nil:
+		finishUpgrade(upgrade, itaallinonev1.UpgradePhaseRejected, "No storage is configured for the pre-upgrade backup")
+	default:
+		backupFactory := newBackupFactoryForUpgrade(reconciler, customResource)
+		upgrade.BackupName = backupFactory.GetName()
+
+		backup := &itaallinonev1.ITAutomationBackup{}
+		err = reconciler.Get(ctx, backupFactory.GetNamespaceName(), backup)
+		if err != nil && !errors.IsNotFound(err) {
+			return err
+		}
+
+		switch {
+		case err == nil && backup.Status.Phase == itaallinonev1.BackupPhaseSucceeded:
+			setUpgradePhase(upgrade, itaallinonev1.UpgradePhaseRollingOut, fmt.Sprintf("Rolling out version %s", desired))
+		case err == nil && backup.Status.Phase == itaallinonev1.BackupPhaseFailed:
+			finishUpgrade(upgrade, itaallinonev1.UpgradePhaseFailed,
+				fmt.Sprintf("ITAutomationBackup %s failed, so version %s is kept. Delete the backup to retry", backup.Name, upgrade.FromVersion))
+		default:
+			setUpgradePhase(upgrade, itaallinonev1.UpgradePhaseBackingUp, fmt.Sprintf("Waiting for ITAutomationBackup %s", upgrade.BackupName))
+		}
+	}
+
+	status.Upgrade = upgrade
+
+	if upgrade.IsFinished() && upgrade.Phase != previousPhase {
+		status.UpgradeHistory = append(status.UpgradeHistory, *upgrade.DeepCopy())
+		if len(status.UpgradeHistory) > upgradeHistoryLimit {
+			status.UpgradeHistory = status.UpgradeHistory[len(status.UpgradeHistory)-upgradeHistoryLimit:]
+		}
+	}
+
+	return nil
+}
+
+func setUpgradePhase(upgrade *itaallinonev1.UpgradeStatus, phase itaallinonev1.UpgradePhase, message string) {
+	if phase == itaallinonev1.UpgradePhaseRollingOut && upgrade.Phase != phase {
+		now := metav1.Now()
+		upgrade.RolloutStartTime = &now
+	}
+	upgrade.Phase = phase
+	upgrade.Message = message
+	upgrade.CompletionTime = nil
+}
+
+func finishUpgrade(upgrade *itaallinonev1.UpgradeStatus, phase itaallinonev1.UpgradePhase, message string) {
+	if upgrade.Phase != phase || upgrade.CompletionTime == nil {
+		now := metav1.Now()
+		upgrade.CompletionTime = &now
+	}
+	upgrade.Phase = phase
+	upgrade.Message = message
+}
+
+// observeBackups reports the most recent succeeded backup of the instance, including the
+// ones taken by hand, the most recent skipped scheduled backup and when the next one is taken
+func (reconciler *ITAutomationAllInOneReconciler) observeBackups(ctx context.Context, customResource *itaallinonev1.ITAutomationAllInOne, skippedBackupTime *metav1.Time, status *itaallinonev1.ITAutomationAllInOneStatus) error {
+	backupList := &itaallinonev1.ITAutomationBackupList{}
+	err := reconciler.List(ctx, backupList, client.InNamespace(customResource.Namespace))
+	if err != nil {
+		return err
+	}
+
+	status.LastSuccessfulBackupTime = nil
+	for _, backup := range backupList.Items {
+		if backup.Spec.InstanceName != customResource.Name || backup.Status.Phase != itaallinonev1.BackupPhaseSucceeded || backup.Status.CompletionTime == nil {
+			continue
+		}
+		if status.LastSuccessfulBackupTime == nil || status.LastSuccessfulBackupTime.Before(backup.Status.CompletionTime) {
+			status.LastSuccessfulBackupTime = backup.Status.CompletionTime.DeepCopy()
+		}
+	}
+
+	if customResource.Spec.BackupSchedule == nil {
+		status.LastSkippedBackupTime = nil
+	} else if skippedBackupTime != nil {
+		status.LastSkippedBackupTime = skippedBackupTime
+	}
+
+	status.NextBackupTime = nil
+	if backupSchedule := customResource.Spec.BackupSchedule; backupSchedule != nil && customResource.DeletionTimestamp.IsZero() &&
+		!customResource.Spec.Paused && !customResource.Spec.Suspended {
+		schedule, err := cron.ParseStandard(backupSchedule.Schedule)
+		if err == nil {
+			next := metav1.NewTime(schedule.Next(time.Now()))
+			status.NextBackupTime = &next
+		}
+	}
+
+	return nil
+}
+
+// observeCredentials refers to the Secret of the passwords once it exists
+func (reconciler *ITAutomationAllInOneReconciler) observeCredentials(ctx context.Context, customResource *itaallinonev1.ITAutomationAllInOne, status *itaallinonev1.ITAutomationAllInOneStatus) error {
+	k8sSecret := &corev1.Secret{}
+	err := reconciler.Get(ctx, types.NamespacedName{Namespace: customResource.Namespace, Name: credentialsSecretName(customResource)}, k8sSecret)
+	if errors.IsNotFound(err) {
+		status.Credentials = nil
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	status.Credentials = &itaallinonev1.CredentialsStatus{
+		SecretName: k8sSecret.Name,
+		Generated:  k8sSecret.Annotations[credentialsGeneratedAnnotation] == "true",
+	}
+
+	return nil
+}
+
+// observeDatabaseAddress returns the address of the database Service once it is created
+func (reconciler *ITAutomationAllInOneReconciler) observeDatabaseAddress(ctx context.Context, customResource *itaallinonev1.ITAutomationAllInOne) (string, error) {
+	if customResource.GetDatabaseService() == nil {
+		return "", nil
+	}
+
+	serviceFactory := newServiceFactoryForDatabase(reconciler, customResource)
+	err := reconciler.Get(ctx, serviceFactory.GetNamespaceName(), &corev1.Service{})
+	if errors.IsNotFound(err) {
+		return "", nil
+	} else if err != nil {
+		return "", err
+	}
+
+	return databaseServiceAddress(customResource), nil
+}
+
+// observeURL returns the address of the Route or the Ingress, or of the frontend
+// Service preferring the one reachable from outside of the cluster.
+func (reconciler *ITAutomationAllInOneReconciler) observeURL(ctx context.Context, customResource *itaallinonev1.ITAutomationAllInOne) (string, error) {
+	if reconciler.RouteAvailable && isRouteEnabled(customResource) {
+		routeFactory := newRouteFactoryForFrontend(reconciler, customResource)
+		k8sRoute := newRoute()
+		err := reconciler.Get(ctx, routeFactory.GetNamespaceName(), k8sRoute)
+		if err != nil && !errors.IsNotFound(err) {
+			return "", err
+		}
+		if err == nil {
+			host, _, _ := unstructured.NestedString(k8sRoute.Object, "spec", "host")
+			_, tls, _ := unstructured.NestedMap(k8sRoute.Object, "spec", "tls")
+			if host != "" {
+				scheme := "http"
+				if tls {
+					scheme = "https"
+				}
+				return fmt.Sprintf("%s://%s", scheme, host), nil
+			}
+		}
+	}
+
+	var ingressName types.NamespacedName
+	if customResource.Spec.Ingress != nil {
+		ingressName = newIngressFactoryForFrontend(reconciler, customResource).GetNamespaceName()
+	}
+	serviceName := newServiceFactoryForFrontend(reconciler, customResource).GetNamespaceName()
+
+	return observeWebConsoleURL(ctx, reconciler.Client, ingressName, serviceName)
+}
+
+// observeWebConsoleURL returns the address of the Ingress if a name is given, or of the
+// Service preferring the one reachable from outside of the cluster
+func observeWebConsoleURL(ctx context.Context, reader client.Reader, ingressName types.NamespacedName, serviceName types.NamespacedName) (string, error) {
+	if ingressName.Name != "" {
+		k8sIngress := &networkingv1.Ingress{}
+		err := reader.Get(ctx, ingressName, k8sIngress)
+		if err != nil && !errors.IsNotFound(err) {
+			return "", err
+		}
+		if err == nil {
+			if url := ingressURL(k8sIngress); url != "" {
+				return url, nil
+			}
+		}
+	}
+
+	k8sService := &corev1.Service{}
+	err := reader.Get(ctx, serviceName, k8sService)
+	if errors.IsNotFound(err) {
+		return "", nil
+	} else if err != nil {
+		return "", err
+	}
+
+	var port corev1.ServicePort
+	for _, servicePort := range k8sService.Spec.Ports {
+		if servicePort.Name == "http" {
+			port = servicePort
+		}
+	}
+
+	switch k8sService.Spec.Type {
+	case corev1.ServiceTypeLoadBalancer:
+		for _, ingress := range k8sService.Status.LoadBalancer.Ingress {
+			if ingress.Hostname != "" {
+				return fmt.Sprintf("http://%s:%d", ingress.Hostname, port.Port), nil
+			}
+			if ingress.IP != "" {
+				return fmt.Sprintf("http://%s:%d", ingress.IP, port.Port), nil
+			}
+		}
+		fallthrough
+	case corev1.ServiceTypeNodePort:
+		if port.NodePort != 0 {
+			nodeAddress, err := findNodeAddress(ctx, reader)
+			if err != nil {
+				return "", err
+			}
+			if nodeAddress != "" {
+				return fmt.Sprintf("http://%s:%d", nodeAddress, port.NodePort), nil
+			}
+		}
+	}
+
+	return fmt.Sprintf("http://%s.%s.svc:%d", k8sService.Name, k8sService.Namespace, port.Port), nil
+}
+
+// ingressURL returns the address of the first path of the Ingress, or an empty string
+// if it has none, e.g. after it was edited by hand
+func ingressURL(k8sIngress *networkingv1.Ingress) string {
+	if len(k8sIngress.Spec.Rules) == 0 {
+		return ""
+	}
+	rule := k8sIngress.Spec.Rules[0]
+	if rule.Host == "" || rule.HTTP == nil || len(rule.HTTP.Paths) == 0 {
+		return ""
+	}
+
+	scheme := "http"
+	if len(k8sIngress.Spec.TLS) > 0 {
+		scheme = "https"
+	}
+	return fmt.Sprintf("%s://%s%s", scheme, rule.Host, rule.HTTP.Paths[0].Path)
+}
+
+// findNodeAddress returns the address of a node the node port is reachable at
+func findNodeAddress(ctx context.Context, reader client.Reader) (string, error) {
+	k8sNodes := &corev1.NodeList{}
+	err := reader.List(ctx, k8sNodes)
+	if err != nil {
+		return "", err
+	}
+
+	return selectNodeAddress(k8sNodes.Items), nil
+}
+
+// selectNodeAddress prefers the external address of ready nodes workloads are scheduled on, e.g. not
+// of control-plane nodes. Nodes are taken by name, so that the address does not change between reconciles.
+func selectNodeAddress(k8sNodes []corev1.Node) string {
+	sortedK8sNodes := append([]corev1.Node(nil), k8sNodes...)
+	sort.Slice(sortedK8sNodes, func(i, j int) bool {
+		return sortedK8sNodes[i].Name < sortedK8sNodes[j].Name
+	})
+
+	var preferredK8sNodes []corev1.Node
+	for _, k8sNode := range sortedK8sNodes {
+		if isNodeReady(&k8sNode) && isNodeSchedulable(&k8sNode) {
+			preferredK8sNodes = append(preferredK8sNodes, k8sNode)
+		}
+	}
+
+	for _, candidates := range [][]corev1.Node{preferredK8sNodes, sortedK8sNodes} {
+		for _, addressType := range []corev1.NodeAddressType{corev1.NodeExternalIP, corev1.NodeInternalIP} {
+			for _, k8sNode := range candidates {
+				for _, address := range k8sNode.Status.Addresses {
+					if address.Type == addressType {
+						return address.Address
+					}
+				}
+			}
+		}
+	}
+
+	return ""
+}
+
+func isNodeReady(k8sNode *corev1.Node) bool {
+	for _, condition := range k8sNode.Status.Conditions {
+		if condition.Type == corev1.NodeReady {
+			return condition.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
+
+// isNodeSchedulable reports whether pods without tolerations are scheduled on the node
+func isNodeSchedulable(k8sNode *corev1.Node) bool {
+	if k8sNode.Spec.Unschedulable {
+		return false
+	}
+	for _, taint := range k8sNode.Spec.Taints {
+		if taint.Effect == corev1.TaintEffectNoSchedule || taint.Effect == corev1.TaintEffectNoExecute {
+			return false
+		}
+	}
+	return true
+}
+
+func setCondition(customResource *itaallinonev1.ITAutomationAllInOne, status *itaallinonev1.ITAutomationAllInOneStatus, conditionType string, conditionStatus metav1.ConditionStatus, reason string, message string) {
+	meta.SetStatusCondition(&status.Conditions, metav1.Condition{
+		Type:               conditionType,
+		Status:             conditionStatus,
+		ObservedGeneration: customResource.Generation,
+		Reason:             reason,
+		Message:            message,
+	})
+}
@@ -0,0 +1,381 @@
+/*
+Copyright 2021 NEC Corporation.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/go-logr/logr"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	itaallinonev1 "github.com/exastro-suite/it-automation-operator/api/v1"
+)
+
+func TestIngressURL(t *testing.T) {
+	tests := []struct {
+		name string
+		spec networkingv1.IngressSpec
+		want string
+	}{
+		{
+			name: "generated Ingress",
+			spec: createIngressSpec(&itaallinonev1.IngressSpec{Host: "ita.example.com", Path: "/ita"}, "ita-frontend"),
+			want: "http://ita.example.com/ita",
+		},
+		{
+			name: "TLS",
+			spec: createIngressSpec(&itaallinonev1.IngressSpec{Host: "ita.example.com", TLSSecretName: "ita-tls"}, "ita-frontend"),
+			want: "https://ita.example.com/",
+		},
+		{
+			name: "no rules",
+			spec: networkingv1.IngressSpec{DefaultBackend: &networkingv1.IngressBackend{}},
+		},
+		{
+			name: "rule without HTTP",
+			spec: networkingv1.IngressSpec{Rules: []networkingv1.IngressRule{{Host: "ita.example.com"}}},
+		},
+		{
+			name: "rule without paths",
+			spec: networkingv1.IngressSpec{Rules: []networkingv1.IngressRule{{
+				Host:             "ita.example.com",
+				IngressRuleValue: networkingv1.IngressRuleValue{HTTP: &networkingv1.HTTPIngressRuleValue{}},
+			}}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ingressURL(&networkingv1.Ingress{Spec: tt.spec}); got != tt.want {
+				t.Errorf("ingressURL() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func newTestNode(name string, ready bool, unschedulable bool, taints []corev1.Taint, addresses ...corev1.NodeAddress) corev1.Node {
+	readyStatus := corev1.ConditionFalse
+	if ready {
+		readyStatus = corev1.ConditionTrue
+	}
+	return corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Spec:       corev1.NodeSpec{Unschedulable: unschedulable, Taints: taints},
+		Status: corev1.NodeStatus{
+			Conditions: []corev1.NodeCondition{{Type: corev1.NodeReady, Status: readyStatus}},
+			Addresses:  addresses,
+		},
+	}
+}
+
+func TestSelectNodeAddress(t *testing.T) {
+	internal := func(address string) corev1.NodeAddress {
+		return corev1.NodeAddress{Type: corev1.NodeInternalIP, Address: address}
+	}
+	external := func(address string) corev1.NodeAddress {
+		return corev1.NodeAddress{Type: corev1.NodeExternalIP, Address: address}
+	}
+	controlPlaneTaint := []corev1.Taint{{Key: "node-role.kubernetes.io/master", Effect: corev1.TaintEffectNoSchedule}}
+
+	tests := []struct {
+		name  string
+		nodes []corev1.Node
+		want  string
+	}{
+		{
+			name: "nodes are taken by name",
+			nodes: []corev1.Node{
+				newTestNode("worker-2", true, false, nil, internal("10.0.0.2")),
+				newTestNode("worker-1", true, false, nil, internal("10.0.0.1")),
+			},
+			want: "10.0.0.1",
+		},
+		{
+			name: "external address is preferred",
+			nodes: []corev1.Node{
+				newTestNode("worker-1", true, false, nil, internal("10.0.0.1")),
+				newTestNode("worker-2", true, false, nil, internal("10.0.0.2"), external("192.0.2.2")),
+			},
+			want: "192.0.2.2",
+		},
+		{
+			name: "control-plane, cordoned and not ready nodes are skipped",
+			nodes: []corev1.Node{
+				newTestNode("a-master", true, false, controlPlaneTaint, external("192.0.2.1")),
+				newTestNode("b-cordoned", true, true, nil, external("192.0.2.2")),
+				newTestNode("c-not-ready", false, false, nil, external("192.0.2.3")),
+				newTestNode("d-worker", true, false, nil, internal("10.0.0.4")),
+			},
+			want: "10.0.0.4",
+		},
+		{
+			name: "any node without a preferred one",
+			nodes: []corev1.Node{
+				newTestNode("master", true, false, controlPlaneTaint, internal("10.0.0.1")),
+			},
+			want: "10.0.0.1",
+		},
+		{
+			name: "no nodes",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := selectNodeAddress(tt.nodes); got != tt.want {
+				t.Errorf("selectNodeAddress() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestObserveUpgrade(t *testing.T) {
+	archive := &itaallinonev1.BackupStorage{Target: &itaallinonev1.BackupTarget{PvcName: "backups"}}
+	upgradeBackup := func(phase itaallinonev1.ITAutomationBackupPhase) *itaallinonev1.ITAutomationBackup {
+		return &itaallinonev1.ITAutomationBackup{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "ita-upgrade-1-9-1"},
+			Status: itaallinonev1.ITAutomationBackupStatus{Phase: phase}}
+	}
+	rollback := func(phase itaallinonev1.ITAutomationRestorePhase) *itaallinonev1.ITAutomationRestore {
+		return &itaallinonev1.ITAutomationRestore{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "ita-rollback-1-9-1"},
+			Status: itaallinonev1.ITAutomationRestoreStatus{Phase: phase}}
+	}
+	upgradeIn := func(phase itaallinonev1.UpgradePhase) *itaallinonev1.UpgradeStatus {
+		startTime := metav1.Now()
+		return &itaallinonev1.UpgradeStatus{FromVersion: "1.9.0", ToVersion: "1.9.1", Phase: phase, StartTime: &startTime, RolloutStartTime: &startTime}
+	}
+	var fullHistory []itaallinonev1.UpgradeStatus
+	for i := 0; i < upgradeHistoryLimit; i++ {
+		fullHistory = append(fullHistory, itaallinonev1.UpgradeStatus{FromVersion: "1.8.0", ToVersion: fmt.Sprintf("1.8.%d", i+1), Phase: itaallinonev1.UpgradePhaseSucceeded})
+	}
+
+	tests := []struct {
+		name            string
+		desired         string
+		upgradeSpec     *itaallinonev1.UpgradeSpec
+		upgrade         *itaallinonev1.UpgradeStatus
+		history         []itaallinonev1.UpgradeStatus
+		deployedVersion string
+		rolledOut       bool
+		objects         []client.Object
+		wantPhase       itaallinonev1.UpgradePhase
+		wantRestore     string
+		wantHistory     []itaallinonev1.UpgradePhase
+	}{
+		{
+			name:    "running version",
+			desired: "1.9.0",
+		},
+		{
+			name:    "upgrade in progress is dropped when the version is reverted",
+			desired: "1.9.0",
+			upgrade: upgradeIn(itaallinonev1.UpgradePhaseBackingUp),
+		},
+		{
+			name:        "release series skipped",
+			desired:     "1.11.0",
+			upgradeSpec: &itaallinonev1.UpgradeSpec{Backup: archive},
+			wantPhase:   itaallinonev1.UpgradePhaseRejected,
+			wantHistory: []itaallinonev1.UpgradePhase{itaallinonev1.UpgradePhaseRejected},
+		},
+		{
+			name:        "no storage for the pre-upgrade backup",
+			desired:     "1.9.1",
+			wantPhase:   itaallinonev1.UpgradePhaseRejected,
+			wantHistory: []itaallinonev1.UpgradePhase{itaallinonev1.UpgradePhaseRejected},
+		},
+		{
+			name:        "pre-upgrade backup is waited for",
+			desired:     "1.9.1",
+			upgradeSpec: &itaallinonev1.UpgradeSpec{Backup: archive},
+			objects:     []client.Object{upgradeBackup(itaallinonev1.BackupPhaseRunning)},
+			wantPhase:   itaallinonev1.UpgradePhaseBackingUp,
+		},
+		{
+			name:        "failed pre-upgrade backup keeps the running version",
+			desired:     "1.9.1",
+			upgradeSpec: &itaallinonev1.UpgradeSpec{Backup: archive},
+			upgrade:     upgradeIn(itaallinonev1.UpgradePhaseBackingUp),
+			objects:     []client.Object{upgradeBackup(itaallinonev1.BackupPhaseFailed)},
+			wantPhase:   itaallinonev1.UpgradePhaseFailed,
+			wantHistory: []itaallinonev1.UpgradePhase{itaallinonev1.UpgradePhaseFailed},
+		},
+		{
+			name:        "new version is rolled out after the backup",
+			desired:     "1.9.1",
+			upgradeSpec: &itaallinonev1.UpgradeSpec{Backup: archive},
+			upgrade:     upgradeIn(itaallinonev1.UpgradePhaseBackingUp),
+			objects:     []client.Object{upgradeBackup(itaallinonev1.BackupPhaseSucceeded)},
+			wantPhase:   itaallinonev1.UpgradePhaseRollingOut,
+		},
+		{
+			name:            "deployed version is waited for to become ready",
+			desired:         "1.9.1",
+			upgradeSpec:     &itaallinonev1.UpgradeSpec{Backup: archive},
+			upgrade:         upgradeIn(itaallinonev1.UpgradePhaseRollingOut),
+			deployedVersion: "1.9.1",
+			wantPhase:       itaallinonev1.UpgradePhaseRollingOut,
+		},
+		{
+			name:            "rolled out version completes the upgrade",
+			desired:         "1.9.1",
+			upgradeSpec:     &itaallinonev1.UpgradeSpec{Backup: archive},
+			upgrade:         upgradeIn(itaallinonev1.UpgradePhaseRollingOut),
+			deployedVersion: "1.9.1",
+			rolledOut:       true,
+			wantPhase:       itaallinonev1.UpgradePhaseSucceeded,
+			wantHistory:     []itaallinonev1.UpgradePhase{itaallinonev1.UpgradePhaseSucceeded},
+		},
+		{
+			name:            "rollback in progress",
+			desired:         "1.9.1",
+			upgradeSpec:     &itaallinonev1.UpgradeSpec{Backup: archive, RollbackPolicy: itaallinonev1.RollbackPolicyAutomatic},
+			upgrade:         upgradeIn(itaallinonev1.UpgradePhaseRollingOut),
+			deployedVersion: "1.9.0",
+			objects:         []client.Object{rollback(itaallinonev1.RestorePhaseRunning)},
+			wantPhase:       itaallinonev1.UpgradePhaseRollingBack,
+			wantRestore:     "ita-rollback-1-9-1",
+		},
+		{
+			name:            "restored data completes the rollback",
+			desired:         "1.9.1",
+			upgradeSpec:     &itaallinonev1.UpgradeSpec{Backup: archive, RollbackPolicy: itaallinonev1.RollbackPolicyAutomatic},
+			upgrade:         upgradeIn(itaallinonev1.UpgradePhaseRollingBack),
+			deployedVersion: "1.9.0",
+			objects:         []client.Object{rollback(itaallinonev1.RestorePhaseSucceeded)},
+			wantPhase:       itaallinonev1.UpgradePhaseRolledBack,
+			wantRestore:     "ita-rollback-1-9-1",
+			wantHistory:     []itaallinonev1.UpgradePhase{itaallinonev1.UpgradePhaseRolledBack},
+		},
+		{
+			name:            "failed restore fails the rollback",
+			desired:         "1.9.1",
+			upgradeSpec:     &itaallinonev1.UpgradeSpec{Backup: archive, RollbackPolicy: itaallinonev1.RollbackPolicyAutomatic},
+			upgrade:         upgradeIn(itaallinonev1.UpgradePhaseRollingBack),
+			deployedVersion: "1.9.0",
+			objects:         []client.Object{rollback(itaallinonev1.RestorePhaseFailed)},
+			wantPhase:       itaallinonev1.UpgradePhaseFailed,
+			wantRestore:     "ita-rollback-1-9-1",
+			wantHistory:     []itaallinonev1.UpgradePhase{itaallinonev1.UpgradePhaseFailed},
+		},
+		{
+			name:            "finished rollback is recorded once",
+			desired:         "1.9.1",
+			upgradeSpec:     &itaallinonev1.UpgradeSpec{Backup: archive, RollbackPolicy: itaallinonev1.RollbackPolicyAutomatic},
+			upgrade:         upgradeIn(itaallinonev1.UpgradePhaseRolledBack),
+			history:         []itaallinonev1.UpgradeStatus{*upgradeIn(itaallinonev1.UpgradePhaseRolledBack)},
+			deployedVersion: "1.9.0",
+			objects:         []client.Object{rollback(itaallinonev1.RestorePhaseSucceeded)},
+			wantPhase:       itaallinonev1.UpgradePhaseRolledBack,
+			wantRestore:     "ita-rollback-1-9-1",
+			wantHistory:     []itaallinonev1.UpgradePhase{itaallinonev1.UpgradePhaseRolledBack},
+		},
+		{
+			name:            "upgrade is retried once its rollback is deleted",
+			desired:         "1.9.1",
+			upgradeSpec:     &itaallinonev1.UpgradeSpec{Backup: archive, RollbackPolicy: itaallinonev1.RollbackPolicyAutomatic},
+			upgrade:         upgradeIn(itaallinonev1.UpgradePhaseRolledBack),
+			history:         []itaallinonev1.UpgradeStatus{*upgradeIn(itaallinonev1.UpgradePhaseRolledBack)},
+			deployedVersion: "1.9.0",
+			objects:         []client.Object{upgradeBackup(itaallinonev1.BackupPhaseSucceeded)},
+			wantPhase:       itaallinonev1.UpgradePhaseRollingOut,
+			wantHistory:     []itaallinonev1.UpgradePhase{itaallinonev1.UpgradePhaseRolledBack},
+		},
+		{
+			name:            "history keeps the most recent upgrades",
+			desired:         "1.9.1",
+			upgradeSpec:     &itaallinonev1.UpgradeSpec{Backup: archive},
+			upgrade:         upgradeIn(itaallinonev1.UpgradePhaseRollingOut),
+			history:         fullHistory,
+			deployedVersion: "1.9.1",
+			rolledOut:       true,
+			wantPhase:       itaallinonev1.UpgradePhaseSucceeded,
+			wantHistory: []itaallinonev1.UpgradePhase{
+				itaallinonev1.UpgradePhaseSucceeded, itaallinonev1.UpgradePhaseSucceeded, itaallinonev1.UpgradePhaseSucceeded,
+				itaallinonev1.UpgradePhaseSucceeded, itaallinonev1.UpgradePhaseSucceeded, itaallinonev1.UpgradePhaseSucceeded,
+				itaallinonev1.UpgradePhaseSucceeded, itaallinonev1.UpgradePhaseSucceeded, itaallinonev1.UpgradePhaseSucceeded,
+				itaallinonev1.UpgradePhaseSucceeded,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			scheme := newTestScheme(t)
+			c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(tt.objects...).Build()
+			reconciler := &ITAutomationAllInOneReconciler{Client: c, Log: logr.Discard(), Scheme: scheme}
+
+			instance := newTestRunningInstance()
+			instance.Spec.Version = tt.desired
+			instance.Spec.Upgrade = tt.upgradeSpec
+			instance.Status.Version = "1.9.0"
+			instance.Status.Upgrade = tt.upgrade
+			instance.Status.UpgradeHistory = tt.history
+			var k8sDeployment *appsv1.Deployment
+			if tt.deployedVersion != "" {
+				k8sDeployment = &appsv1.Deployment{}
+				k8sDeployment.Spec.Template.Labels = map[string]string{versionLabel: tt.deployedVersion}
+			}
+
+			status := instance.Status.DeepCopy()
+			err := reconciler.observeUpgrade(context.Background(), instance, k8sDeployment, tt.rolledOut, status)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			upgrade := status.Upgrade
+			if tt.wantPhase == "" {
+				if upgrade != nil {
+					t.Errorf("upgrade = %v, want none", upgrade)
+				}
+				return
+			}
+			if upgrade == nil {
+				t.Fatalf("upgrade = nil, want phase %s", tt.wantPhase)
+			}
+			if upgrade.Phase != tt.wantPhase || upgrade.FromVersion != "1.9.0" || upgrade.ToVersion != tt.desired {
+				t.Errorf("upgrade = %s from %s to %s, want %s from 1.9.0 to %s", upgrade.Phase, upgrade.FromVersion, upgrade.ToVersion, tt.wantPhase, tt.desired)
+			}
+			if upgrade.RestoreName != tt.wantRestore {
+				t.Errorf("restore = %q, want %q", upgrade.RestoreName, tt.wantRestore)
+			}
+			if finished := upgrade.CompletionTime != nil; finished != upgrade.IsFinished() {
+				t.Errorf("completion time = %v, want it set only once finished", upgrade.CompletionTime)
+			}
+			if upgrade.Phase == itaallinonev1.UpgradePhaseRollingOut && upgrade.RolloutStartTime == nil {
+				t.Errorf("rollout start time is not set")
+			}
+
+			var history []itaallinonev1.UpgradePhase
+			for _, entry := range status.UpgradeHistory {
+				history = append(history, entry.Phase)
+			}
+			if fmt.Sprint(history) != fmt.Sprint(tt.wantHistory) {
+				t.Errorf("history = %v, want %v", history, tt.wantHistory)
+			}
+			if len(tt.history) == upgradeHistoryLimit && status.UpgradeHistory[upgradeHistoryLimit-1].ToVersion != tt.desired {
+				t.Errorf("last history entry = %v, want the upgrade to %s", status.UpgradeHistory[upgradeHistoryLimit-1], tt.desired)
+			}
+		})
+	}
+}
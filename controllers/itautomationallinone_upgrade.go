@@ -0,0 +1,121 @@
+/*
+Copyright 2021 NEC Corporation.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+
+	itaallinonev1 "github.com/exastro-suite/it-automation-operator/api/v1"
+)
+
+// Only the most recent finished upgrades are kept in the status
+const upgradeHistoryLimit = 10
+
+// resolveDeploymentVersion returns the version the frontend is deployed with. A change of
+// spec.version is held back on the running version until the upgrade path is valid and the
+// pre-upgrade backup has succeeded, which is taken here. Once deployed, the new version is kept
+// unless it does not become ready in time and is rolled back.
+func (reconciler *ITAutomationAllInOneReconciler) resolveDeploymentVersion(ctx context.Context, customResource *itaallinonev1.ITAutomationAllInOne) (string, error) {
+	running := customResource.Status.Version
+	desired := customResource.Spec.Version
+	if running == "" || running == desired {
+		return desired, nil
+	}
+
+	// A rolled back upgrade is retried when its restore is deleted
+	rollbackFactory := newRestoreFactoryForRollback(reconciler, customResource)
+	err := reconciler.Get(ctx, rollbackFactory.GetNamespaceName(), &itaallinonev1.ITAutomationRestore{})
+	if err == nil {
+		return running, nil
+	} else if !errors.IsNotFound(err) {
+		return running, err
+	}
+
+	deploymentFactory := newDeploymentFactoryForFrontend(reconciler, customResource)
+	k8sDeployment := &appsv1.Deployment{}
+	err = reconciler.Get(ctx, deploymentFactory.GetNamespaceName(), k8sDeployment)
+	if err == nil && k8sDeployment.Spec.Template.Labels[versionLabel] == desired {
+		deadline, found := rollbackDeadline(customResource, customResource.Status.Upgrade)
+		if !found || time.Now().Before(deadline) || isDeploymentRolledOut(k8sDeployment) {
+			return desired, nil
+		}
+
+		reconciler.Log.Info("New version did not become ready in time. Rolling back", append(k8sResourceToLogParameters(customResource),
+			"from", desired, "to", running)...)
+		_, _, err = reconciler.ensureK8sResourceCreatedWithEvent(ctx, customResource, rollbackFactory)
+		return running, err
+	} else if err != nil && !errors.IsNotFound(err) {
+		return running, err
+	}
+
+	// The reasons the upgrade is held back are reported by observeUpgrade
+	if itaallinonev1.CheckUpgradePath(running, desired) != nil {
+		return running, nil
+	}
+	if customResource.Spec.Upgrade != nil && customResource.Spec.Upgrade.SkipBackup {
+		return desired, nil
+	}
+	if customResource.GetUpgradeBackupStorage() == nil {
+		return running, nil
+	}
+
+	backupFactory := newBackupFactoryForUpgrade(reconciler, customResource)
+	_, _, err = reconciler.ensureK8sResourceCreatedWithEvent(ctx, customResource, backupFactory)
+	if err != nil {
+		return running, err
+	}
+
+	backup := &itaallinonev1.ITAutomationBackup{}
+	err = reconciler.Get(ctx, backupFactory.GetNamespaceName(), backup)
+	if err != nil && !errors.IsNotFound(err) {
+		return running, err
+	}
+	if err == nil && backup.Status.Phase == itaallinonev1.BackupPhaseSucceeded {
+		return desired, nil
+	}
+
+	return running, nil
+}
+
+// rollbackDeadline returns when the rollout of spec.version is rolled back, if the upgrade is
+// rolled back automatically and is rolling out. A suspended instance never becomes ready, so it is not rolled back.
+func rollbackDeadline(customResource *itaallinonev1.ITAutomationAllInOne, upgrade *itaallinonev1.UpgradeStatus) (time.Time, bool) {
+	if customResource.GetRollbackPolicy() != itaallinonev1.RollbackPolicyAutomatic || customResource.Spec.Suspended || upgrade == nil ||
+		upgrade.ToVersion != customResource.Spec.Version || upgrade.Phase != itaallinonev1.UpgradePhaseRollingOut || upgrade.RolloutStartTime == nil {
+		return time.Time{}, false
+	}
+	return upgrade.RolloutStartTime.Add(customResource.GetUpgradeTimeout()), true
+}
+
+// isDeploymentRolledOut reports whether all the replicas of the Deployment are updated and available
+func isDeploymentRolledOut(k8sDeployment *appsv1.Deployment) bool {
+	replicas := int32(1)
+	if k8sDeployment.Spec.Replicas != nil {
+		replicas = *k8sDeployment.Spec.Replicas
+	}
+	deploymentStatus := k8sDeployment.Status
+
+	return replicas > 0 &&
+		deploymentStatus.ObservedGeneration >= k8sDeployment.Generation &&
+		deploymentStatus.UpdatedReplicas >= replicas &&
+		deploymentStatus.Replicas <= deploymentStatus.UpdatedReplicas &&
+		deploymentStatus.AvailableReplicas >= replicas
+}
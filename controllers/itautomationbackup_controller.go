@@ -0,0 +1,400 @@
+/*
+Copyright 2021 NEC Corporation.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/go-logr/logr"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	itaallinonev1 "github.com/exastro-suite/it-automation-operator/api/v1"
+)
+
+// The instance is not watched, so a pending backup checks it periodically.
+const instanceRecheckInterval = 30 * time.Second
+
+// ITAutomationBackupReconciler reconciles a ITAutomationBackup object
+type ITAutomationBackupReconciler struct {
+	client.Client
+	Log           logr.Logger
+	Scheme        *runtime.Scheme
+	ImageDefaults ImageDefaults
+	// VolumeSnapshotAvailable tells whether the cluster serves CSI VolumeSnapshots
+	VolumeSnapshotAvailable bool
+	Recorder                record.EventRecorder
+}
+
+// backupResult is the termination message of the backup Job
+type backupResult struct {
+	Size     int64  `json:"size"`
+	Checksum string `json:"checksum"`
+}
+
+//+kubebuilder:rbac:groups=ita-all-in-one.ita.exastro,resources=itautomationbackups,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=ita-all-in-one.ita.exastro,resources=itautomationbackups/status,verbs=get;update;patch
+//+kubebuilder:rbac:groups=ita-all-in-one.ita.exastro,resources=itautomationbackups/finalizers,verbs=update
+//+kubebuilder:rbac:groups=batch,resources=jobs,verbs=get;list;watch;create;update;delete
+//+kubebuilder:rbac:groups=core,resources=pods,verbs=get;list;watch
+//+kubebuilder:rbac:groups=core,resources=persistentvolumeclaims,verbs=get;list;watch
+//+kubebuilder:rbac:groups=snapshot.storage.k8s.io,resources=volumesnapshots,verbs=get;list;watch;create;delete
+//+kubebuilder:rbac:groups=core,resources=events,verbs=create;patch
+
+func (reconciler *ITAutomationBackupReconciler) Reconcile(ctx context.Context, request ctrl.Request) (ctrl.Result, error) {
+	backup := &itaallinonev1.ITAutomationBackup{}
+	err := reconciler.Get(ctx, request.NamespacedName, backup)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			reconciler.Log.Info("Custom resource is not found. Ignoring since object must be deleted", k8sResourceToLogParameters(backup)...)
+			return ctrl.Result{}, nil
+		}
+
+		reconciler.Log.Error(err, "Failed to get custom resource", k8sResourceToLogParameters(backup)...)
+		return ctrl.Result{}, err
+	}
+
+	if !backup.DeletionTimestamp.IsZero() {
+		return reconciler.finalizeBackup(ctx, backup)
+	}
+
+	// The finalizer deletes the archive when the backup is deleted, or
+	// starts the instance again if it is stopped for VolumeSnapshots
+	needsFinalizer := backup.Spec.RetentionPolicy == itaallinonev1.RetentionPolicyDelete ||
+		backup.Spec.GetMethod() == itaallinonev1.BackupMethodVolumeSnapshot
+	if needsFinalizer && !controllerutil.ContainsFinalizer(backup, finalizerName) {
+		controllerutil.AddFinalizer(backup, finalizerName)
+		err = reconciler.Update(ctx, backup)
+		if err != nil && !errors.IsConflict(err) {
+			reconciler.Log.Error(err, "Failed to add finalizer", k8sResourceToLogParameters(backup)...)
+			return ctrl.Result{}, err
+		}
+		return ctrl.Result{Requeue: true}, nil
+	}
+
+	// A finished backup is never taken again
+	if backup.Status.Phase == itaallinonev1.BackupPhaseSucceeded || backup.Status.Phase == itaallinonev1.BackupPhaseFailed {
+		return ctrl.Result{}, nil
+	}
+
+	status := backup.Status.DeepCopy()
+	status.ObservedGeneration = backup.Generation
+
+	if backup.Spec.GetMethod() == itaallinonev1.BackupMethodVolumeSnapshot {
+		result, err := reconciler.takeVolumeSnapshots(ctx, backup, status)
+		return reconciler.updateBackupStatus(ctx, backup, status, result, err)
+	}
+
+	if backup.Spec.Target == nil {
+		failBackup(backup, status, "TargetRequired", "spec.target is required with the Archive method")
+		return reconciler.updateBackupStatus(ctx, backup, status, ctrl.Result{}, nil)
+	}
+
+	jobFactory := newJobFactoryForBackup(reconciler, backup)
+	k8sJob := &batchv1.Job{}
+	err = reconciler.Get(ctx, jobFactory.GetNamespaceName(), k8sJob)
+	if errors.IsNotFound(err) {
+		result, err := reconciler.createBackupJob(ctx, backup, jobFactory, status)
+		return reconciler.updateBackupStatus(ctx, backup, status, result, err)
+	} else if err != nil {
+		reconciler.Log.Error(err, "Failed to get resource", k8sResourceToLogParameters(k8sJob)...)
+		return ctrl.Result{}, err
+	}
+
+	err = reconciler.observeBackupJob(ctx, backup, k8sJob, status)
+	return reconciler.updateBackupStatus(ctx, backup, status, ctrl.Result{}, err)
+}
+
+// createBackupJob starts the backup once the instance is running
+func (reconciler *ITAutomationBackupReconciler) createBackupJob(ctx context.Context, backup *itaallinonev1.ITAutomationBackup, jobFactory *JobFactoryForBackup, status *itaallinonev1.ITAutomationBackupStatus) (ctrl.Result, error) {
+	status.Phase = itaallinonev1.BackupPhasePending
+
+	instance := &itaallinonev1.ITAutomationAllInOne{}
+	err := reconciler.Get(ctx, types.NamespacedName{Namespace: backup.Namespace, Name: backup.Spec.InstanceName}, instance)
+	if errors.IsNotFound(err) {
+		setBackupCondition(backup, status, metav1.ConditionFalse, "InstanceNotFound",
+			fmt.Sprintf("ITAutomationAllInOne %s is not found", backup.Spec.InstanceName))
+		return ctrl.Result{RequeueAfter: instanceRecheckInterval}, nil
+	} else if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	databasePod, err := findReadyPod(ctx, reconciler.Client, instance)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	if instance.Status.Phase != itaallinonev1.PhaseRunning || databasePod == nil {
+		setBackupCondition(backup, status, metav1.ConditionFalse, "InstanceNotReady",
+			fmt.Sprintf("ITAutomationAllInOne %s is not running", instance.Name))
+		return ctrl.Result{RequeueAfter: instanceRecheckInterval}, nil
+	}
+
+	jobFactory.Instance = instance
+	jobFactory.DatabasePod = databasePod
+	k8sJob := jobFactory.New()
+
+	reconciler.Log.Info("Creating resource", k8sResourceToLogParameters(k8sJob)...)
+
+	err = reconciler.Create(ctx, k8sJob)
+	if err != nil {
+		reconciler.Log.Error(err, "Failed to create resource", k8sResourceToLogParameters(k8sJob)...)
+		return ctrl.Result{}, err
+	}
+
+	status.Phase = itaallinonev1.BackupPhaseRunning
+	status.JobName = k8sJob.GetName()
+	status.Version = instance.Status.Version
+	status.Path = backupArchiveName(backup)
+	setBackupCondition(backup, status, metav1.ConditionFalse, "JobRunning",
+		fmt.Sprintf("Job %s is running", k8sJob.GetName()))
+
+	return ctrl.Result{}, nil
+}
+
+func (reconciler *ITAutomationBackupReconciler) observeBackupJob(ctx context.Context, backup *itaallinonev1.ITAutomationBackup, k8sJob *batchv1.Job, status *itaallinonev1.ITAutomationBackupStatus) error {
+	status.JobName = k8sJob.Name
+	status.Version = k8sJob.Labels[versionLabel]
+	status.Path = backupArchiveName(backup)
+	status.StartTime = k8sJob.Status.StartTime
+
+	switch {
+	case isJobSucceeded(k8sJob):
+		message, err := findTerminationMessage(ctx, reconciler.Client, k8sJob)
+		if err != nil {
+			return err
+		}
+
+		result := backupResult{}
+		err = json.Unmarshal([]byte(message), &result)
+		if err != nil {
+			return fmt.Errorf("failed to parse the result of Job %s: %w", k8sJob.Name, err)
+		}
+
+		status.Phase = itaallinonev1.BackupPhaseSucceeded
+		status.CompletionTime = k8sJob.Status.CompletionTime
+		status.Size = result.Size
+		status.Checksum = result.Checksum
+		setBackupCondition(backup, status, metav1.ConditionTrue, "JobSucceeded",
+			fmt.Sprintf("Archive %s is stored in %s", status.Path, backup.Spec.Target.PvcName))
+	case isJobFailed(k8sJob):
+		status.Phase = itaallinonev1.BackupPhaseFailed
+		status.CompletionTime = &findJobCondition(k8sJob, batchv1.JobFailed).LastTransitionTime
+		setBackupCondition(backup, status, metav1.ConditionFalse, "JobFailed",
+			fmt.Sprintf("Job %s failed: %s", k8sJob.Name, findJobCondition(k8sJob, batchv1.JobFailed).Message))
+	default:
+		status.Phase = itaallinonev1.BackupPhaseRunning
+		setBackupCondition(backup, status, metav1.ConditionFalse, "JobRunning",
+			fmt.Sprintf("Job %s is running", k8sJob.Name))
+	}
+
+	return nil
+}
+
+// finalizeBackup deletes the archive once the backup Job is finished. A failed cleanup
+// blocks the deletion, and is retried when its Job is deleted. Owned VolumeSnapshots
+// are left to the garbage collector.
+func (reconciler *ITAutomationBackupReconciler) finalizeBackup(ctx context.Context, backup *itaallinonev1.ITAutomationBackup) (ctrl.Result, error) {
+	if !controllerutil.ContainsFinalizer(backup, finalizerName) {
+		return ctrl.Result{}, nil
+	}
+
+	if backup.Spec.GetMethod() == itaallinonev1.BackupMethodVolumeSnapshot {
+		instance := &itaallinonev1.ITAutomationAllInOne{}
+		err := reconciler.Get(ctx, types.NamespacedName{Namespace: backup.Namespace, Name: backup.Spec.InstanceName}, instance)
+		if err != nil && !errors.IsNotFound(err) {
+			return ctrl.Result{}, err
+		}
+		if err == nil && instance.Annotations[quiescedByAnnotation] == quiescedBy(backupKind, backup.Name) {
+			return releaseInstance(ctx, reconciler.Client, reconciler.Log, reconciler.Recorder, instance)
+		}
+	}
+
+	backupJob, err := getJob(ctx, reconciler.Client, newJobFactoryForBackup(reconciler, backup))
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	// Nothing is written to the target before the backup Job is created
+	if backupJob != nil {
+		if !isJobSucceeded(backupJob) && !isJobFailed(backupJob) {
+			return ctrl.Result{}, nil
+		}
+
+		cleanupJobFactory := newJobFactoryForArchiveCleanup(reconciler, backup)
+		cleanupJobFactory.BackupJob = backupJob
+		requeue, result, err := ensureK8sResourceCreated(ctx, reconciler.Client, reconciler.Log, cleanupJobFactory)
+		if requeue {
+			return result, err
+		}
+
+		cleanupJob, err := getJob(ctx, reconciler.Client, cleanupJobFactory)
+		if err != nil || cleanupJob == nil || !isJobSucceeded(cleanupJob) {
+			return ctrl.Result{}, err
+		}
+	}
+
+	reconciler.Log.Info("Backup is cleaned up. Removing finalizer", k8sResourceToLogParameters(backup)...)
+
+	controllerutil.RemoveFinalizer(backup, finalizerName)
+	err = reconciler.Update(ctx, backup)
+	if err != nil {
+		if errors.IsConflict(err) {
+			return ctrl.Result{Requeue: true}, nil
+		}
+
+		reconciler.Log.Error(err, "Failed to remove finalizer", k8sResourceToLogParameters(backup)...)
+		return ctrl.Result{}, err
+	}
+
+	return ctrl.Result{}, nil
+}
+
+func (reconciler *ITAutomationBackupReconciler) updateBackupStatus(ctx context.Context, backup *itaallinonev1.ITAutomationBackup, status *itaallinonev1.ITAutomationBackupStatus, result ctrl.Result, reconcileErr error) (ctrl.Result, error) {
+	if equality.Semantic.DeepEqual(status, &backup.Status) {
+		return result, reconcileErr
+	}
+
+	previousPhase := backup.Status.Phase
+	backup.Status = *status
+	err := reconciler.Status().Update(ctx, backup)
+	if err != nil {
+		if errors.IsConflict(err) {
+			reconciler.Log.Info("Custom resource was modified concurrently. Retrying status update", k8sResourceToLogParameters(backup)...)
+			return ctrl.Result{Requeue: true}, reconcileErr
+		}
+
+		reconciler.Log.Error(err, "Failed to update status", k8sResourceToLogParameters(backup)...)
+		if reconcileErr != nil {
+			return result, reconcileErr
+		}
+		return result, err
+	}
+
+	if status.Phase != previousPhase {
+		reconciler.recordBackupEvent(ctx, backup)
+	}
+
+	return result, reconcileErr
+}
+
+// recordBackupEvent records the phase the backup has moved to, once the status is stored
+func (reconciler *ITAutomationBackupReconciler) recordBackupEvent(ctx context.Context, backup *itaallinonev1.ITAutomationBackup) {
+	detail := ""
+	if condition := meta.FindStatusCondition(backup.Status.Conditions, itaallinonev1.ConditionTypeComplete); condition != nil {
+		detail = condition.Message
+	}
+
+	switch backup.Status.Phase {
+	case itaallinonev1.BackupPhaseRunning:
+		recordInstanceEvent(ctx, reconciler.Client, reconciler.Recorder, backup, backup.Spec.InstanceName, corev1.EventTypeNormal, eventReasonBackupStarted,
+			"ITAutomationBackup %s of ITAutomationAllInOne %s started", backup.Name, backup.Spec.InstanceName)
+	case itaallinonev1.BackupPhaseSucceeded:
+		recordInstanceEvent(ctx, reconciler.Client, reconciler.Recorder, backup, backup.Spec.InstanceName, corev1.EventTypeNormal, eventReasonBackupCompleted,
+			"ITAutomationBackup %s of ITAutomationAllInOne %s completed: %s", backup.Name, backup.Spec.InstanceName, detail)
+	case itaallinonev1.BackupPhaseFailed:
+		recordInstanceEvent(ctx, reconciler.Client, reconciler.Recorder, backup, backup.Spec.InstanceName, corev1.EventTypeWarning, eventReasonBackupFailed,
+			"ITAutomationBackup %s of ITAutomationAllInOne %s failed: %s", backup.Name, backup.Spec.InstanceName, detail)
+	}
+}
+
+func failBackup(backup *itaallinonev1.ITAutomationBackup, status *itaallinonev1.ITAutomationBackupStatus, reason string, message string) {
+	now := metav1.Now()
+	status.Phase = itaallinonev1.BackupPhaseFailed
+	status.CompletionTime = &now
+	setBackupCondition(backup, status, metav1.ConditionFalse, reason, message)
+}
+
+func setBackupCondition(backup *itaallinonev1.ITAutomationBackup, status *itaallinonev1.ITAutomationBackupStatus, conditionStatus metav1.ConditionStatus, reason string, message string) {
+	meta.SetStatusCondition(&status.Conditions, metav1.Condition{
+		Type:               itaallinonev1.ConditionTypeComplete,
+		Status:             conditionStatus,
+		ObservedGeneration: backup.Generation,
+		Reason:             reason,
+		Message:            message,
+	})
+}
+
+// findReadyPod returns a ready frontend pod of the instance, or nil if there is none
+func findReadyPod(ctx context.Context, reader client.Reader, instance *itaallinonev1.ITAutomationAllInOne) (*corev1.Pod, error) {
+	k8sPods := &corev1.PodList{}
+	err := reader.List(ctx, k8sPods, client.InNamespace(instance.Namespace), client.MatchingLabels(createLabels(instance)))
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range k8sPods.Items {
+		k8sPod := &k8sPods.Items[i]
+		if k8sPod.DeletionTimestamp != nil || k8sPod.Status.PodIP == "" {
+			continue
+		}
+		for _, condition := range k8sPod.Status.Conditions {
+			if condition.Type == corev1.PodReady && condition.Status == corev1.ConditionTrue {
+				return k8sPod, nil
+			}
+		}
+	}
+
+	return nil, nil
+}
+
+// findTerminationMessage returns the termination message of the pod the Job succeeded with
+func findTerminationMessage(ctx context.Context, reader client.Reader, k8sJob *batchv1.Job) (string, error) {
+	k8sPods := &corev1.PodList{}
+	err := reader.List(ctx, k8sPods, client.InNamespace(k8sJob.Namespace), client.MatchingLabels{"job-name": k8sJob.Name})
+	if err != nil {
+		return "", err
+	}
+
+	for _, k8sPod := range k8sPods.Items {
+		if k8sPod.Status.Phase != corev1.PodSucceeded {
+			continue
+		}
+		for _, containerStatus := range k8sPod.Status.ContainerStatuses {
+			if terminated := containerStatus.State.Terminated; terminated != nil && terminated.ExitCode == 0 {
+				return terminated.Message, nil
+			}
+		}
+	}
+
+	return "", fmt.Errorf("no succeeded pod of Job %s is found", k8sJob.Name)
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (reconciler *ITAutomationBackupReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	builder := ctrl.NewControllerManagedBy(mgr).
+		For(&itaallinonev1.ITAutomationBackup{}).
+		Owns(&batchv1.Job{})
+
+	if reconciler.VolumeSnapshotAvailable {
+		builder = builder.Owns(newVolumeSnapshot())
+	}
+
+	return builder.Complete(reconciler)
+}
@@ -0,0 +1,322 @@
+/*
+Copyright 2021 NEC Corporation.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/go-logr/logr"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	itaallinonev1 "github.com/exastro-suite/it-automation-operator/api/v1"
+)
+
+func newTestBackupWithMethod(method itaallinonev1.BackupMethod) *itaallinonev1.ITAutomationBackup {
+	backup := &itaallinonev1.ITAutomationBackup{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "backup", UID: "backup-uid"},
+		Spec: itaallinonev1.ITAutomationBackupSpec{
+			InstanceName:  "ita",
+			BackupStorage: itaallinonev1.BackupStorage{Method: method},
+		},
+	}
+	if method == itaallinonev1.BackupMethodArchive {
+		backup.Spec.Target = &itaallinonev1.BackupTarget{PvcName: "backups"}
+	} else {
+		backup.Finalizers = []string{finalizerName}
+	}
+	return backup
+}
+
+func findEnvVar(env []corev1.EnvVar, name string) *corev1.EnvVar {
+	for i := range env {
+		if env[i].Name == name {
+			return &env[i]
+		}
+	}
+	return nil
+}
+
+func TestJobFactoryForBackup(t *testing.T) {
+	tests := []struct {
+		name        string
+		statusImage string
+		wantImage   string
+	}{
+		{
+			name:        "image the instance runs",
+			statusImage: "registry.example.com/ita:1.9.0",
+			wantImage:   "registry.example.com/ita:1.9.0",
+		},
+		{
+			name:      "image of the spec before the instance reports one",
+			wantImage: "ghcr.io/exastro-suite/it-automation:1.9.0-ubi8-ja",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			instance := newTestRunningInstance()
+			instance.Status.Image = tt.statusImage
+			backup := newTestBackupWithMethod(itaallinonev1.BackupMethodArchive)
+			factory := newJobFactoryForBackup(&ITAutomationBackupReconciler{Scheme: newTestScheme(t)}, backup)
+			factory.Instance = instance
+			factory.DatabasePod = newTestFrontendPod(instance, "10.0.0.1")
+			k8sJob := factory.New().(*batchv1.Job)
+
+			if k8sJob.Name != "backup-backup" || k8sJob.Namespace != "default" {
+				t.Errorf("Job = %s/%s, want default/backup-backup", k8sJob.Namespace, k8sJob.Name)
+			}
+			if !metav1.IsControlledBy(k8sJob, backup) {
+				t.Errorf("owner references = %v, want the backup", k8sJob.OwnerReferences)
+			}
+			if k8sJob.Labels[versionLabel] != "1.9.0" {
+				t.Errorf("version label = %q, want 1.9.0", k8sJob.Labels[versionLabel])
+			}
+
+			podSpec := k8sJob.Spec.Template.Spec
+			container := podSpec.Containers[0]
+			if container.Image != tt.wantImage {
+				t.Errorf("image = %s, want %s", container.Image, tt.wantImage)
+			}
+			if script := container.Command[len(container.Command)-1]; script != createBackupScript("backup.tar.gz") {
+				t.Errorf("script = %q, want the backup script of backup.tar.gz", script)
+			}
+			if host := findEnvVar(container.Env, "DATABASE_HOST"); host == nil || host.Value != "10.0.0.1" {
+				t.Errorf("DATABASE_HOST = %v, want the address of the database pod", host)
+			}
+			if password := findEnvVar(container.Env, "MYSQL_PWD"); password == nil || password.ValueFrom == nil ||
+				password.ValueFrom.SecretKeyRef.Name != credentialsSecretName(instance) {
+				t.Errorf("MYSQL_PWD = %v, want the password of the instance Secret", password)
+			}
+
+			claims := map[string]bool{}
+			for _, volume := range podSpec.Volumes {
+				if claim := volume.PersistentVolumeClaim; claim != nil {
+					claims[claim.ClaimName] = claim.ReadOnly
+				}
+			}
+			if readOnly, found := claims["ita-file-volume"]; !found || !readOnly {
+				t.Errorf("claims = %v, want the file volume read-only", claims)
+			}
+			if readOnly, found := claims["backups"]; !found || readOnly {
+				t.Errorf("claims = %v, want the target writable", claims)
+			}
+
+			affinity := podSpec.Affinity
+			if affinity == nil || affinity.PodAffinity == nil ||
+				affinity.PodAffinity.RequiredDuringSchedulingIgnoredDuringExecution[0].LabelSelector.MatchLabels["app.kubernetes.io/instance"] != "ita" {
+				t.Errorf("affinity = %v, want the Job next to the instance", affinity)
+			}
+			if podSpec.RestartPolicy != corev1.RestartPolicyNever {
+				t.Errorf("restart policy = %s, want Never", podSpec.RestartPolicy)
+			}
+		})
+	}
+}
+
+func TestCreateBackupScript(t *testing.T) {
+	script := createBackupScript("backup.tar.gz")
+
+	// The archive only appears under its name once it is complete
+	for _, want := range []string{
+		"set -e\n",
+		`mysqldump --host="$DATABASE_HOST" --port="$DATABASE_PORT" --user="$DATABASE_USER" $DATABASE_TLS_OPTIONS --single-transaction`,
+		`"$DATABASE_NAME" > "$work/database.sql"`,
+		`tar -czf "$work/archive.tar.gz" -C "$work" database.sql -C / exastro-file-volume`,
+		`mv "$work/archive.tar.gz" /backup/backup.tar.gz`,
+		`stat -c %s /backup/backup.tar.gz`,
+		`sha256sum /backup/backup.tar.gz`,
+		`> /dev/termination-log`,
+	} {
+		if !strings.Contains(script, want) {
+			t.Errorf("script does not contain %q:\n%s", want, script)
+		}
+	}
+	if strings.Index(script, "mysqldump") > strings.Index(script, "mv ") {
+		t.Errorf("archive is moved before the dump:\n%s", script)
+	}
+}
+
+func TestBackupPhases(t *testing.T) {
+	instance := newTestRunningInstance()
+	deployingInstance := newTestRunningInstance()
+	deployingInstance.Status.Phase = itaallinonev1.PhaseDeploying
+	succeededBackup := newTestBackupWithMethod(itaallinonev1.BackupMethodArchive)
+	succeededBackup.Status.Phase = itaallinonev1.BackupPhaseSucceeded
+
+	backupJob := func(conditionType batchv1.JobConditionType) *batchv1.Job {
+		k8sJob := &batchv1.Job{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "backup-backup", Labels: map[string]string{versionLabel: "1.9.0"}}}
+		if conditionType != "" {
+			k8sJob.Status.Conditions = []batchv1.JobCondition{{Type: conditionType, Status: corev1.ConditionTrue, Message: "BackoffLimitExceeded"}}
+		}
+		return k8sJob
+	}
+	succeededPod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "backup-backup-x", Labels: map[string]string{"job-name": "backup-backup"}},
+		Status: corev1.PodStatus{Phase: corev1.PodSucceeded, ContainerStatuses: []corev1.ContainerStatus{
+			{State: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{Message: `{"size": 1024, "checksum": "sha256:0123"}`}}},
+		}},
+	}
+
+	tests := []struct {
+		name         string
+		backup       *itaallinonev1.ITAutomationBackup
+		objects      []client.Object
+		wantResult   ctrl.Result
+		wantPhase    itaallinonev1.ITAutomationBackupPhase
+		wantReason   string
+		wantJob      bool
+		wantEvent    string
+		wantSize     int64
+		wantChecksum string
+	}{
+		{
+			name:       "target is required",
+			backup:     &itaallinonev1.ITAutomationBackup{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "backup"}, Spec: itaallinonev1.ITAutomationBackupSpec{InstanceName: "ita"}},
+			wantPhase:  itaallinonev1.BackupPhaseFailed,
+			wantReason: "TargetRequired",
+			wantEvent:  eventReasonBackupFailed,
+		},
+		{
+			name:       "missing instance is waited for",
+			wantResult: ctrl.Result{RequeueAfter: instanceRecheckInterval},
+			wantPhase:  itaallinonev1.BackupPhasePending,
+			wantReason: "InstanceNotFound",
+		},
+		{
+			name:       "instance which is not running is waited for",
+			objects:    []client.Object{deployingInstance, newTestFrontendPod(instance, "10.0.0.1")},
+			wantResult: ctrl.Result{RequeueAfter: instanceRecheckInterval},
+			wantPhase:  itaallinonev1.BackupPhasePending,
+			wantReason: "InstanceNotReady",
+		},
+		{
+			name:       "instance without a ready pod is waited for",
+			objects:    []client.Object{instance, newTestFrontendPod(instance, "")},
+			wantResult: ctrl.Result{RequeueAfter: instanceRecheckInterval},
+			wantPhase:  itaallinonev1.BackupPhasePending,
+			wantReason: "InstanceNotReady",
+		},
+		{
+			name:       "Job is created for a running instance",
+			objects:    []client.Object{instance, newTestFrontendPod(instance, "10.0.0.1")},
+			wantPhase:  itaallinonev1.BackupPhaseRunning,
+			wantReason: "JobRunning",
+			wantJob:    true,
+			wantEvent:  eventReasonBackupStarted,
+		},
+		{
+			name:       "running Job",
+			objects:    []client.Object{backupJob("")},
+			wantPhase:  itaallinonev1.BackupPhaseRunning,
+			wantReason: "JobRunning",
+			wantJob:    true,
+			wantEvent:  eventReasonBackupStarted,
+		},
+		{
+			name:         "succeeded Job reports the archive",
+			objects:      []client.Object{backupJob(batchv1.JobComplete), succeededPod},
+			wantPhase:    itaallinonev1.BackupPhaseSucceeded,
+			wantReason:   "JobSucceeded",
+			wantJob:      true,
+			wantEvent:    eventReasonBackupCompleted,
+			wantSize:     1024,
+			wantChecksum: "sha256:0123",
+		},
+		{
+			name:       "failed Job",
+			objects:    []client.Object{backupJob(batchv1.JobFailed)},
+			wantPhase:  itaallinonev1.BackupPhaseFailed,
+			wantReason: "JobFailed",
+			wantJob:    true,
+			wantEvent:  eventReasonBackupFailed,
+		},
+		{
+			name:      "finished backup is never taken again",
+			backup:    succeededBackup,
+			objects:   []client.Object{instance, newTestFrontendPod(instance, "10.0.0.1")},
+			wantPhase: itaallinonev1.BackupPhaseSucceeded,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			scheme := newTestScheme(t)
+			backup := tt.backup
+			if backup == nil {
+				backup = newTestBackupWithMethod(itaallinonev1.BackupMethodArchive)
+			}
+			backup = backup.DeepCopy()
+			c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(append([]client.Object{backup}, tt.objects...)...).Build()
+			recorder := record.NewFakeRecorder(100)
+			reconciler := &ITAutomationBackupReconciler{Client: c, Log: logr.Discard(), Scheme: scheme, Recorder: recorder}
+
+			result, err := reconciler.Reconcile(ctx, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(backup)})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result != tt.wantResult {
+				t.Errorf("result = %v, want %v", result, tt.wantResult)
+			}
+
+			if err := c.Get(ctx, client.ObjectKeyFromObject(backup), backup); err != nil {
+				t.Fatal(err)
+			}
+			status := backup.Status
+			if status.Phase != tt.wantPhase {
+				t.Errorf("phase = %s, want %s", status.Phase, tt.wantPhase)
+			}
+			if tt.wantReason != "" {
+				condition := meta.FindStatusCondition(status.Conditions, itaallinonev1.ConditionTypeComplete)
+				if condition == nil || condition.Reason != tt.wantReason {
+					t.Errorf("condition = %v, want reason %s", condition, tt.wantReason)
+				}
+			}
+			if status.Size != tt.wantSize || status.Checksum != tt.wantChecksum {
+				t.Errorf("size, checksum = %d, %q, want %d, %q", status.Size, status.Checksum, tt.wantSize, tt.wantChecksum)
+			}
+			if tt.wantJob && (status.JobName != "backup-backup" || status.Path != "backup.tar.gz" || status.Version != "1.9.0") {
+				t.Errorf("Job, path, version = %q, %q, %q, want the ones of the Job", status.JobName, status.Path, status.Version)
+			}
+
+			k8sJob := &batchv1.Job{}
+			err = c.Get(ctx, types.NamespacedName{Namespace: "default", Name: "backup-backup"}, k8sJob)
+			if jobExists := err == nil; jobExists != tt.wantJob {
+				t.Errorf("Job exists = %v, want %v", jobExists, tt.wantJob)
+			}
+
+			events := recordedEvents(recorder)
+			if tt.wantEvent != "" && !strings.Contains(events, " "+tt.wantEvent+" ") {
+				t.Errorf("events = %q, want %s", events, tt.wantEvent)
+			}
+			if tt.wantEvent == "" && events != "" {
+				t.Errorf("events = %q, want none", events)
+			}
+		})
+	}
+}
@@ -0,0 +1,228 @@
+/*
+Copyright 2021 NEC Corporation.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+
+	itaallinonev1 "github.com/exastro-suite/it-automation-operator/api/v1"
+)
+
+const backupKind = "ITAutomationBackup"
+
+// Retained VolumeSnapshots are not owned by the backup, so their progress is polled.
+const volumeSnapshotRecheckInterval = 10 * time.Second
+
+// takeVolumeSnapshots stops the instance, snapshots both of its claims and starts it again as
+// soon as the snapshots are cut. The backup succeeds once the snapshots are ready to use.
+func (reconciler *ITAutomationBackupReconciler) takeVolumeSnapshots(ctx context.Context, backup *itaallinonev1.ITAutomationBackup, status *itaallinonev1.ITAutomationBackupStatus) (ctrl.Result, error) {
+	if !reconciler.VolumeSnapshotAvailable {
+		failBackup(backup, status, "VolumeSnapshotUnavailable", "The snapshot.storage.k8s.io API is not installed in the cluster")
+		return ctrl.Result{}, nil
+	}
+
+	snapshotFactories := []*VolumeSnapshotFactoryForVolume{
+		newVolumeSnapshotFactoryForVolume(reconciler, backup, fileVolumeName),
+		newVolumeSnapshotFactoryForVolume(reconciler, backup, databaseVolumeName),
+	}
+
+	var k8sVolumeSnapshots []*unstructured.Unstructured
+	for _, snapshotFactory := range snapshotFactories {
+		k8sVolumeSnapshot := newVolumeSnapshot()
+		err := reconciler.Get(ctx, snapshotFactory.GetNamespaceName(), k8sVolumeSnapshot)
+		if errors.IsNotFound(err) {
+			continue
+		} else if err != nil {
+			reconciler.Log.Error(err, "Failed to get resource", k8sResourceToLogParameters(k8sVolumeSnapshot)...)
+			return ctrl.Result{}, err
+		}
+		k8sVolumeSnapshots = append(k8sVolumeSnapshots, k8sVolumeSnapshot)
+	}
+
+	instance := &itaallinonev1.ITAutomationAllInOne{}
+	err := reconciler.Get(ctx, types.NamespacedName{Namespace: backup.Namespace, Name: backup.Spec.InstanceName}, instance)
+	if errors.IsNotFound(err) {
+		instance = nil
+	} else if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	if len(k8sVolumeSnapshots) < len(snapshotFactories) {
+		return reconciler.createVolumeSnapshots(ctx, backup, instance, snapshotFactories, status)
+	}
+
+	return reconciler.observeVolumeSnapshots(ctx, backup, instance, k8sVolumeSnapshots, status)
+}
+
+// createVolumeSnapshots creates the snapshots once no pod of the instance is left,
+// so that the database files on the volume are consistent
+func (reconciler *ITAutomationBackupReconciler) createVolumeSnapshots(ctx context.Context, backup *itaallinonev1.ITAutomationBackup, instance *itaallinonev1.ITAutomationAllInOne, snapshotFactories []*VolumeSnapshotFactoryForVolume, status *itaallinonev1.ITAutomationBackupStatus) (ctrl.Result, error) {
+	if instance == nil {
+		status.Phase = itaallinonev1.BackupPhasePending
+		setBackupCondition(backup, status, metav1.ConditionFalse, "InstanceNotFound",
+			fmt.Sprintf("ITAutomationAllInOne %s is not found", backup.Spec.InstanceName))
+		return ctrl.Result{RequeueAfter: instanceRecheckInterval}, nil
+	}
+
+	holder := quiescedBy(backupKind, backup.Name)
+	switch instance.Annotations[quiescedByAnnotation] {
+	case holder:
+	case "":
+		status.Phase = itaallinonev1.BackupPhasePending
+		if instance.Status.Phase != itaallinonev1.PhaseRunning {
+			setBackupCondition(backup, status, metav1.ConditionFalse, "InstanceNotReady",
+				fmt.Sprintf("ITAutomationAllInOne %s is not running", instance.Name))
+			return ctrl.Result{RequeueAfter: instanceRecheckInterval}, nil
+		}
+
+		setBackupCondition(backup, status, metav1.ConditionFalse, "Stopping",
+			fmt.Sprintf("ITAutomationAllInOne %s is stopping to take VolumeSnapshots", instance.Name))
+		return quiesceInstance(ctx, reconciler.Client, reconciler.Log, reconciler.Recorder, instance, holder)
+	default:
+		status.Phase = itaallinonev1.BackupPhasePending
+		setBackupCondition(backup, status, metav1.ConditionFalse, "InstanceBusy",
+			fmt.Sprintf("ITAutomationAllInOne %s is stopped by %s", instance.Name, instance.Annotations[quiescedByAnnotation]))
+		return ctrl.Result{RequeueAfter: instanceRecheckInterval}, nil
+	}
+
+	status.Phase = itaallinonev1.BackupPhaseRunning
+	if status.StartTime == nil {
+		now := metav1.Now()
+		status.StartTime = &now
+	}
+
+	podsExist, err := hasFrontendPods(ctx, reconciler.Client, instance)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	if podsExist {
+		setBackupCondition(backup, status, metav1.ConditionFalse, "Stopping",
+			fmt.Sprintf("ITAutomationAllInOne %s is stopping to take VolumeSnapshots", instance.Name))
+		return ctrl.Result{RequeueAfter: podTerminationRecheckInterval}, nil
+	}
+
+	setBackupCondition(backup, status, metav1.ConditionFalse, "TakingVolumeSnapshots",
+		fmt.Sprintf("VolumeSnapshots of ITAutomationAllInOne %s are being taken", instance.Name))
+
+	for _, snapshotFactory := range snapshotFactories {
+		snapshotFactory.Instance = instance
+		requeue, result, err := ensureK8sResourceCreated(ctx, reconciler.Client, reconciler.Log, snapshotFactory)
+		if requeue {
+			return result, err
+		}
+	}
+
+	return ctrl.Result{RequeueAfter: volumeSnapshotRecheckInterval}, nil
+}
+
+// observeVolumeSnapshots starts the instance again once the snapshots are cut or failed,
+// and completes the backup when they are ready to use
+func (reconciler *ITAutomationBackupReconciler) observeVolumeSnapshots(ctx context.Context, backup *itaallinonev1.ITAutomationBackup, instance *itaallinonev1.ITAutomationAllInOne, k8sVolumeSnapshots []*unstructured.Unstructured, status *itaallinonev1.ITAutomationBackupStatus) (ctrl.Result, error) {
+	status.Phase = itaallinonev1.BackupPhaseRunning
+	status.Version = k8sVolumeSnapshots[0].GetLabels()[versionLabel]
+
+	var failures []string
+	cut := true
+	ready := true
+	for _, k8sVolumeSnapshot := range k8sVolumeSnapshots {
+		if message, found, _ := unstructured.NestedString(k8sVolumeSnapshot.Object, "status", "error", "message"); found {
+			failures = append(failures, fmt.Sprintf("VolumeSnapshot %s failed: %s", k8sVolumeSnapshot.GetName(), message))
+		}
+		if _, found, _ := unstructured.NestedString(k8sVolumeSnapshot.Object, "status", "creationTime"); !found {
+			cut = false
+		}
+		if readyToUse, _, _ := unstructured.NestedBool(k8sVolumeSnapshot.Object, "status", "readyToUse"); !readyToUse {
+			ready = false
+		}
+	}
+
+	if instance != nil && instance.Annotations[quiescedByAnnotation] == quiescedBy(backupKind, backup.Name) && (cut || len(failures) > 0) {
+		setBackupCondition(backup, status, metav1.ConditionFalse, "Starting",
+			fmt.Sprintf("ITAutomationAllInOne %s is starting", instance.Name))
+		return releaseInstance(ctx, reconciler.Client, reconciler.Log, reconciler.Recorder, instance)
+	}
+
+	switch {
+	case len(failures) > 0:
+		failBackup(backup, status, "VolumeSnapshotFailed", strings.Join(failures, ", "))
+	case ready:
+		volumeSnapshots, size, err := reconciler.recordVolumeSnapshots(ctx, k8sVolumeSnapshots)
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+
+		now := metav1.Now()
+		status.Phase = itaallinonev1.BackupPhaseSucceeded
+		status.CompletionTime = &now
+		status.VolumeSnapshots = volumeSnapshots
+		status.Size = size
+		setBackupCondition(backup, status, metav1.ConditionTrue, "VolumeSnapshotsReady", "VolumeSnapshots are ready to use")
+	default:
+		setBackupCondition(backup, status, metav1.ConditionFalse, "TakingVolumeSnapshots", "VolumeSnapshots are being taken")
+		return ctrl.Result{RequeueAfter: volumeSnapshotRecheckInterval}, nil
+	}
+
+	return ctrl.Result{}, nil
+}
+
+// recordVolumeSnapshots describes the snapshots and their source claims for the restore,
+// and returns the total restore size in bytes
+func (reconciler *ITAutomationBackupReconciler) recordVolumeSnapshots(ctx context.Context, k8sVolumeSnapshots []*unstructured.Unstructured) ([]itaallinonev1.BackupVolumeSnapshot, int64, error) {
+	var volumeSnapshots []itaallinonev1.BackupVolumeSnapshot
+	var size int64
+	for _, k8sVolumeSnapshot := range k8sVolumeSnapshots {
+		volumeSnapshot := itaallinonev1.BackupVolumeSnapshot{
+			Name:       k8sVolumeSnapshot.GetName(),
+			VolumeName: k8sVolumeSnapshot.GetLabels()[componentLabel],
+		}
+
+		if restoreSize, found, _ := unstructured.NestedString(k8sVolumeSnapshot.Object, "status", "restoreSize"); found {
+			quantity, err := resource.ParseQuantity(restoreSize)
+			if err != nil {
+				return nil, 0, fmt.Errorf("failed to parse the restore size of VolumeSnapshot %s: %w", k8sVolumeSnapshot.GetName(), err)
+			}
+			volumeSnapshot.RestoreSize = &quantity
+			size += quantity.Value()
+		}
+
+		// The claim is gone if the instance was deleted meanwhile, in which case the defaults are used on restore
+		claimName, _, _ := unstructured.NestedString(k8sVolumeSnapshot.Object, "spec", "source", "persistentVolumeClaimName")
+		k8sPvc := &corev1.PersistentVolumeClaim{}
+		err := reconciler.Get(ctx, types.NamespacedName{Namespace: k8sVolumeSnapshot.GetNamespace(), Name: claimName}, k8sPvc)
+		if err == nil {
+			volumeSnapshot.StorageClassName = k8sPvc.Spec.StorageClassName
+			volumeSnapshot.AccessModes = k8sPvc.Spec.AccessModes
+		} else if !errors.IsNotFound(err) {
+			return nil, 0, err
+		}
+
+		volumeSnapshots = append(volumeSnapshots, volumeSnapshot)
+	}
+
+	return volumeSnapshots, size, nil
+}
@@ -0,0 +1,270 @@
+/*
+Copyright 2021 NEC Corporation.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	itaallinonev1 "github.com/exastro-suite/it-automation-operator/api/v1"
+)
+
+// newTestVolumeSnapshots returns the snapshots of both claims of the instance with the given status
+func newTestVolumeSnapshots(t *testing.T, backup *itaallinonev1.ITAutomationBackup, instance *itaallinonev1.ITAutomationAllInOne, status map[string]interface{}) []client.Object {
+	var k8sVolumeSnapshots []client.Object
+	for _, volumeName := range []string{fileVolumeName, databaseVolumeName} {
+		factory := newVolumeSnapshotFactoryForVolume(&ITAutomationBackupReconciler{Scheme: newTestScheme(t)}, backup, volumeName)
+		factory.Instance = instance
+		k8sVolumeSnapshot := factory.New().(*unstructured.Unstructured)
+		if status != nil {
+			k8sVolumeSnapshot.Object["status"] = runtime.DeepCopyJSON(status)
+		}
+		k8sVolumeSnapshots = append(k8sVolumeSnapshots, k8sVolumeSnapshot)
+	}
+	return k8sVolumeSnapshots
+}
+
+func TestVolumeSnapshotBackupPhases(t *testing.T) {
+	holder := quiescedBy(backupKind, "backup")
+	backup := newTestBackupWithMethod(itaallinonev1.BackupMethodVolumeSnapshot)
+
+	instanceAnnotated := func(quiescedByValue string) *itaallinonev1.ITAutomationAllInOne {
+		instance := newTestRunningInstance()
+		if quiescedByValue != "" {
+			instance.Annotations = map[string]string{quiescedByAnnotation: quiescedByValue}
+		}
+		return instance
+	}
+	deployingInstance := instanceAnnotated("")
+	deployingInstance.Status.Phase = itaallinonev1.PhaseDeploying
+	deletedBackup := backup.DeepCopy()
+	deletionTimestamp := metav1.Now()
+	deletedBackup.DeletionTimestamp = &deletionTimestamp
+
+	cut := map[string]interface{}{"creationTime": "2021-06-10T03:00:00Z", "readyToUse": false}
+	ready := map[string]interface{}{"creationTime": "2021-06-10T03:00:00Z", "readyToUse": true, "restoreSize": "1Gi"}
+	failed := map[string]interface{}{"error": map[string]interface{}{"message": "no space left"}}
+
+	tests := []struct {
+		name                      string
+		backup                    *itaallinonev1.ITAutomationBackup
+		volumeSnapshotUnavailable bool
+		instance                  *itaallinonev1.ITAutomationAllInOne
+		objects                   []client.Object
+		wantResult                ctrl.Result
+		wantPhase                 itaallinonev1.ITAutomationBackupPhase
+		wantReason                string
+		wantQuiescedBy            string
+		wantVolumeSnapshots       int
+		wantEvent                 string
+	}{
+		{
+			name:                      "VolumeSnapshot API is required",
+			volumeSnapshotUnavailable: true,
+			instance:                  instanceAnnotated(""),
+			wantPhase:                 itaallinonev1.BackupPhaseFailed,
+			wantReason:                "VolumeSnapshotUnavailable",
+			wantEvent:                 eventReasonBackupFailed,
+		},
+		{
+			name:       "instance which is not running is waited for",
+			instance:   deployingInstance,
+			wantResult: ctrl.Result{RequeueAfter: instanceRecheckInterval},
+			wantPhase:  itaallinonev1.BackupPhasePending,
+			wantReason: "InstanceNotReady",
+		},
+		{
+			name:           "running instance is stopped",
+			instance:       instanceAnnotated(""),
+			wantResult:     ctrl.Result{RequeueAfter: podTerminationRecheckInterval},
+			wantPhase:      itaallinonev1.BackupPhasePending,
+			wantReason:     "Stopping",
+			wantQuiescedBy: holder,
+			wantEvent:      eventReasonInstanceStopped,
+		},
+		{
+			name:           "instance stopped by a restore is waited for",
+			instance:       instanceAnnotated("ITAutomationRestore/restore"),
+			wantResult:     ctrl.Result{RequeueAfter: instanceRecheckInterval},
+			wantPhase:      itaallinonev1.BackupPhasePending,
+			wantReason:     "InstanceBusy",
+			wantQuiescedBy: "ITAutomationRestore/restore",
+		},
+		{
+			name:           "pods are waited for to terminate",
+			instance:       instanceAnnotated(holder),
+			objects:        []client.Object{newTestFrontendPod(newTestRunningInstance(), "")},
+			wantResult:     ctrl.Result{RequeueAfter: podTerminationRecheckInterval},
+			wantPhase:      itaallinonev1.BackupPhaseRunning,
+			wantReason:     "Stopping",
+			wantQuiescedBy: holder,
+			wantEvent:      eventReasonBackupStarted,
+		},
+		{
+			name:                "snapshots are taken once the pods are gone",
+			instance:            instanceAnnotated(holder),
+			wantResult:          ctrl.Result{Requeue: true},
+			wantPhase:           itaallinonev1.BackupPhaseRunning,
+			wantReason:          "TakingVolumeSnapshots",
+			wantQuiescedBy:      holder,
+			wantVolumeSnapshots: 1, // one per reconciliation
+			wantEvent:           eventReasonBackupStarted,
+		},
+		{
+			name:                "instance is started once the snapshots are cut",
+			instance:            instanceAnnotated(holder),
+			objects:             newTestVolumeSnapshots(t, backup, newTestRunningInstance(), cut),
+			wantResult:          ctrl.Result{RequeueAfter: instanceRecheckInterval},
+			wantPhase:           itaallinonev1.BackupPhaseRunning,
+			wantReason:          "Starting",
+			wantVolumeSnapshots: 2,
+			wantEvent:           eventReasonInstanceStarted,
+		},
+		{
+			name:                "instance is started when a snapshot fails",
+			instance:            instanceAnnotated(holder),
+			objects:             newTestVolumeSnapshots(t, backup, newTestRunningInstance(), failed),
+			wantResult:          ctrl.Result{RequeueAfter: instanceRecheckInterval},
+			wantPhase:           itaallinonev1.BackupPhaseRunning,
+			wantReason:          "Starting",
+			wantVolumeSnapshots: 2,
+			wantEvent:           eventReasonInstanceStarted,
+		},
+		{
+			name:                "cut snapshots are waited for to be ready",
+			instance:            instanceAnnotated(""),
+			objects:             newTestVolumeSnapshots(t, backup, newTestRunningInstance(), cut),
+			wantResult:          ctrl.Result{RequeueAfter: volumeSnapshotRecheckInterval},
+			wantPhase:           itaallinonev1.BackupPhaseRunning,
+			wantReason:          "TakingVolumeSnapshots",
+			wantVolumeSnapshots: 2,
+			wantEvent:           eventReasonBackupStarted,
+		},
+		{
+			name:                "failed snapshot fails the backup",
+			instance:            instanceAnnotated(""),
+			objects:             newTestVolumeSnapshots(t, backup, newTestRunningInstance(), failed),
+			wantPhase:           itaallinonev1.BackupPhaseFailed,
+			wantReason:          "VolumeSnapshotFailed",
+			wantVolumeSnapshots: 2,
+			wantEvent:           eventReasonBackupFailed,
+		},
+		{
+			name:                "ready snapshots complete the backup",
+			instance:            instanceAnnotated(""),
+			objects:             newTestVolumeSnapshots(t, backup, newTestRunningInstance(), ready),
+			wantPhase:           itaallinonev1.BackupPhaseSucceeded,
+			wantReason:          "VolumeSnapshotsReady",
+			wantVolumeSnapshots: 2,
+			wantEvent:           eventReasonBackupCompleted,
+		},
+		{
+			name:       "deleted backup starts the instance it stopped",
+			backup:     deletedBackup,
+			instance:   instanceAnnotated(holder),
+			wantResult: ctrl.Result{RequeueAfter: instanceRecheckInterval},
+			wantEvent:  eventReasonInstanceStarted,
+		},
+		{
+			name:           "deleted backup leaves an instance stopped by others alone",
+			backup:         deletedBackup,
+			instance:       instanceAnnotated("ITAutomationRestore/restore"),
+			wantQuiescedBy: "ITAutomationRestore/restore",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			scheme := newTestScheme(t)
+			backup := backup.DeepCopy()
+			if tt.backup != nil {
+				backup = tt.backup.DeepCopy()
+			}
+			objects := append([]client.Object{backup, tt.instance.DeepCopy()}, tt.objects...)
+			c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(objects...).Build()
+			recorder := record.NewFakeRecorder(100)
+			reconciler := &ITAutomationBackupReconciler{Client: c, Log: logr.Discard(), Scheme: scheme, Recorder: recorder,
+				VolumeSnapshotAvailable: !tt.volumeSnapshotUnavailable}
+
+			result, err := reconciler.Reconcile(ctx, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(backup)})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result != tt.wantResult {
+				t.Errorf("result = %v, want %v", result, tt.wantResult)
+			}
+
+			if err := c.Get(ctx, client.ObjectKeyFromObject(backup), backup); err != nil {
+				t.Fatal(err)
+			}
+			if backup.Status.Phase != tt.wantPhase {
+				t.Errorf("phase = %s, want %s", backup.Status.Phase, tt.wantPhase)
+			}
+			if tt.wantReason != "" {
+				condition := meta.FindStatusCondition(backup.Status.Conditions, itaallinonev1.ConditionTypeComplete)
+				if condition == nil || condition.Reason != tt.wantReason {
+					t.Errorf("condition = %v, want reason %s", condition, tt.wantReason)
+				}
+			}
+			if tt.wantPhase == itaallinonev1.BackupPhaseSucceeded && (len(backup.Status.VolumeSnapshots) != 2 || backup.Status.Size != 2<<30) {
+				t.Errorf("volume snapshots, size = %v, %d, want both snapshots of 2Gi", backup.Status.VolumeSnapshots, backup.Status.Size)
+			}
+
+			instance := &itaallinonev1.ITAutomationAllInOne{}
+			if err := c.Get(ctx, client.ObjectKeyFromObject(tt.instance), instance); err != nil {
+				t.Fatal(err)
+			}
+			if quiescedByValue := instance.Annotations[quiescedByAnnotation]; quiescedByValue != tt.wantQuiescedBy {
+				t.Errorf("quiesced by = %q, want %q", quiescedByValue, tt.wantQuiescedBy)
+			}
+
+			volumeSnapshots := 0
+			for _, volumeName := range []string{fileVolumeName, databaseVolumeName} {
+				err := c.Get(ctx, newVolumeSnapshotFactoryForVolume(reconciler, backup, volumeName).GetNamespaceName(), newVolumeSnapshot())
+				if err == nil {
+					volumeSnapshots++
+				} else if !errors.IsNotFound(err) {
+					t.Fatal(err)
+				}
+			}
+			if volumeSnapshots != tt.wantVolumeSnapshots {
+				t.Errorf("volume snapshots = %d, want %d", volumeSnapshots, tt.wantVolumeSnapshots)
+			}
+
+			events := recordedEvents(recorder)
+			if tt.wantEvent != "" && !strings.Contains(events, " "+tt.wantEvent+" ") {
+				t.Errorf("events = %q, want %s", events, tt.wantEvent)
+			}
+			if tt.wantEvent == "" && events != "" {
+				t.Errorf("events = %q, want none", events)
+			}
+		})
+	}
+}
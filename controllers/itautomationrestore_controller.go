@@ -0,0 +1,392 @@
+/*
+Copyright 2021 NEC Corporation.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/go-logr/logr"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	itaallinonev1 "github.com/exastro-suite/it-automation-operator/api/v1"
+)
+
+const restoreKind = "ITAutomationRestore"
+
+// ITAutomationRestoreReconciler reconciles a ITAutomationRestore object
+type ITAutomationRestoreReconciler struct {
+	client.Client
+	Log           logr.Logger
+	Scheme        *runtime.Scheme
+	ImageDefaults ImageDefaults
+	// VolumeSnapshotAvailable tells whether the cluster serves CSI VolumeSnapshots
+	VolumeSnapshotAvailable bool
+	Recorder                record.EventRecorder
+}
+
+//+kubebuilder:rbac:groups=ita-all-in-one.ita.exastro,resources=itautomationrestores,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=ita-all-in-one.ita.exastro,resources=itautomationrestores/status,verbs=get;update;patch
+//+kubebuilder:rbac:groups=ita-all-in-one.ita.exastro,resources=itautomationrestores/finalizers,verbs=update
+//+kubebuilder:rbac:groups=batch,resources=jobs,verbs=get;list;watch;create;update;delete
+//+kubebuilder:rbac:groups=core,resources=pods,verbs=get;list;watch
+//+kubebuilder:rbac:groups=core,resources=persistentvolumeclaims,verbs=get;list;watch;create
+//+kubebuilder:rbac:groups=core,resources=events,verbs=create;patch
+
+func (reconciler *ITAutomationRestoreReconciler) Reconcile(ctx context.Context, request ctrl.Request) (ctrl.Result, error) {
+	restore := &itaallinonev1.ITAutomationRestore{}
+	err := reconciler.Get(ctx, request.NamespacedName, restore)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			reconciler.Log.Info("Custom resource is not found. Ignoring since object must be deleted", k8sResourceToLogParameters(restore)...)
+			return ctrl.Result{}, nil
+		}
+
+		reconciler.Log.Error(err, "Failed to get custom resource", k8sResourceToLogParameters(restore)...)
+		return ctrl.Result{}, err
+	}
+
+	if !restore.DeletionTimestamp.IsZero() {
+		return reconciler.finalizeRestore(ctx, restore)
+	}
+
+	// A finished restore is never run again
+	if restore.Status.Phase == itaallinonev1.RestorePhaseSucceeded || restore.Status.Phase == itaallinonev1.RestorePhaseFailed {
+		return ctrl.Result{}, nil
+	}
+
+	// The finalizer releases the instance if the restore is deleted while it is stopped
+	if !controllerutil.ContainsFinalizer(restore, finalizerName) {
+		controllerutil.AddFinalizer(restore, finalizerName)
+		err = reconciler.Update(ctx, restore)
+		if err != nil && !errors.IsConflict(err) {
+			reconciler.Log.Error(err, "Failed to add finalizer", k8sResourceToLogParameters(restore)...)
+			return ctrl.Result{}, err
+		}
+		return ctrl.Result{Requeue: true}, nil
+	}
+
+	status := restore.Status.DeepCopy()
+	status.ObservedGeneration = restore.Generation
+	if status.StartTime == nil {
+		now := metav1.Now()
+		status.StartTime = &now
+	}
+
+	result, err := reconciler.runRestore(ctx, restore, status)
+	return reconciler.updateRestoreStatus(ctx, restore, status, result, err)
+}
+
+// runRestore advances the restore by one step at a time. The progress is derived from
+// the instance and the Jobs, so the status only reports it.
+func (reconciler *ITAutomationRestoreReconciler) runRestore(ctx context.Context, restore *itaallinonev1.ITAutomationRestore, status *itaallinonev1.ITAutomationRestoreStatus) (ctrl.Result, error) {
+	status.Phase = itaallinonev1.RestorePhasePending
+
+	backup := &itaallinonev1.ITAutomationBackup{}
+	err := reconciler.Get(ctx, types.NamespacedName{Namespace: restore.Namespace, Name: restore.Spec.BackupName}, backup)
+	if errors.IsNotFound(err) {
+		setRestoreCondition(restore, status, itaallinonev1.ConditionTypeBackupValidated, metav1.ConditionFalse, "BackupNotFound",
+			fmt.Sprintf("ITAutomationBackup %s is not found", restore.Spec.BackupName))
+		return ctrl.Result{RequeueAfter: instanceRecheckInterval}, nil
+	} else if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	switch backup.Status.Phase {
+	case itaallinonev1.BackupPhaseSucceeded:
+	case itaallinonev1.BackupPhaseFailed:
+		failRestore(restore, status, itaallinonev1.ConditionTypeBackupValidated, "BackupFailed",
+			fmt.Sprintf("ITAutomationBackup %s failed", backup.Name))
+		return ctrl.Result{}, nil
+	default:
+		setRestoreCondition(restore, status, itaallinonev1.ConditionTypeBackupValidated, metav1.ConditionFalse, "BackupNotSucceeded",
+			fmt.Sprintf("ITAutomationBackup %s is %s", backup.Name, backup.Status.Phase))
+		return ctrl.Result{RequeueAfter: instanceRecheckInterval}, nil
+	}
+
+	if backup.Spec.GetMethod() == itaallinonev1.BackupMethodVolumeSnapshot {
+		return reconciler.restoreVolumeSnapshots(ctx, restore, backup, status)
+	}
+
+	instance := &itaallinonev1.ITAutomationAllInOne{}
+	err = reconciler.Get(ctx, types.NamespacedName{Namespace: restore.Namespace, Name: restore.Spec.InstanceName}, instance)
+	if errors.IsNotFound(err) {
+		setRestoreCondition(restore, status, itaallinonev1.ConditionTypeBackupValidated, metav1.ConditionFalse, "InstanceNotFound",
+			fmt.Sprintf("ITAutomationAllInOne %s is not found", restore.Spec.InstanceName))
+		return ctrl.Result{RequeueAfter: instanceRecheckInterval}, nil
+	} else if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	// The running version differs from spec.version while an upgrade is held back or rolled back
+	instanceVersion := instance.Status.Version
+	if instanceVersion == "" {
+		instanceVersion = instance.Spec.Version
+	}
+	if backup.Status.Version != instanceVersion {
+		failRestore(restore, status, itaallinonev1.ConditionTypeBackupValidated, "VersionMismatch",
+			fmt.Sprintf("ITAutomationBackup %s is taken from version %s, but ITAutomationAllInOne %s is version %s",
+				backup.Name, backup.Status.Version, instance.Name, instanceVersion))
+		return ctrl.Result{}, nil
+	}
+
+	setRestoreCondition(restore, status, itaallinonev1.ConditionTypeBackupValidated, metav1.ConditionTrue, "Validated",
+		fmt.Sprintf("ITAutomationBackup %s of version %s can be restored", backup.Name, backup.Status.Version))
+	status.Phase = itaallinonev1.RestorePhaseRunning
+
+	filesJobFactory := newJobFactoryForRestore(reconciler, restore, restoreOperationFiles)
+	filesJobFactory.Instance = instance
+	filesJobFactory.Backup = backup
+	filesJob, err := getJob(ctx, reconciler.Client, filesJobFactory)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	if filesJob == nil || !isJobSucceeded(filesJob) {
+		return reconciler.restoreFiles(ctx, restore, instance, filesJobFactory, filesJob, status)
+	}
+
+	setRestoreCondition(restore, status, itaallinonev1.ConditionTypeFilesRestored, metav1.ConditionTrue, "JobSucceeded",
+		fmt.Sprintf("Job %s succeeded", filesJob.Name))
+
+	if instance.Annotations[quiescedByAnnotation] == quiescedBy(restoreKind, restore.Name) {
+		setRestoreCondition(restore, status, itaallinonev1.ConditionTypeInstanceStarted, metav1.ConditionFalse, "Starting",
+			fmt.Sprintf("ITAutomationAllInOne %s is starting", instance.Name))
+		return releaseInstance(ctx, reconciler.Client, reconciler.Log, reconciler.Recorder, instance)
+	}
+
+	databasePod, err := findReadyPod(ctx, reconciler.Client, instance)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	// A rolled back instance is Degraded, so only its pod is checked
+	if databasePod == nil {
+		setRestoreCondition(restore, status, itaallinonev1.ConditionTypeInstanceStarted, metav1.ConditionFalse, "Starting",
+			fmt.Sprintf("ITAutomationAllInOne %s is starting", instance.Name))
+		return ctrl.Result{RequeueAfter: instanceRecheckInterval}, nil
+	}
+
+	setRestoreCondition(restore, status, itaallinonev1.ConditionTypeInstanceStarted, metav1.ConditionTrue, "Running",
+		fmt.Sprintf("ITAutomationAllInOne %s is running", instance.Name))
+
+	databaseJobFactory := newJobFactoryForRestore(reconciler, restore, restoreOperationDatabase)
+	databaseJobFactory.Instance = instance
+	databaseJobFactory.Backup = backup
+	databaseJobFactory.DatabasePod = databasePod
+	requeue, result, err := ensureK8sResourceCreated(ctx, reconciler.Client, reconciler.Log, databaseJobFactory)
+	if requeue {
+		setRestoreCondition(restore, status, itaallinonev1.ConditionTypeDatabaseRestored, metav1.ConditionFalse, "JobRunning",
+			fmt.Sprintf("Job %s is running", databaseJobFactory.GetName()))
+		return result, err
+	}
+
+	databaseJob, err := getJob(ctx, reconciler.Client, databaseJobFactory)
+	if err != nil || databaseJob == nil {
+		return ctrl.Result{}, err
+	}
+
+	switch {
+	case isJobSucceeded(databaseJob):
+		setRestoreCondition(restore, status, itaallinonev1.ConditionTypeDatabaseRestored, metav1.ConditionTrue, "JobSucceeded",
+			fmt.Sprintf("Job %s succeeded", databaseJob.Name))
+		status.Phase = itaallinonev1.RestorePhaseSucceeded
+		status.CompletionTime = databaseJob.Status.CompletionTime
+	case isJobFailed(databaseJob):
+		failRestore(restore, status, itaallinonev1.ConditionTypeDatabaseRestored, "JobFailed",
+			fmt.Sprintf("Job %s failed: %s", databaseJob.Name, findJobCondition(databaseJob, batchv1.JobFailed).Message))
+	default:
+		setRestoreCondition(restore, status, itaallinonev1.ConditionTypeDatabaseRestored, metav1.ConditionFalse, "JobRunning",
+			fmt.Sprintf("Job %s is running", databaseJob.Name))
+	}
+
+	return ctrl.Result{}, nil
+}
+
+// restoreFiles stops the instance and replaces its file volume once no pod is left
+func (reconciler *ITAutomationRestoreReconciler) restoreFiles(ctx context.Context, restore *itaallinonev1.ITAutomationRestore, instance *itaallinonev1.ITAutomationAllInOne, filesJobFactory *JobFactoryForRestore, filesJob *batchv1.Job, status *itaallinonev1.ITAutomationRestoreStatus) (ctrl.Result, error) {
+	switch instance.Annotations[quiescedByAnnotation] {
+	case quiescedBy(restoreKind, restore.Name):
+	case "":
+		setRestoreCondition(restore, status, itaallinonev1.ConditionTypeInstanceStopped, metav1.ConditionFalse, "Stopping",
+			fmt.Sprintf("ITAutomationAllInOne %s is stopping", instance.Name))
+		return quiesceInstance(ctx, reconciler.Client, reconciler.Log, reconciler.Recorder, instance, quiescedBy(restoreKind, restore.Name))
+	default:
+		status.Phase = itaallinonev1.RestorePhasePending
+		setRestoreCondition(restore, status, itaallinonev1.ConditionTypeInstanceStopped, metav1.ConditionFalse, "InstanceBusy",
+			fmt.Sprintf("ITAutomationAllInOne %s is stopped by %s", instance.Name, instance.Annotations[quiescedByAnnotation]))
+		return ctrl.Result{RequeueAfter: instanceRecheckInterval}, nil
+	}
+
+	podsExist, err := hasFrontendPods(ctx, reconciler.Client, instance)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	if podsExist {
+		setRestoreCondition(restore, status, itaallinonev1.ConditionTypeInstanceStopped, metav1.ConditionFalse, "Stopping",
+			fmt.Sprintf("ITAutomationAllInOne %s is stopping", instance.Name))
+		return ctrl.Result{RequeueAfter: podTerminationRecheckInterval}, nil
+	}
+
+	setRestoreCondition(restore, status, itaallinonev1.ConditionTypeInstanceStopped, metav1.ConditionTrue, "Stopped",
+		fmt.Sprintf("ITAutomationAllInOne %s is stopped", instance.Name))
+
+	if filesJob == nil {
+		setRestoreCondition(restore, status, itaallinonev1.ConditionTypeFilesRestored, metav1.ConditionFalse, "JobRunning",
+			fmt.Sprintf("Job %s is running", filesJobFactory.GetName()))
+		_, result, err := ensureK8sResourceCreated(ctx, reconciler.Client, reconciler.Log, filesJobFactory)
+		return result, err
+	}
+
+	if isJobFailed(filesJob) {
+		failRestore(restore, status, itaallinonev1.ConditionTypeFilesRestored, "JobFailed",
+			fmt.Sprintf("Job %s failed: %s. ITAutomationAllInOne %s is kept stopped until the restore is deleted",
+				filesJob.Name, findJobCondition(filesJob, batchv1.JobFailed).Message, instance.Name))
+		return ctrl.Result{}, nil
+	}
+
+	setRestoreCondition(restore, status, itaallinonev1.ConditionTypeFilesRestored, metav1.ConditionFalse, "JobRunning",
+		fmt.Sprintf("Job %s is running", filesJob.Name))
+	return ctrl.Result{}, nil
+}
+
+// finalizeRestore starts the instance again if it is still stopped by the restore
+func (reconciler *ITAutomationRestoreReconciler) finalizeRestore(ctx context.Context, restore *itaallinonev1.ITAutomationRestore) (ctrl.Result, error) {
+	if !controllerutil.ContainsFinalizer(restore, finalizerName) {
+		return ctrl.Result{}, nil
+	}
+
+	instance := &itaallinonev1.ITAutomationAllInOne{}
+	err := reconciler.Get(ctx, types.NamespacedName{Namespace: restore.Namespace, Name: restore.Spec.InstanceName}, instance)
+	if err != nil && !errors.IsNotFound(err) {
+		return ctrl.Result{}, err
+	}
+	if err == nil && instance.Annotations[quiescedByAnnotation] == quiescedBy(restoreKind, restore.Name) {
+		return releaseInstance(ctx, reconciler.Client, reconciler.Log, reconciler.Recorder, instance)
+	}
+
+	controllerutil.RemoveFinalizer(restore, finalizerName)
+	err = reconciler.Update(ctx, restore)
+	if err != nil {
+		if errors.IsConflict(err) {
+			return ctrl.Result{Requeue: true}, nil
+		}
+
+		reconciler.Log.Error(err, "Failed to remove finalizer", k8sResourceToLogParameters(restore)...)
+		return ctrl.Result{}, err
+	}
+
+	return ctrl.Result{}, nil
+}
+
+func (reconciler *ITAutomationRestoreReconciler) updateRestoreStatus(ctx context.Context, restore *itaallinonev1.ITAutomationRestore, status *itaallinonev1.ITAutomationRestoreStatus, result ctrl.Result, reconcileErr error) (ctrl.Result, error) {
+	if equality.Semantic.DeepEqual(status, &restore.Status) {
+		return result, reconcileErr
+	}
+
+	previousPhase := restore.Status.Phase
+	restore.Status = *status
+	err := reconciler.Status().Update(ctx, restore)
+	if err != nil {
+		if errors.IsConflict(err) {
+			reconciler.Log.Info("Custom resource was modified concurrently. Retrying status update", k8sResourceToLogParameters(restore)...)
+			return ctrl.Result{Requeue: true}, reconcileErr
+		}
+
+		reconciler.Log.Error(err, "Failed to update status", k8sResourceToLogParameters(restore)...)
+		if reconcileErr != nil {
+			return result, reconcileErr
+		}
+		return result, err
+	}
+
+	if status.Phase != previousPhase {
+		reconciler.recordRestoreEvent(ctx, restore)
+	}
+
+	return result, reconcileErr
+}
+
+// recordRestoreEvent records the phase the restore has moved to, once the status is stored.
+// Rollbacks of upgrades are restores too, and are recorded the same way.
+func (reconciler *ITAutomationRestoreReconciler) recordRestoreEvent(ctx context.Context, restore *itaallinonev1.ITAutomationRestore) {
+	switch restore.Status.Phase {
+	case itaallinonev1.RestorePhaseRunning:
+		recordInstanceEvent(ctx, reconciler.Client, reconciler.Recorder, restore, restore.Spec.InstanceName, corev1.EventTypeNormal, eventReasonRestoreStarted,
+			"ITAutomationRestore %s of ITAutomationBackup %s onto ITAutomationAllInOne %s started", restore.Name, restore.Spec.BackupName, restore.Spec.InstanceName)
+	case itaallinonev1.RestorePhaseSucceeded:
+		recordInstanceEvent(ctx, reconciler.Client, reconciler.Recorder, restore, restore.Spec.InstanceName, corev1.EventTypeNormal, eventReasonRestoreCompleted,
+			"ITAutomationRestore %s of ITAutomationBackup %s onto ITAutomationAllInOne %s completed", restore.Name, restore.Spec.BackupName, restore.Spec.InstanceName)
+	case itaallinonev1.RestorePhaseFailed:
+		message := ""
+		for _, condition := range restore.Status.Conditions {
+			if condition.Status == metav1.ConditionFalse {
+				message = condition.Message
+			}
+		}
+		recordInstanceEvent(ctx, reconciler.Client, reconciler.Recorder, restore, restore.Spec.InstanceName, corev1.EventTypeWarning, eventReasonRestoreFailed,
+			"ITAutomationRestore %s of ITAutomationBackup %s onto ITAutomationAllInOne %s failed: %s", restore.Name, restore.Spec.BackupName, restore.Spec.InstanceName, message)
+	}
+}
+
+func failRestore(restore *itaallinonev1.ITAutomationRestore, status *itaallinonev1.ITAutomationRestoreStatus, conditionType string, reason string, message string) {
+	now := metav1.Now()
+	status.Phase = itaallinonev1.RestorePhaseFailed
+	status.CompletionTime = &now
+	setRestoreCondition(restore, status, conditionType, metav1.ConditionFalse, reason, message)
+}
+
+func setRestoreCondition(restore *itaallinonev1.ITAutomationRestore, status *itaallinonev1.ITAutomationRestoreStatus, conditionType string, conditionStatus metav1.ConditionStatus, reason string, message string) {
+	meta.SetStatusCondition(&status.Conditions, metav1.Condition{
+		Type:               conditionType,
+		Status:             conditionStatus,
+		ObservedGeneration: restore.Generation,
+		Reason:             reason,
+		Message:            message,
+	})
+}
+
+// getJob returns the Job of the factory, or nil if it does not exist
+func getJob(ctx context.Context, reader client.Reader, jobFactory K8sResourceFactory) (*batchv1.Job, error) {
+	k8sJob := &batchv1.Job{}
+	err := reader.Get(ctx, jobFactory.GetNamespaceName(), k8sJob)
+	if errors.IsNotFound(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	return k8sJob, nil
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (reconciler *ITAutomationRestoreReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&itaallinonev1.ITAutomationRestore{}).
+		Owns(&batchv1.Job{}).
+		Complete(reconciler)
+}
@@ -0,0 +1,435 @@
+/*
+Copyright 2021 NEC Corporation.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/go-logr/logr"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	itaallinonev1 "github.com/exastro-suite/it-automation-operator/api/v1"
+)
+
+// newTestSucceededBackup returns an archive of version 1.9.0 named "backup"
+func newTestSucceededBackup() *itaallinonev1.ITAutomationBackup {
+	backup := newTestBackupWithMethod(itaallinonev1.BackupMethodArchive)
+	backup.Status = itaallinonev1.ITAutomationBackupStatus{Phase: itaallinonev1.BackupPhaseSucceeded, Version: "1.9.0",
+		Path: "backup.tar.gz", Checksum: "sha256:0123"}
+	return backup
+}
+
+func TestJobFactoryForRestore(t *testing.T) {
+	tests := []struct {
+		name          string
+		operation     string
+		wantScript    string
+		wantClaims    map[string]bool
+		wantEnv       bool
+		wantWorkspace bool
+	}{
+		{
+			name:       "files are restored onto the stopped instance",
+			operation:  restoreOperationFiles,
+			wantScript: createFileRestoreScript("/backup/backup.tar.gz", "sha256:0123"),
+			wantClaims: map[string]bool{"backups": true, "ita-file-volume": false},
+		},
+		{
+			name:          "database is imported into the running instance",
+			operation:     restoreOperationDatabase,
+			wantScript:    createDatabaseRestoreScript("/backup/backup.tar.gz"),
+			wantClaims:    map[string]bool{"backups": true},
+			wantEnv:       true,
+			wantWorkspace: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// The image of the backup is used, even if the instance is meant to run another version
+			instance := newTestRunningInstance()
+			instance.Spec.Version = "1.10.0"
+			restore := newTestRestore("ita")
+			factory := newJobFactoryForRestore(&ITAutomationRestoreReconciler{Scheme: newTestScheme(t)}, restore, tt.operation)
+			factory.Instance = instance
+			factory.Backup = newTestSucceededBackup()
+			factory.DatabasePod = newTestFrontendPod(instance, "10.0.0.1")
+			k8sJob := factory.New().(*batchv1.Job)
+
+			if k8sJob.Name != "restore-"+tt.operation {
+				t.Errorf("Job = %s, want restore-%s", k8sJob.Name, tt.operation)
+			}
+			if !metav1.IsControlledBy(k8sJob, restore) {
+				t.Errorf("owner references = %v, want the restore", k8sJob.OwnerReferences)
+			}
+
+			podSpec := k8sJob.Spec.Template.Spec
+			container := podSpec.Containers[0]
+			if want := "ghcr.io/exastro-suite/it-automation:1.9.0-ubi8-ja"; container.Image != want {
+				t.Errorf("image = %s, want %s", container.Image, want)
+			}
+			if script := container.Command[len(container.Command)-1]; script != tt.wantScript {
+				t.Errorf("script = %q, want %q", script, tt.wantScript)
+			}
+			if host := findEnvVar(container.Env, "DATABASE_HOST"); (host != nil && host.Value == "10.0.0.1") != tt.wantEnv {
+				t.Errorf("DATABASE_HOST = %v, want it set %v", host, tt.wantEnv)
+			}
+
+			claims := map[string]bool{}
+			workspace := false
+			for _, volume := range podSpec.Volumes {
+				if claim := volume.PersistentVolumeClaim; claim != nil {
+					claims[claim.ClaimName] = claim.ReadOnly
+				}
+				if volume.EmptyDir != nil {
+					workspace = true
+				}
+			}
+			if len(claims) != len(tt.wantClaims) {
+				t.Errorf("claims = %v, want %v", claims, tt.wantClaims)
+			}
+			for claimName, readOnly := range tt.wantClaims {
+				if gotReadOnly, found := claims[claimName]; !found || gotReadOnly != readOnly {
+					t.Errorf("claims = %v, want %v", claims, tt.wantClaims)
+				}
+			}
+			if workspace != tt.wantWorkspace {
+				t.Errorf("workspace = %v, want %v", workspace, tt.wantWorkspace)
+			}
+		})
+	}
+}
+
+func TestRestoreScripts(t *testing.T) {
+	tests := []struct {
+		name      string
+		script    string
+		wantLines []string
+	}{
+		{
+			name:   "files are verified before the volume is emptied",
+			script: createFileRestoreScript("/backup/backup.tar.gz", "sha256:0123"),
+			wantLines: []string{
+				"set -e",
+				`echo "0123  /backup/backup.tar.gz" | sha256sum -c -`,
+				"find /exastro-file-volume -mindepth 1 -delete",
+				"tar -xzf /backup/backup.tar.gz -C / exastro-file-volume",
+			},
+		},
+		{
+			name:   "dump is imported into the database of the instance",
+			script: createDatabaseRestoreScript("/backup/backup.tar.gz"),
+			wantLines: []string{
+				"set -e",
+				"tar -xzf /backup/backup.tar.gz -C /work database.sql",
+				`mysql --host="$DATABASE_HOST" --port="$DATABASE_PORT" --user="$DATABASE_USER" $DATABASE_TLS_OPTIONS "$DATABASE_NAME" < /work/database.sql`,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if want := strings.Join(tt.wantLines, "\n") + "\n"; tt.script != want {
+				t.Errorf("script = %q, want %q", tt.script, want)
+			}
+		})
+	}
+}
+
+func TestRestorePhases(t *testing.T) {
+	holder := quiescedBy(restoreKind, "restore")
+	backup := newTestSucceededBackup()
+	runningBackup := newTestBackupWithMethod(itaallinonev1.BackupMethodArchive)
+	runningBackup.Status.Phase = itaallinonev1.BackupPhaseRunning
+	failedBackup := newTestBackupWithMethod(itaallinonev1.BackupMethodArchive)
+	failedBackup.Status.Phase = itaallinonev1.BackupPhaseFailed
+
+	instanceAnnotated := func(quiescedByValue string) *itaallinonev1.ITAutomationAllInOne {
+		instance := newTestRunningInstance()
+		if quiescedByValue != "" {
+			instance.Annotations = map[string]string{quiescedByAnnotation: quiescedByValue}
+		}
+		return instance
+	}
+	otherVersionInstance := instanceAnnotated("")
+	otherVersionInstance.Status.Version = "1.10.0"
+	restoreJob := func(operation string, conditionType batchv1.JobConditionType) *batchv1.Job {
+		k8sJob := &batchv1.Job{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "restore-" + operation}}
+		if conditionType != "" {
+			k8sJob.Status.Conditions = []batchv1.JobCondition{{Type: conditionType, Status: corev1.ConditionTrue, Message: "BackoffLimitExceeded"}}
+		}
+		return k8sJob
+	}
+	stoppedPod := newTestFrontendPod(newTestRunningInstance(), "")
+	readyPod := newTestFrontendPod(newTestRunningInstance(), "10.0.0.1")
+	deletedRestore := newTestRestore("ita")
+	deletedRestore.Finalizers = []string{finalizerName}
+	deletionTimestamp := metav1.Now()
+	deletedRestore.DeletionTimestamp = &deletionTimestamp
+
+	tests := []struct {
+		name              string
+		restore           *itaallinonev1.ITAutomationRestore
+		objects           []client.Object
+		wantResult        ctrl.Result
+		wantPhase         itaallinonev1.ITAutomationRestorePhase
+		wantConditionType string
+		wantReason        string
+		wantQuiescedBy    string
+		wantJobs          []string
+		wantEvent         string
+	}{
+		{
+			name:              "missing backup is waited for",
+			objects:           []client.Object{instanceAnnotated("")},
+			wantResult:        ctrl.Result{RequeueAfter: instanceRecheckInterval},
+			wantPhase:         itaallinonev1.RestorePhasePending,
+			wantConditionType: itaallinonev1.ConditionTypeBackupValidated,
+			wantReason:        "BackupNotFound",
+		},
+		{
+			name:              "running backup is waited for",
+			objects:           []client.Object{runningBackup, instanceAnnotated("")},
+			wantResult:        ctrl.Result{RequeueAfter: instanceRecheckInterval},
+			wantPhase:         itaallinonev1.RestorePhasePending,
+			wantConditionType: itaallinonev1.ConditionTypeBackupValidated,
+			wantReason:        "BackupNotSucceeded",
+		},
+		{
+			name:              "failed backup is not restored",
+			objects:           []client.Object{failedBackup, instanceAnnotated("")},
+			wantPhase:         itaallinonev1.RestorePhaseFailed,
+			wantConditionType: itaallinonev1.ConditionTypeBackupValidated,
+			wantReason:        "BackupFailed",
+			wantEvent:         eventReasonRestoreFailed,
+		},
+		{
+			name:              "missing instance is waited for",
+			objects:           []client.Object{backup},
+			wantResult:        ctrl.Result{RequeueAfter: instanceRecheckInterval},
+			wantPhase:         itaallinonev1.RestorePhasePending,
+			wantConditionType: itaallinonev1.ConditionTypeBackupValidated,
+			wantReason:        "InstanceNotFound",
+		},
+		{
+			name:              "backup of another version is not restored",
+			objects:           []client.Object{backup, otherVersionInstance},
+			wantPhase:         itaallinonev1.RestorePhaseFailed,
+			wantConditionType: itaallinonev1.ConditionTypeBackupValidated,
+			wantReason:        "VersionMismatch",
+			wantEvent:         eventReasonRestoreFailed,
+		},
+		{
+			name:              "running instance is stopped",
+			objects:           []client.Object{backup, instanceAnnotated(""), readyPod},
+			wantResult:        ctrl.Result{RequeueAfter: podTerminationRecheckInterval},
+			wantPhase:         itaallinonev1.RestorePhaseRunning,
+			wantConditionType: itaallinonev1.ConditionTypeInstanceStopped,
+			wantReason:        "Stopping",
+			wantQuiescedBy:    holder,
+			wantEvent:         eventReasonInstanceStopped,
+		},
+		{
+			name:              "instance stopped by a backup is waited for",
+			objects:           []client.Object{backup, instanceAnnotated("ITAutomationBackup/other")},
+			wantResult:        ctrl.Result{RequeueAfter: instanceRecheckInterval},
+			wantPhase:         itaallinonev1.RestorePhasePending,
+			wantConditionType: itaallinonev1.ConditionTypeInstanceStopped,
+			wantReason:        "InstanceBusy",
+			wantQuiescedBy:    "ITAutomationBackup/other",
+		},
+		{
+			name:              "pods are waited for to terminate",
+			objects:           []client.Object{backup, instanceAnnotated(holder), stoppedPod},
+			wantResult:        ctrl.Result{RequeueAfter: podTerminationRecheckInterval},
+			wantPhase:         itaallinonev1.RestorePhaseRunning,
+			wantConditionType: itaallinonev1.ConditionTypeInstanceStopped,
+			wantReason:        "Stopping",
+			wantQuiescedBy:    holder,
+			wantEvent:         eventReasonRestoreStarted,
+		},
+		{
+			name:              "files are restored once the pods are gone",
+			objects:           []client.Object{backup, instanceAnnotated(holder)},
+			wantResult:        ctrl.Result{Requeue: true},
+			wantPhase:         itaallinonev1.RestorePhaseRunning,
+			wantConditionType: itaallinonev1.ConditionTypeFilesRestored,
+			wantReason:        "JobRunning",
+			wantQuiescedBy:    holder,
+			wantJobs:          []string{"restore-restore-files"},
+			wantEvent:         eventReasonRestoreStarted,
+		},
+		{
+			name:              "failed file restore keeps the instance stopped",
+			objects:           []client.Object{backup, instanceAnnotated(holder), restoreJob(restoreOperationFiles, batchv1.JobFailed)},
+			wantPhase:         itaallinonev1.RestorePhaseFailed,
+			wantConditionType: itaallinonev1.ConditionTypeFilesRestored,
+			wantReason:        "JobFailed",
+			wantQuiescedBy:    holder,
+			wantJobs:          []string{"restore-restore-files"},
+			wantEvent:         eventReasonRestoreFailed,
+		},
+		{
+			name:              "instance is started once the files are restored",
+			objects:           []client.Object{backup, instanceAnnotated(holder), restoreJob(restoreOperationFiles, batchv1.JobComplete)},
+			wantResult:        ctrl.Result{RequeueAfter: instanceRecheckInterval},
+			wantPhase:         itaallinonev1.RestorePhaseRunning,
+			wantConditionType: itaallinonev1.ConditionTypeInstanceStarted,
+			wantReason:        "Starting",
+			wantJobs:          []string{"restore-restore-files"},
+			wantEvent:         eventReasonInstanceStarted,
+		},
+		{
+			name:              "started instance is waited for to be ready",
+			objects:           []client.Object{backup, instanceAnnotated(""), stoppedPod, restoreJob(restoreOperationFiles, batchv1.JobComplete)},
+			wantResult:        ctrl.Result{RequeueAfter: instanceRecheckInterval},
+			wantPhase:         itaallinonev1.RestorePhaseRunning,
+			wantConditionType: itaallinonev1.ConditionTypeInstanceStarted,
+			wantReason:        "Starting",
+			wantJobs:          []string{"restore-restore-files"},
+			wantEvent:         eventReasonRestoreStarted,
+		},
+		{
+			name:              "database is restored into the ready instance",
+			objects:           []client.Object{backup, instanceAnnotated(""), readyPod, restoreJob(restoreOperationFiles, batchv1.JobComplete)},
+			wantResult:        ctrl.Result{Requeue: true},
+			wantPhase:         itaallinonev1.RestorePhaseRunning,
+			wantConditionType: itaallinonev1.ConditionTypeDatabaseRestored,
+			wantReason:        "JobRunning",
+			wantJobs:          []string{"restore-restore-database", "restore-restore-files"},
+			wantEvent:         eventReasonRestoreStarted,
+		},
+		{
+			name: "restored database completes the restore",
+			objects: []client.Object{backup, instanceAnnotated(""), readyPod, restoreJob(restoreOperationFiles, batchv1.JobComplete),
+				restoreJob(restoreOperationDatabase, batchv1.JobComplete)},
+			wantPhase:         itaallinonev1.RestorePhaseSucceeded,
+			wantConditionType: itaallinonev1.ConditionTypeDatabaseRestored,
+			wantReason:        "JobSucceeded",
+			wantJobs:          []string{"restore-restore-database", "restore-restore-files"},
+			wantEvent:         eventReasonRestoreCompleted,
+		},
+		{
+			name: "failed database restore fails the restore",
+			objects: []client.Object{backup, instanceAnnotated(""), readyPod, restoreJob(restoreOperationFiles, batchv1.JobComplete),
+				restoreJob(restoreOperationDatabase, batchv1.JobFailed)},
+			wantPhase:         itaallinonev1.RestorePhaseFailed,
+			wantConditionType: itaallinonev1.ConditionTypeDatabaseRestored,
+			wantReason:        "JobFailed",
+			wantJobs:          []string{"restore-restore-database", "restore-restore-files"},
+			wantEvent:         eventReasonRestoreFailed,
+		},
+		{
+			name:       "deleted restore starts the instance it stopped",
+			restore:    deletedRestore,
+			objects:    []client.Object{backup, instanceAnnotated(holder)},
+			wantResult: ctrl.Result{RequeueAfter: instanceRecheckInterval},
+			wantEvent:  eventReasonInstanceStarted,
+		},
+		{
+			name:           "deleted restore leaves an instance stopped by others alone",
+			restore:        deletedRestore,
+			objects:        []client.Object{backup, instanceAnnotated("ITAutomationBackup/other")},
+			wantQuiescedBy: "ITAutomationBackup/other",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			scheme := newTestScheme(t)
+			restore := tt.restore
+			if restore == nil {
+				restore = newTestRestore("ita")
+				restore.Finalizers = []string{finalizerName}
+			}
+			restore = restore.DeepCopy()
+			var objects []client.Object
+			for _, object := range append([]client.Object{restore}, tt.objects...) {
+				objects = append(objects, object.DeepCopyObject().(client.Object))
+			}
+			c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(objects...).Build()
+			recorder := record.NewFakeRecorder(100)
+			reconciler := &ITAutomationRestoreReconciler{Client: c, Log: logr.Discard(), Scheme: scheme, Recorder: recorder}
+
+			result, err := reconciler.Reconcile(ctx, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(restore)})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result != tt.wantResult {
+				t.Errorf("result = %v, want %v", result, tt.wantResult)
+			}
+
+			if err := c.Get(ctx, client.ObjectKeyFromObject(restore), restore); err != nil {
+				t.Fatal(err)
+			}
+			if restore.Status.Phase != tt.wantPhase {
+				t.Errorf("phase = %s, want %s", restore.Status.Phase, tt.wantPhase)
+			}
+			if tt.wantConditionType != "" {
+				condition := meta.FindStatusCondition(restore.Status.Conditions, tt.wantConditionType)
+				if condition == nil || condition.Reason != tt.wantReason {
+					t.Errorf("%s condition = %v, want reason %s", tt.wantConditionType, condition, tt.wantReason)
+				}
+			}
+			if tt.wantPhase == itaallinonev1.RestorePhaseFailed && restore.Status.CompletionTime == nil {
+				t.Errorf("completion time is not set")
+			}
+
+			instance := &itaallinonev1.ITAutomationAllInOne{}
+			err = c.Get(ctx, types.NamespacedName{Namespace: "default", Name: "ita"}, instance)
+			if err != nil && !errors.IsNotFound(err) {
+				t.Fatal(err)
+			}
+			if quiescedByValue := instance.Annotations[quiescedByAnnotation]; quiescedByValue != tt.wantQuiescedBy {
+				t.Errorf("quiesced by = %q, want %q", quiescedByValue, tt.wantQuiescedBy)
+			}
+
+			// Jobs are listed by name
+			k8sJobs := &batchv1.JobList{}
+			if err := c.List(ctx, k8sJobs); err != nil {
+				t.Fatal(err)
+			}
+			var jobs []string
+			for _, k8sJob := range k8sJobs.Items {
+				jobs = append(jobs, k8sJob.Name)
+			}
+			if strings.Join(jobs, ",") != strings.Join(tt.wantJobs, ",") {
+				t.Errorf("Jobs = %v, want %v", jobs, tt.wantJobs)
+			}
+
+			events := recordedEvents(recorder)
+			if tt.wantEvent != "" && !strings.Contains(events, " "+tt.wantEvent+" ") {
+				t.Errorf("events = %q, want %s", events, tt.wantEvent)
+			}
+			if tt.wantEvent == "" && events != "" {
+				t.Errorf("events = %q, want none", events)
+			}
+		})
+	}
+}
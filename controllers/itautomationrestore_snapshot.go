@@ -0,0 +1,121 @@
+/*
+Copyright 2021 NEC Corporation.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+
+	itaallinonev1 "github.com/exastro-suite/it-automation-operator/api/v1"
+)
+
+// restoreVolumeSnapshots provisions the claims of a new instance from the VolumeSnapshots of
+// the backup, and completes once the instance is created with those claims and running
+func (reconciler *ITAutomationRestoreReconciler) restoreVolumeSnapshots(ctx context.Context, restore *itaallinonev1.ITAutomationRestore, backup *itaallinonev1.ITAutomationBackup, status *itaallinonev1.ITAutomationRestoreStatus) (ctrl.Result, error) {
+	if !reconciler.VolumeSnapshotAvailable {
+		failRestore(restore, status, itaallinonev1.ConditionTypeBackupValidated, "VolumeSnapshotUnavailable",
+			"The snapshot.storage.k8s.io API is not installed in the cluster")
+		return ctrl.Result{}, nil
+	}
+
+	setRestoreCondition(restore, status, itaallinonev1.ConditionTypeBackupValidated, metav1.ConditionTrue, "Validated",
+		fmt.Sprintf("ITAutomationBackup %s of version %s can be restored", backup.Name, backup.Status.Version))
+	status.Phase = itaallinonev1.RestorePhaseRunning
+
+	instance := &itaallinonev1.ITAutomationAllInOne{}
+	err := reconciler.Get(ctx, types.NamespacedName{Namespace: restore.Namespace, Name: restore.Spec.InstanceName}, instance)
+	if errors.IsNotFound(err) {
+		instance = nil
+	} else if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	for i := range backup.Status.VolumeSnapshots {
+		claimFactory := newPersistentVolumeClaimFactoryForSnapshot(reconciler, restore, &backup.Status.VolumeSnapshots[i])
+		k8sPvc := &corev1.PersistentVolumeClaim{}
+		err := reconciler.Get(ctx, claimFactory.GetNamespaceName(), k8sPvc)
+		switch {
+		case err == nil:
+			if k8sPvc.Annotations[restoredByAnnotation] != restore.Name {
+				failRestore(restore, status, itaallinonev1.ConditionTypeClaimsProvisioned, "ClaimExists",
+					fmt.Sprintf("PersistentVolumeClaim %s already exists", k8sPvc.Name))
+				return ctrl.Result{}, nil
+			}
+		case errors.IsNotFound(err):
+			// Claims are only provisioned before the instance exists, so that it never runs on empty volumes
+			if instance != nil {
+				failRestore(restore, status, itaallinonev1.ConditionTypeClaimsProvisioned, "InstanceExists",
+					fmt.Sprintf("ITAutomationAllInOne %s already exists. A VolumeSnapshot backup can only be restored to a new instance", instance.Name))
+				return ctrl.Result{}, nil
+			}
+
+			setRestoreCondition(restore, status, itaallinonev1.ConditionTypeClaimsProvisioned, metav1.ConditionFalse, "Provisioning",
+				fmt.Sprintf("PersistentVolumeClaim %s is being provisioned", claimFactory.GetName()))
+			_, result, err := ensureK8sResourceCreated(ctx, reconciler.Client, reconciler.Log, claimFactory)
+			return result, err
+		default:
+			return ctrl.Result{}, err
+		}
+	}
+
+	setRestoreCondition(restore, status, itaallinonev1.ConditionTypeClaimsProvisioned, metav1.ConditionTrue, "Provisioned",
+		"PersistentVolumeClaims are provisioned from the VolumeSnapshots")
+
+	if instance == nil {
+		setRestoreCondition(restore, status, itaallinonev1.ConditionTypeInstanceStarted, metav1.ConditionFalse, "WaitingForInstance",
+			fmt.Sprintf("Create ITAutomationAllInOne %s of version %s without filePvcName and databasePvcName to start it on the restored claims",
+				restore.Spec.InstanceName, backup.Status.Version))
+		return ctrl.Result{RequeueAfter: instanceRecheckInterval}, nil
+	}
+
+	if backup.Status.Version != instance.Spec.Version {
+		failRestore(restore, status, itaallinonev1.ConditionTypeBackupValidated, "VersionMismatch",
+			fmt.Sprintf("ITAutomationBackup %s is taken from version %s, but ITAutomationAllInOne %s is version %s",
+				backup.Name, backup.Status.Version, instance.Name, instance.Spec.Version))
+		return ctrl.Result{}, nil
+	}
+
+	if instance.Spec.FilePvcName != "" || instance.Spec.DatabasePvcName != "" {
+		failRestore(restore, status, itaallinonev1.ConditionTypeInstanceStarted, "ClaimsNotUsed",
+			fmt.Sprintf("ITAutomationAllInOne %s names its own claims instead of the restored claims", instance.Name))
+		return ctrl.Result{}, nil
+	}
+
+	databasePod, err := findReadyPod(ctx, reconciler.Client, instance)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	if instance.Status.Phase != itaallinonev1.PhaseRunning || databasePod == nil {
+		setRestoreCondition(restore, status, itaallinonev1.ConditionTypeInstanceStarted, metav1.ConditionFalse, "Starting",
+			fmt.Sprintf("ITAutomationAllInOne %s is starting", instance.Name))
+		return ctrl.Result{RequeueAfter: instanceRecheckInterval}, nil
+	}
+
+	now := metav1.Now()
+	status.Phase = itaallinonev1.RestorePhaseSucceeded
+	status.CompletionTime = &now
+	setRestoreCondition(restore, status, itaallinonev1.ConditionTypeInstanceStarted, metav1.ConditionTrue, "Running",
+		fmt.Sprintf("ITAutomationAllInOne %s is running", instance.Name))
+
+	return ctrl.Result{}, nil
+}
@@ -0,0 +1,184 @@
+/*
+Copyright 2021 NEC Corporation.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	itaallinonev1 "github.com/exastro-suite/it-automation-operator/api/v1"
+)
+
+func TestVolumeSnapshotRestorePhases(t *testing.T) {
+	backup := newTestBackupWithMethod(itaallinonev1.BackupMethodVolumeSnapshot)
+	backup.Status = itaallinonev1.ITAutomationBackupStatus{Phase: itaallinonev1.BackupPhaseSucceeded, Version: "1.9.0",
+		VolumeSnapshots: []itaallinonev1.BackupVolumeSnapshot{
+			{VolumeName: fileVolumeName, Name: "backup-file-volume"},
+			{VolumeName: databaseVolumeName, Name: "backup-database-volume"},
+		}}
+
+	var restoredClaims []client.Object
+	for i := range backup.Status.VolumeSnapshots {
+		factory := newPersistentVolumeClaimFactoryForSnapshot(&ITAutomationRestoreReconciler{Scheme: newTestScheme(t)}, newTestRestore("ita"), &backup.Status.VolumeSnapshots[i])
+		restoredClaims = append(restoredClaims, factory.New())
+	}
+	foreignClaim := &corev1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "ita-file-volume"}}
+
+	startingInstance := newTestRunningInstance()
+	startingInstance.Status.Phase = itaallinonev1.PhaseDeploying
+	otherVersionInstance := newTestRunningInstance()
+	otherVersionInstance.Spec.Version = "1.10.0"
+	ownClaimsInstance := newTestRunningInstance()
+	ownClaimsInstance.Spec.FilePvcName = "files"
+	readyPod := newTestFrontendPod(newTestRunningInstance(), "10.0.0.1")
+
+	tests := []struct {
+		name                      string
+		volumeSnapshotUnavailable bool
+		objects                   []client.Object
+		wantResult                ctrl.Result
+		wantPhase                 itaallinonev1.ITAutomationRestorePhase
+		wantConditionType         string
+		wantReason                string
+		wantClaims                int
+	}{
+		{
+			name:                      "VolumeSnapshot API is required",
+			volumeSnapshotUnavailable: true,
+			wantPhase:                 itaallinonev1.RestorePhaseFailed,
+			wantConditionType:         itaallinonev1.ConditionTypeBackupValidated,
+			wantReason:                "VolumeSnapshotUnavailable",
+		},
+		{
+			name:              "claims are provisioned before the instance is created",
+			wantResult:        ctrl.Result{Requeue: true},
+			wantPhase:         itaallinonev1.RestorePhaseRunning,
+			wantConditionType: itaallinonev1.ConditionTypeClaimsProvisioned,
+			wantReason:        "Provisioning",
+			wantClaims:        1, // one per reconciliation
+		},
+		{
+			name:              "existing instance is not restored onto",
+			objects:           []client.Object{newTestRunningInstance()},
+			wantPhase:         itaallinonev1.RestorePhaseFailed,
+			wantConditionType: itaallinonev1.ConditionTypeClaimsProvisioned,
+			wantReason:        "InstanceExists",
+		},
+		{
+			name:              "claims of others are not replaced",
+			objects:           []client.Object{foreignClaim},
+			wantPhase:         itaallinonev1.RestorePhaseFailed,
+			wantConditionType: itaallinonev1.ConditionTypeClaimsProvisioned,
+			wantReason:        "ClaimExists",
+			wantClaims:        1,
+		},
+		{
+			name:              "provisioned claims wait for the instance",
+			objects:           restoredClaims,
+			wantResult:        ctrl.Result{RequeueAfter: instanceRecheckInterval},
+			wantPhase:         itaallinonev1.RestorePhaseRunning,
+			wantConditionType: itaallinonev1.ConditionTypeInstanceStarted,
+			wantReason:        "WaitingForInstance",
+			wantClaims:        2,
+		},
+		{
+			name:              "instance of another version is not started on the claims",
+			objects:           append([]client.Object{otherVersionInstance}, restoredClaims...),
+			wantPhase:         itaallinonev1.RestorePhaseFailed,
+			wantConditionType: itaallinonev1.ConditionTypeBackupValidated,
+			wantReason:        "VersionMismatch",
+			wantClaims:        2,
+		},
+		{
+			name:              "instance naming its own claims does not use the restored ones",
+			objects:           append([]client.Object{ownClaimsInstance}, restoredClaims...),
+			wantPhase:         itaallinonev1.RestorePhaseFailed,
+			wantConditionType: itaallinonev1.ConditionTypeInstanceStarted,
+			wantReason:        "ClaimsNotUsed",
+			wantClaims:        2,
+		},
+		{
+			name:              "starting instance is waited for",
+			objects:           append([]client.Object{startingInstance, readyPod}, restoredClaims...),
+			wantResult:        ctrl.Result{RequeueAfter: instanceRecheckInterval},
+			wantPhase:         itaallinonev1.RestorePhaseRunning,
+			wantConditionType: itaallinonev1.ConditionTypeInstanceStarted,
+			wantReason:        "Starting",
+			wantClaims:        2,
+		},
+		{
+			name:              "running instance completes the restore",
+			objects:           append([]client.Object{newTestRunningInstance(), readyPod}, restoredClaims...),
+			wantPhase:         itaallinonev1.RestorePhaseSucceeded,
+			wantConditionType: itaallinonev1.ConditionTypeInstanceStarted,
+			wantReason:        "Running",
+			wantClaims:        2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			scheme := newTestScheme(t)
+			restore := newTestRestore("ita")
+			restore.Finalizers = []string{finalizerName}
+			var objects []client.Object
+			for _, object := range append([]client.Object{restore, backup}, tt.objects...) {
+				objects = append(objects, object.DeepCopyObject().(client.Object))
+			}
+			c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(objects...).Build()
+			reconciler := &ITAutomationRestoreReconciler{Client: c, Log: logr.Discard(), Scheme: scheme, Recorder: record.NewFakeRecorder(100),
+				VolumeSnapshotAvailable: !tt.volumeSnapshotUnavailable}
+
+			result, err := reconciler.Reconcile(ctx, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(restore)})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result != tt.wantResult {
+				t.Errorf("result = %v, want %v", result, tt.wantResult)
+			}
+
+			if err := c.Get(ctx, client.ObjectKeyFromObject(restore), restore); err != nil {
+				t.Fatal(err)
+			}
+			if restore.Status.Phase != tt.wantPhase {
+				t.Errorf("phase = %s, want %s", restore.Status.Phase, tt.wantPhase)
+			}
+			condition := meta.FindStatusCondition(restore.Status.Conditions, tt.wantConditionType)
+			if condition == nil || condition.Reason != tt.wantReason {
+				t.Errorf("%s condition = %v, want reason %s", tt.wantConditionType, condition, tt.wantReason)
+			}
+
+			k8sPvcs := &corev1.PersistentVolumeClaimList{}
+			if err := c.List(ctx, k8sPvcs); err != nil {
+				t.Fatal(err)
+			}
+			if len(k8sPvcs.Items) != tt.wantClaims {
+				t.Errorf("claims = %d, want %d", len(k8sPvcs.Items), tt.wantClaims)
+			}
+		})
+	}
+}
@@ -0,0 +1,250 @@
+/*
+Copyright 2021 NEC Corporation.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"fmt"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	itaallinonev1 "github.com/exastro-suite/it-automation-operator/api/v1"
+)
+
+// JobFactoryForBackup creates the Job which dumps the database of a running instance
+// and archives it together with the file volume into the target claim.
+// The Job writes the size and the checksum of the archive to its termination message.
+type JobFactoryForBackup struct {
+	k8sResourceFactoryBase
+	Reconciler     *ITAutomationBackupReconciler
+	CustomResource *itaallinonev1.ITAutomationBackup
+	Instance       *itaallinonev1.ITAutomationAllInOne
+	// DatabasePod is a ready frontend pod of the instance the database is dumped from
+	DatabasePod *corev1.Pod
+}
+
+func newJobFactoryForBackup(reconciler *ITAutomationBackupReconciler, backup *itaallinonev1.ITAutomationBackup) *JobFactoryForBackup {
+	return &JobFactoryForBackup{
+		k8sResourceFactoryBase: newK8sResourceFactoryBase(backup, reconciler.Scheme, backup.Name+"-backup", &batchv1.Job{}),
+		Reconciler:             reconciler,
+		CustomResource:         backup,
+	}
+}
+
+func (factory *JobFactoryForBackup) New() client.Object {
+	labels := createJobLabels(factory.Instance.Name, "backup")
+	jobLabels := mergeStringMaps(labels, map[string]string{
+		versionLabel: factory.Instance.Status.Version,
+	})
+	backoffLimit := int32(2)
+	securityContext, podSecurityContext := createSecurityContexts(factory.Instance)
+	pullPolicy, pullSecrets := imagePullSettings(factory.Instance)
+
+	image := factory.Instance.Status.Image
+	if image == "" {
+		image = resolveImage(factory.Instance, factory.Instance.Spec.Version, factory.Reconciler.ImageDefaults)
+	}
+
+	k8sJob := &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: factory.GetNamespace(),
+			Name:      factory.GetName(),
+			Labels:    jobLabels,
+		},
+		Spec: batchv1.JobSpec{
+			BackoffLimit: &backoffLimit,
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels: labels,
+				},
+				Spec: corev1.PodSpec{
+					Containers: []corev1.Container{
+						{
+							Name:            "backup",
+							Image:           image,
+							ImagePullPolicy: pullPolicy,
+							Command:         []string{"/bin/sh", "-c", createBackupScript(backupArchiveName(factory.CustomResource))},
+							Env:             createDatabaseClientEnv(factory.Instance, factory.DatabasePod),
+							SecurityContext: securityContext,
+							VolumeMounts: []corev1.VolumeMount{
+								{
+									Name:      fileVolumeName,
+									MountPath: "/exastro-file-volume",
+									ReadOnly:  true,
+								},
+								{
+									Name:      "backup",
+									MountPath: backupMountPath,
+								},
+							},
+						},
+					},
+					RestartPolicy:    corev1.RestartPolicyNever,
+					ImagePullSecrets: pullSecrets,
+					SecurityContext:  podSecurityContext,
+					Volumes: []corev1.Volume{
+						{
+							Name: fileVolumeName,
+							VolumeSource: corev1.VolumeSource{
+								PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{
+									ClaimName: factory.Instance.GetFilePvcName(),
+									ReadOnly:  true,
+								},
+							},
+						},
+						{
+							Name: "backup",
+							VolumeSource: corev1.VolumeSource{
+								PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{
+									ClaimName: factory.CustomResource.Spec.Target.PvcName,
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+
+	applyJobPodTemplate(factory.Instance, &k8sJob.Spec.Template.Spec)
+
+	// The file volume may only be attachable to a single node, so the Job runs next to the instance.
+	k8sJob.Spec.Template.Spec.Affinity = &corev1.Affinity{
+		PodAffinity: &corev1.PodAffinity{
+			RequiredDuringSchedulingIgnoredDuringExecution: []corev1.PodAffinityTerm{
+				{
+					LabelSelector: &metav1.LabelSelector{
+						MatchLabels: createLabels(factory.Instance),
+					},
+					TopologyKey: corev1.LabelHostname,
+				},
+			},
+		},
+	}
+
+	factory.setOwner(k8sJob)
+
+	return k8sJob
+}
+
+// Merge leaves the Job untouched since the pod template of a Job is immutable.
+func (factory *JobFactoryForBackup) Merge(k8sResource client.Object, desiredK8sResource client.Object) {
+}
+
+func backupArchiveName(backup *itaallinonev1.ITAutomationBackup) string {
+	return backup.Name + ".tar.gz"
+}
+
+// createBackupScript dumps the database in a single transaction and archives the dump
+// together with the file volume. The archive contains "database.sql" and "exastro-file-volume/".
+// The dump does not name the database, so that it can be imported into one named differently.
+func createBackupScript(archiveName string) string {
+	return fmt.Sprintf(`set -e
+work=%[1]s/.%[2]s.tmp
+rm -rf "$work"
+mkdir -p "$work"
+mysqldump --host="$DATABASE_HOST" --port="$DATABASE_PORT" --user="$DATABASE_USER" $DATABASE_TLS_OPTIONS --single-transaction --routines --triggers "$DATABASE_NAME" > "$work/database.sql"
+tar -czf "$work/archive.tar.gz" -C "$work" database.sql -C / exastro-file-volume
+mv "$work/archive.tar.gz" %[1]s/%[2]s
+rm -rf "$work"
+size=$(stat -c %%s %[1]s/%[2]s)
+checksum=$(sha256sum %[1]s/%[2]s | cut -d ' ' -f 1)
+echo "{\"size\": $size, \"checksum\": \"sha256:$checksum\"}" > /dev/termination-log
+`, backupMountPath, archiveName)
+}
+
+// JobFactoryForArchiveCleanup creates the Job which deletes the archive of a backup from
+// its target. The instance may already be gone, so the pod is derived from the backup Job.
+type JobFactoryForArchiveCleanup struct {
+	k8sResourceFactoryBase
+	Reconciler     *ITAutomationBackupReconciler
+	CustomResource *itaallinonev1.ITAutomationBackup
+	BackupJob      *batchv1.Job
+}
+
+func newJobFactoryForArchiveCleanup(reconciler *ITAutomationBackupReconciler, backup *itaallinonev1.ITAutomationBackup) *JobFactoryForArchiveCleanup {
+	return &JobFactoryForArchiveCleanup{
+		k8sResourceFactoryBase: newK8sResourceFactoryBase(backup, reconciler.Scheme, backup.Name+"-cleanup", &batchv1.Job{}),
+		Reconciler:             reconciler,
+		CustomResource:         backup,
+	}
+}
+
+func (factory *JobFactoryForArchiveCleanup) New() client.Object {
+	labels := createJobLabels(factory.CustomResource.Spec.InstanceName, "backup-cleanup")
+	backoffLimit := int32(2)
+	backupPodSpec := factory.BackupJob.Spec.Template.Spec
+	backupContainer := backupPodSpec.Containers[0]
+	archiveName := backupArchiveName(factory.CustomResource)
+
+	var volumes []corev1.Volume
+	for _, volume := range backupPodSpec.Volumes {
+		if volume.Name == "backup" {
+			volumes = append(volumes, volume)
+		}
+	}
+
+	k8sJob := &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: factory.GetNamespace(),
+			Name:      factory.GetName(),
+			Labels:    labels,
+		},
+		Spec: batchv1.JobSpec{
+			BackoffLimit: &backoffLimit,
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels: labels,
+				},
+				Spec: corev1.PodSpec{
+					Containers: []corev1.Container{
+						{
+							Name:            "cleanup",
+							Image:           backupContainer.Image,
+							ImagePullPolicy: backupContainer.ImagePullPolicy,
+							Command: []string{"/bin/sh", "-c", fmt.Sprintf("rm -rf %[1]s/.%[2]s.tmp %[1]s/%[2]s",
+								backupMountPath, archiveName)},
+							SecurityContext: backupContainer.SecurityContext,
+							VolumeMounts: []corev1.VolumeMount{
+								{
+									Name:      "backup",
+									MountPath: backupMountPath,
+								},
+							},
+						},
+					},
+					RestartPolicy:    corev1.RestartPolicyNever,
+					ImagePullSecrets: backupPodSpec.ImagePullSecrets,
+					SecurityContext:  backupPodSpec.SecurityContext,
+					NodeSelector:     backupPodSpec.NodeSelector,
+					Tolerations:      backupPodSpec.Tolerations,
+					Volumes:          volumes,
+				},
+			},
+		},
+	}
+
+	factory.setOwner(k8sJob)
+
+	return k8sJob
+}
+
+// Merge leaves the Job untouched since the pod template of a Job is immutable.
+func (factory *JobFactoryForArchiveCleanup) Merge(k8sResource client.Object, desiredK8sResource client.Object) {
+}
@@ -0,0 +1,149 @@
+/*
+Copyright 2021 NEC Corporation.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"strconv"
+	"strings"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	itaallinonev1 "github.com/exastro-suite/it-automation-operator/api/v1"
+)
+
+const volumeOperationInitialize = "initialize"
+
+// initializingVolumesAnnotation records on the Job the volumes it initializes
+const initializingVolumesAnnotation = "ita-all-in-one.ita.exastro/volumes"
+
+// JobFactoryForInitialization creates the Job which initializes the volumes of an image
+// supporting ImageFeatureInitializationJob. The frontend must be stopped while it is running.
+type JobFactoryForInitialization struct {
+	k8sResourceFactoryBase
+	Reconciler     *ITAutomationAllInOneReconciler
+	CustomResource *itaallinonev1.ITAutomationAllInOne
+	// Version of the image which initializes the volumes, the one the frontend is going to run
+	Version string
+	// PendingVolumes are the names of the volumes the Job initializes
+	PendingVolumes []string
+}
+
+func newJobFactoryForInitialization(reconciler *ITAutomationAllInOneReconciler, customResource *itaallinonev1.ITAutomationAllInOne) *JobFactoryForInitialization {
+	return &JobFactoryForInitialization{
+		k8sResourceFactoryBase: newK8sResourceFactoryBase(customResource, reconciler.Scheme, customResource.Name+"-"+volumeOperationInitialize, &batchv1.Job{}),
+		Reconciler:             reconciler,
+		CustomResource:         customResource,
+	}
+}
+
+func (factory *JobFactoryForInitialization) New() client.Object {
+	labels := createJobLabels(factory.CustomResource.Name, volumeOperationInitialize)
+	backoffLimit := int32(0)
+	activeDeadlineSeconds := volumeJobActiveDeadlineSeconds
+	securityContext, podSecurityContext := createSecurityContexts(factory.CustomResource)
+	pullPolicy, pullSecrets := imagePullSettings(factory.CustomResource)
+	volumeMounts, volumes := createInstanceVolumes(factory.CustomResource)
+
+	env := []corev1.EnvVar{
+		{
+			Name:  "EXASTRO_AUTO_FILE_VOLUME_INIT",
+			Value: strconv.FormatBool(containsString(factory.PendingVolumes, fileVolumeName)),
+		},
+		{
+			Name:  "EXASTRO_AUTO_DATABASE_VOLUME_INIT",
+			Value: strconv.FormatBool(containsString(factory.PendingVolumes, databaseVolumeName)),
+		},
+	}
+	env = append(env, createCredentialsContainerEnv(factory.CustomResource)...)
+
+	// The requests the volumes are initialized for, so that a new request replaces the Job
+	annotations := map[string]string{
+		initializingVolumesAnnotation: strings.Join(factory.PendingVolumes, ","),
+	}
+	for _, volumeName := range factory.PendingVolumes {
+		annotations[reinitializeAnnotationPrefix+volumeName] = factory.CustomResource.Annotations[reinitializeAnnotationPrefix+volumeName]
+	}
+
+	k8sJob := &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:   factory.GetNamespace(),
+			Name:        factory.GetName(),
+			Labels:      labels,
+			Annotations: annotations,
+		},
+		Spec: batchv1.JobSpec{
+			// A partly initialized volume is not initialized again without a request
+			BackoffLimit:          &backoffLimit,
+			ActiveDeadlineSeconds: &activeDeadlineSeconds,
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels: labels,
+				},
+				Spec: corev1.PodSpec{
+					Containers: []corev1.Container{
+						{
+							Name:            volumeOperationInitialize,
+							Image:           resolveImage(factory.CustomResource, factory.Version, factory.Reconciler.ImageDefaults),
+							ImagePullPolicy: pullPolicy,
+							// The entrypoint of the image initializes the volumes before running its arguments
+							Args:            []string{"true"},
+							Env:             env,
+							SecurityContext: securityContext,
+							VolumeMounts:    volumeMounts,
+						},
+					},
+					RestartPolicy:    corev1.RestartPolicyNever,
+					ImagePullSecrets: pullSecrets,
+					SecurityContext:  podSecurityContext,
+					Volumes:          volumes,
+				},
+			},
+		},
+	}
+
+	applyJobPodTemplate(factory.CustomResource, &k8sJob.Spec.Template.Spec)
+	// The Job runs the installer of ITA itself, which needs the resources of the frontend
+	k8sJob.Spec.Template.Spec.Containers[0].Resources = corev1.ResourceRequirements{}
+	if podTemplate := factory.CustomResource.Spec.PodTemplate; podTemplate != nil {
+		k8sJob.Spec.Template.Spec.Containers[0].Resources = podTemplate.Resources
+	}
+
+	factory.setOwner(k8sJob)
+
+	return k8sJob
+}
+
+// Merge leaves the Job untouched since the pod template of a Job is immutable.
+// A Job for other requests is deleted and created again instead.
+func (factory *JobFactoryForInitialization) Merge(k8sResource client.Object, desiredK8sResource client.Object) {
+}
+
+// isCurrent reports whether the Job initializes each pending volume for its current request.
+// The Job may have initialized more volumes, which are already marked.
+func (factory *JobFactoryForInitialization) isCurrent(k8sJob *batchv1.Job) bool {
+	volumeNames := strings.Split(k8sJob.Annotations[initializingVolumesAnnotation], ",")
+	for _, volumeName := range factory.PendingVolumes {
+		annotation := reinitializeAnnotationPrefix + volumeName
+		if !containsString(volumeNames, volumeName) || k8sJob.Annotations[annotation] != factory.CustomResource.Annotations[annotation] {
+			return false
+		}
+	}
+	return true
+}
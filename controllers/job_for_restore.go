@@ -0,0 +1,171 @@
+/*
+Copyright 2021 NEC Corporation.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"fmt"
+	"strings"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	itaallinonev1 "github.com/exastro-suite/it-automation-operator/api/v1"
+)
+
+const (
+	restoreOperationFiles    = "restore-files"
+	restoreOperationDatabase = "restore-database"
+)
+
+// JobFactoryForRestore creates the Jobs which restore a backup archive onto an instance.
+// The files are restored while the instance is stopped, and the database is imported
+// into the instance after it is started again.
+type JobFactoryForRestore struct {
+	k8sResourceFactoryBase
+	Reconciler     *ITAutomationRestoreReconciler
+	CustomResource *itaallinonev1.ITAutomationRestore
+	Instance       *itaallinonev1.ITAutomationAllInOne
+	Backup         *itaallinonev1.ITAutomationBackup
+	// Operation is either restoreOperationFiles or restoreOperationDatabase
+	Operation string
+	// DatabasePod is a ready frontend pod of the instance the dump is imported into
+	DatabasePod *corev1.Pod
+}
+
+func newJobFactoryForRestore(reconciler *ITAutomationRestoreReconciler, restore *itaallinonev1.ITAutomationRestore, operation string) *JobFactoryForRestore {
+	return &JobFactoryForRestore{
+		k8sResourceFactoryBase: newK8sResourceFactoryBase(restore, reconciler.Scheme, restore.Name+"-"+operation, &batchv1.Job{}),
+		Reconciler:             reconciler,
+		CustomResource:         restore,
+		Operation:              operation,
+	}
+}
+
+func (factory *JobFactoryForRestore) New() client.Object {
+	labels := createJobLabels(factory.Instance.Name, factory.Operation)
+	backoffLimit := int32(2)
+	securityContext, podSecurityContext := createSecurityContexts(factory.Instance)
+	pullPolicy, pullSecrets := imagePullSettings(factory.Instance)
+	archivePath := backupMountPath + "/" + factory.Backup.Status.Path
+
+	container := corev1.Container{
+		Name:            factory.Operation,
+		Image:           resolveImage(factory.Instance, factory.Backup.Status.Version, factory.Reconciler.ImageDefaults),
+		ImagePullPolicy: pullPolicy,
+		SecurityContext: securityContext,
+		VolumeMounts: []corev1.VolumeMount{
+			{
+				Name:      "backup",
+				MountPath: backupMountPath,
+				ReadOnly:  true,
+			},
+		},
+	}
+	volumes := []corev1.Volume{
+		{
+			Name: "backup",
+			VolumeSource: corev1.VolumeSource{
+				PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{
+					ClaimName: factory.Backup.Spec.Target.PvcName,
+					ReadOnly:  true,
+				},
+			},
+		},
+	}
+
+	if factory.Operation == restoreOperationFiles {
+		container.Command = []string{"/bin/sh", "-c", createFileRestoreScript(archivePath, factory.Backup.Status.Checksum)}
+		container.VolumeMounts = append(container.VolumeMounts, corev1.VolumeMount{
+			Name:      fileVolumeName,
+			MountPath: "/exastro-file-volume",
+		})
+		volumes = append(volumes, corev1.Volume{
+			Name: fileVolumeName,
+			VolumeSource: corev1.VolumeSource{
+				PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{
+					ClaimName: factory.Instance.GetFilePvcName(),
+				},
+			},
+		})
+	} else {
+		container.Command = []string{"/bin/sh", "-c", createDatabaseRestoreScript(archivePath)}
+		container.Env = createDatabaseClientEnv(factory.Instance, factory.DatabasePod)
+		container.VolumeMounts = append(container.VolumeMounts, corev1.VolumeMount{
+			Name:      "work",
+			MountPath: "/work",
+		})
+		volumes = append(volumes, corev1.Volume{
+			Name: "work",
+			VolumeSource: corev1.VolumeSource{
+				EmptyDir: &corev1.EmptyDirVolumeSource{},
+			},
+		})
+	}
+
+	k8sJob := &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: factory.GetNamespace(),
+			Name:      factory.GetName(),
+			Labels:    labels,
+		},
+		Spec: batchv1.JobSpec{
+			BackoffLimit: &backoffLimit,
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels: labels,
+				},
+				Spec: corev1.PodSpec{
+					Containers:       []corev1.Container{container},
+					RestartPolicy:    corev1.RestartPolicyNever,
+					ImagePullSecrets: pullSecrets,
+					SecurityContext:  podSecurityContext,
+					Volumes:          volumes,
+				},
+			},
+		},
+	}
+
+	applyJobPodTemplate(factory.Instance, &k8sJob.Spec.Template.Spec)
+
+	factory.setOwner(k8sJob)
+
+	return k8sJob
+}
+
+// Merge leaves the Job untouched since the pod template of a Job is immutable.
+func (factory *JobFactoryForRestore) Merge(k8sResource client.Object, desiredK8sResource client.Object) {
+}
+
+// createFileRestoreScript verifies the archive and replaces the contents of the file volume with it
+func createFileRestoreScript(archivePath string, checksum string) string {
+	return fmt.Sprintf(`set -e
+echo "%[2]s  %[1]s" | sha256sum -c -
+find /exastro-file-volume -mindepth 1 -delete
+tar -xzf %[1]s -C / exastro-file-volume
+`, archivePath, strings.TrimPrefix(checksum, "sha256:"))
+}
+
+// createDatabaseRestoreScript imports the database dump of the archive into the running instance.
+// Older dumps select the embedded database themselves.
+func createDatabaseRestoreScript(archivePath string) string {
+	return fmt.Sprintf(`set -e
+tar -xzf %[1]s -C /work database.sql
+mysql --host="$DATABASE_HOST" --port="$DATABASE_PORT" --user="$DATABASE_USER" $DATABASE_TLS_OPTIONS "$DATABASE_NAME" < /work/database.sql
+`, archivePath)
+}
@@ -0,0 +1,183 @@
+/*
+Copyright 2021 NEC Corporation.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"fmt"
+	"strings"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	itaallinonev1 "github.com/exastro-suite/it-automation-operator/api/v1"
+)
+
+const (
+	volumeOperationWipe        = "wipe"
+	volumeOperationFinalBackup = "final-backup"
+)
+
+const backupMountPath = "/backup"
+
+// volumeJobActiveDeadlineSeconds fails a Job whose pod cannot be scheduled or hangs,
+// so that the deletion is reported as blocked instead of waiting forever
+const volumeJobActiveDeadlineSeconds = int64(2 * 60 * 60)
+
+// JobFactoryForVolumes creates a Job which runs a shell script against the volumes
+// of the instance. The instance must be stopped while the Job is running.
+type JobFactoryForVolumes struct {
+	k8sResourceFactoryBase
+	Reconciler     *ITAutomationAllInOneReconciler
+	CustomResource *itaallinonev1.ITAutomationAllInOne
+	// Operation is the suffix of the Job name and the component of its pod
+	Operation string
+	Script    string
+	// BackupPvcName is mounted at /backup when set
+	BackupPvcName string
+}
+
+func newJobFactoryForVolumes(reconciler *ITAutomationAllInOneReconciler, customResource *itaallinonev1.ITAutomationAllInOne, operation string) *JobFactoryForVolumes {
+	return &JobFactoryForVolumes{
+		k8sResourceFactoryBase: newK8sResourceFactoryBase(customResource, reconciler.Scheme, customResource.Name+"-"+operation, &batchv1.Job{}),
+		Reconciler:             reconciler,
+		CustomResource:         customResource,
+		Operation:              operation,
+	}
+}
+
+func (factory *JobFactoryForVolumes) New() client.Object {
+	labels := createJobLabels(factory.CustomResource.Name, factory.Operation)
+	backoffLimit := int32(2)
+	activeDeadlineSeconds := volumeJobActiveDeadlineSeconds
+	securityContext, podSecurityContext := createSecurityContexts(factory.CustomResource)
+	pullPolicy, pullSecrets := imagePullSettings(factory.CustomResource)
+
+	volumeMounts, volumes := createInstanceVolumes(factory.CustomResource)
+	if factory.BackupPvcName != "" {
+		volumeMounts = append(volumeMounts, corev1.VolumeMount{
+			Name:      "backup",
+			MountPath: backupMountPath,
+		})
+		volumes = append(volumes, corev1.Volume{
+			Name: "backup",
+			VolumeSource: corev1.VolumeSource{
+				PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{
+					ClaimName: factory.BackupPvcName,
+				},
+			},
+		})
+	}
+
+	k8sJob := &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: factory.GetNamespace(),
+			Name:      factory.GetName(),
+			Labels:    labels,
+		},
+		Spec: batchv1.JobSpec{
+			BackoffLimit:          &backoffLimit,
+			ActiveDeadlineSeconds: &activeDeadlineSeconds,
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels: labels,
+				},
+				Spec: corev1.PodSpec{
+					Containers: []corev1.Container{
+						{
+							Name:            factory.Operation,
+							Image:           resolveImage(factory.CustomResource, factory.CustomResource.Spec.Version, factory.Reconciler.ImageDefaults),
+							ImagePullPolicy: pullPolicy,
+							Command:         []string{"/bin/sh", "-c", factory.Script},
+							SecurityContext: securityContext,
+							VolumeMounts:    volumeMounts,
+						},
+					},
+					RestartPolicy:    corev1.RestartPolicyNever,
+					ImagePullSecrets: pullSecrets,
+					SecurityContext:  podSecurityContext,
+					Volumes:          volumes,
+				},
+			},
+		},
+	}
+
+	applyJobPodTemplate(factory.CustomResource, &k8sJob.Spec.Template.Spec)
+
+	factory.setOwner(k8sJob)
+
+	return k8sJob
+}
+
+// Merge leaves the Job untouched since the pod template of a Job is immutable.
+func (factory *JobFactoryForVolumes) Merge(k8sResource client.Object, desiredK8sResource client.Object) {
+}
+
+// createInstanceVolumes returns the claims of the instance and where the containers of its Jobs mount them
+func createInstanceVolumes(customResource *itaallinonev1.ITAutomationAllInOne) ([]corev1.VolumeMount, []corev1.Volume) {
+	volumeMounts := []corev1.VolumeMount{
+		{
+			Name:      fileVolumeName,
+			MountPath: "/exastro-file-volume",
+		},
+		{
+			Name:      databaseVolumeName,
+			MountPath: "/exastro-database-volume",
+		},
+	}
+	volumes := []corev1.Volume{
+		{
+			Name: fileVolumeName,
+			VolumeSource: corev1.VolumeSource{
+				PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{
+					ClaimName: customResource.GetFilePvcName(),
+				},
+			},
+		},
+		{
+			Name: databaseVolumeName,
+			VolumeSource: corev1.VolumeSource{
+				PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{
+					ClaimName: customResource.GetDatabasePvcName(),
+				},
+			},
+		},
+	}
+
+	return volumeMounts, volumes
+}
+
+// createWipeScript deletes the contents of the volumes but keeps the mount points
+func createWipeScript(customResource *itaallinonev1.ITAutomationAllInOne) string {
+	return "find /" + strings.Join(mountedVolumeDirectories(customResource), " /") + " -mindepth 1 -delete"
+}
+
+// createFinalBackupScript archives the volumes into the backup claim. The archive
+// is named after the deletion timestamp, so retries of the Job overwrite the same file.
+func createFinalBackupScript(customResource *itaallinonev1.ITAutomationAllInOne) string {
+	archiveName := fmt.Sprintf("%s-final-%s.tar.gz", customResource.Name,
+		customResource.DeletionTimestamp.UTC().Format("20060102150405"))
+
+	return fmt.Sprintf("set -e; tar -czf %s/%s.tmp -C / %s; mv %s/%s.tmp %s/%s",
+		backupMountPath, archiveName, strings.Join(mountedVolumeDirectories(customResource), " "), backupMountPath, archiveName, backupMountPath, archiveName)
+}
+
+// mountedVolumeDirectories returns the directories the volumes of the instance are mounted at, relative to the root
+func mountedVolumeDirectories(customResource *itaallinonev1.ITAutomationAllInOne) []string {
+	return []string{"exastro-file-volume", "exastro-database-volume"}
+}
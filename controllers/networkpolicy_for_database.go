@@ -0,0 +1,102 @@
+/*
+Copyright 2021 NEC Corporation.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	itaallinonev1 "github.com/exastro-suite/it-automation-operator/api/v1"
+)
+
+// NetworkPolicyFactoryForDatabase restricts who can connect to the database exposed by
+// ServiceFactoryForDatabase. As the policy isolates the pods of the instance, the web
+// console port is allowed from anywhere so that it is served as before.
+type NetworkPolicyFactoryForDatabase struct {
+	k8sResourceFactoryBase
+	Reconciler     *ITAutomationAllInOneReconciler
+	CustomResource *itaallinonev1.ITAutomationAllInOne
+}
+
+func newNetworkPolicyFactoryForDatabase(reconciler *ITAutomationAllInOneReconciler, customResource *itaallinonev1.ITAutomationAllInOne) *NetworkPolicyFactoryForDatabase {
+	return &NetworkPolicyFactoryForDatabase{
+		k8sResourceFactoryBase: newK8sResourceFactoryBase(customResource, reconciler.Scheme, customResource.Name+"-database", &networkingv1.NetworkPolicy{}),
+		Reconciler:             reconciler,
+		CustomResource:         customResource,
+	}
+}
+
+func (factory *NetworkPolicyFactoryForDatabase) New() client.Object {
+	var allowedClients []networkingv1.NetworkPolicyPeer
+	if service := factory.CustomResource.GetDatabaseService(); service != nil {
+		allowedClients = service.AllowedClients
+	}
+
+	// Backup and restore Jobs of the instance, whatever their component
+	jobLabels := createJobLabels(factory.CustomResource.Name, "")
+	delete(jobLabels, componentLabel)
+
+	tcp := corev1.ProtocolTCP
+	httpPort := intstr.FromInt(80)
+	mysqlPort := intstr.FromInt(databasePort)
+
+	k8sNetworkPolicy := &networkingv1.NetworkPolicy{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: factory.GetNamespace(),
+			Name:      factory.GetName(),
+		},
+		Spec: networkingv1.NetworkPolicySpec{
+			PodSelector: metav1.LabelSelector{
+				MatchLabels: createLabels(factory.CustomResource),
+			},
+			Ingress: []networkingv1.NetworkPolicyIngressRule{
+				{
+					Ports: []networkingv1.NetworkPolicyPort{
+						{Protocol: &tcp, Port: &httpPort},
+					},
+				},
+				{
+					Ports: []networkingv1.NetworkPolicyPort{
+						{Protocol: &tcp, Port: &mysqlPort},
+					},
+					From: append([]networkingv1.NetworkPolicyPeer{
+						{
+							PodSelector: &metav1.LabelSelector{
+								MatchLabels: jobLabels,
+							},
+						},
+					}, allowedClients...),
+				},
+			},
+			PolicyTypes: []networkingv1.PolicyType{networkingv1.PolicyTypeIngress},
+		},
+	}
+
+	factory.setOwner(k8sNetworkPolicy)
+
+	return k8sNetworkPolicy
+}
+
+func (factory *NetworkPolicyFactoryForDatabase) Merge(k8sResource client.Object, desiredK8sResource client.Object) {
+	k8sNetworkPolicy := k8sResource.(*networkingv1.NetworkPolicy)
+	desiredK8sNetworkPolicy := desiredK8sResource.(*networkingv1.NetworkPolicy)
+
+	k8sNetworkPolicy.Spec = desiredK8sNetworkPolicy.Spec
+}
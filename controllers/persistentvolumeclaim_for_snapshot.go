@@ -0,0 +1,108 @@
+/*
+Copyright 2021 NEC Corporation.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	itaallinonev1 "github.com/exastro-suite/it-automation-operator/api/v1"
+)
+
+// restoredByAnnotation names the ITAutomationRestore which provisioned a claim from a VolumeSnapshot
+const restoredByAnnotation = "ita-all-in-one.ita.exastro/restored-by"
+
+// PersistentVolumeClaimFactoryForSnapshot provisions a claim of a new instance from a
+// VolumeSnapshot of a backup. The claim gets the default name and labels of the volume, so that the
+// instance picks it up when it is created. From then on PersistentVolumeClaimFactoryForVolume of the
+// instance converges the claim: it expands it as the spec requires and sets the instance as its owner
+// when the retention policy is Delete. Until then the claim is owned by nobody, so that deleting the
+// restore does not delete data the instance is about to use, and a claim of an instance which is
+// never created is left to be deleted by hand.
+type PersistentVolumeClaimFactoryForSnapshot struct {
+	k8sResourceFactoryBase
+	Reconciler     *ITAutomationRestoreReconciler
+	CustomResource *itaallinonev1.ITAutomationRestore
+	VolumeSnapshot *itaallinonev1.BackupVolumeSnapshot
+}
+
+func newPersistentVolumeClaimFactoryForSnapshot(reconciler *ITAutomationRestoreReconciler, restore *itaallinonev1.ITAutomationRestore, volumeSnapshot *itaallinonev1.BackupVolumeSnapshot) *PersistentVolumeClaimFactoryForSnapshot {
+	return &PersistentVolumeClaimFactoryForSnapshot{
+		k8sResourceFactoryBase: newK8sResourceFactoryBase(restore, reconciler.Scheme, restore.Spec.InstanceName+"-"+volumeSnapshot.VolumeName, &corev1.PersistentVolumeClaim{}),
+		Reconciler:             reconciler,
+		CustomResource:         restore,
+		VolumeSnapshot:         volumeSnapshot,
+	}
+}
+
+func (factory *PersistentVolumeClaimFactoryForSnapshot) New() client.Object {
+	volumeSnapshot := factory.VolumeSnapshot
+
+	// Same labels as the claims created for the instance
+	labels := map[string]string{
+		"app.kubernetes.io/name":     appName,
+		"app.kubernetes.io/instance": factory.CustomResource.Spec.InstanceName,
+		componentLabel:               volumeSnapshot.VolumeName,
+	}
+
+	size := resource.MustParse(defaultVolumeSize)
+	if volumeSnapshot.RestoreSize != nil {
+		size = *volumeSnapshot.RestoreSize
+	}
+
+	accessModes := volumeSnapshot.AccessModes
+	if len(accessModes) == 0 {
+		accessModes = []corev1.PersistentVolumeAccessMode{corev1.ReadWriteOnce}
+	}
+
+	apiGroup := VolumeSnapshotGroupVersionKind.Group
+
+	return &corev1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: factory.GetNamespace(),
+			Name:      factory.GetName(),
+			Labels:    labels,
+			// The snapshot holds an initialized volume
+			Annotations: map[string]string{
+				restoredByAnnotation:          factory.CustomResource.Name,
+				volumeInitializedAtAnnotation: time.Now().UTC().Format(time.RFC3339),
+			},
+		},
+		Spec: corev1.PersistentVolumeClaimSpec{
+			AccessModes:      accessModes,
+			StorageClassName: volumeSnapshot.StorageClassName,
+			Resources: corev1.ResourceRequirements{
+				Requests: corev1.ResourceList{
+					corev1.ResourceStorage: size,
+				},
+			},
+			DataSource: &corev1.TypedLocalObjectReference{
+				APIGroup: &apiGroup,
+				Kind:     VolumeSnapshotGroupVersionKind.Kind,
+				Name:     volumeSnapshot.Name,
+			},
+		},
+	}
+}
+
+// Merge leaves the claim untouched since the instance converges it once it is created.
+func (factory *PersistentVolumeClaimFactoryForSnapshot) Merge(k8sResource client.Object, desiredK8sResource client.Object) {
+}
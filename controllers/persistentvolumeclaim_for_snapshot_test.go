@@ -0,0 +1,175 @@
+/*
+Copyright 2021 NEC Corporation.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	itaallinonev1 "github.com/exastro-suite/it-automation-operator/api/v1"
+)
+
+func newTestRestore(instanceName string) *itaallinonev1.ITAutomationRestore {
+	return &itaallinonev1.ITAutomationRestore{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "restore", UID: "restore-uid"},
+		Spec:       itaallinonev1.ITAutomationRestoreSpec{BackupName: "backup", InstanceName: instanceName},
+	}
+}
+
+func TestPersistentVolumeClaimFactoryForSnapshot(t *testing.T) {
+	restoreSize := resource.MustParse("20Gi")
+	storageClassName := "csi"
+
+	tests := []struct {
+		name            string
+		volumeSnapshot  itaallinonev1.BackupVolumeSnapshot
+		wantSize        string
+		wantAccessModes []corev1.PersistentVolumeAccessMode
+	}{
+		{
+			name:            "defaults",
+			volumeSnapshot:  itaallinonev1.BackupVolumeSnapshot{VolumeName: fileVolumeName, Name: "backup-file-volume"},
+			wantSize:        defaultVolumeSize,
+			wantAccessModes: []corev1.PersistentVolumeAccessMode{corev1.ReadWriteOnce},
+		},
+		{
+			name: "size, access modes and storage class of the snapshotted claim",
+			volumeSnapshot: itaallinonev1.BackupVolumeSnapshot{VolumeName: databaseVolumeName, Name: "backup-database-volume",
+				RestoreSize: &restoreSize, AccessModes: []corev1.PersistentVolumeAccessMode{corev1.ReadWriteMany}, StorageClassName: &storageClassName},
+			wantSize:        "20Gi",
+			wantAccessModes: []corev1.PersistentVolumeAccessMode{corev1.ReadWriteMany},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			scheme := newTestScheme(t)
+			restore := newTestRestore("ita")
+			volumeSnapshot := tt.volumeSnapshot
+			factory := newPersistentVolumeClaimFactoryForSnapshot(&ITAutomationRestoreReconciler{Scheme: scheme}, restore, &volumeSnapshot)
+			k8sPvc := factory.New().(*corev1.PersistentVolumeClaim)
+
+			// The instance finds the claim under the name and labels of its own claim
+			instance := &itaallinonev1.ITAutomationAllInOne{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "ita"}}
+			instanceK8sPvc := newPersistentVolumeClaimFactoryForVolume(&ITAutomationAllInOneReconciler{Scheme: scheme}, instance, volumeSnapshot.VolumeName).New()
+			if k8sPvc.Namespace != instanceK8sPvc.GetNamespace() || k8sPvc.Name != instanceK8sPvc.GetName() {
+				t.Errorf("claim = %s/%s, want %s/%s", k8sPvc.Namespace, k8sPvc.Name, instanceK8sPvc.GetNamespace(), instanceK8sPvc.GetName())
+			}
+			if !reflect.DeepEqual(k8sPvc.Labels, instanceK8sPvc.GetLabels()) {
+				t.Errorf("labels = %v, want %v", k8sPvc.Labels, instanceK8sPvc.GetLabels())
+			}
+
+			if len(k8sPvc.OwnerReferences) != 0 {
+				t.Errorf("owner references = %v, want none", k8sPvc.OwnerReferences)
+			}
+			if k8sPvc.Annotations[restoredByAnnotation] != restore.Name || k8sPvc.Annotations[volumeInitializedAtAnnotation] == "" {
+				t.Errorf("annotations = %v, want the restore and the initialization", k8sPvc.Annotations)
+			}
+
+			dataSource := k8sPvc.Spec.DataSource
+			if dataSource == nil || dataSource.Kind != "VolumeSnapshot" || dataSource.Name != volumeSnapshot.Name {
+				t.Errorf("data source = %v, want VolumeSnapshot %s", dataSource, volumeSnapshot.Name)
+			}
+			size := k8sPvc.Spec.Resources.Requests[corev1.ResourceStorage]
+			if size.Cmp(resource.MustParse(tt.wantSize)) != 0 {
+				t.Errorf("size = %s, want %s", size.String(), tt.wantSize)
+			}
+			if !reflect.DeepEqual(k8sPvc.Spec.AccessModes, tt.wantAccessModes) {
+				t.Errorf("access modes = %v, want %v", k8sPvc.Spec.AccessModes, tt.wantAccessModes)
+			}
+			if !reflect.DeepEqual(k8sPvc.Spec.StorageClassName, volumeSnapshot.StorageClassName) {
+				t.Errorf("storage class = %v, want %v", k8sPvc.Spec.StorageClassName, volumeSnapshot.StorageClassName)
+			}
+		})
+	}
+}
+
+func TestVolumeClaimFromSnapshotIsTakenOver(t *testing.T) {
+	restoreSize := resource.MustParse("20Gi")
+
+	tests := []struct {
+		name      string
+		storage   *itaallinonev1.VolumeClaimSpec
+		wantOwned bool
+		wantSize  string
+	}{
+		{
+			name:     "claim is kept at the restore size and not owned by default",
+			wantSize: "20Gi",
+		},
+		{
+			name:      "claim is owned with the Delete policy",
+			storage:   &itaallinonev1.VolumeClaimSpec{RetentionPolicy: itaallinonev1.RetentionPolicyDelete},
+			wantOwned: true,
+			wantSize:  "20Gi",
+		},
+		{
+			name:     "claim is expanded as the spec requires",
+			storage:  &itaallinonev1.VolumeClaimSpec{Size: resource.MustParse("30Gi")},
+			wantSize: "30Gi",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			scheme := newTestScheme(t)
+			volumeSnapshot := itaallinonev1.BackupVolumeSnapshot{VolumeName: fileVolumeName, Name: "backup-file-volume", RestoreSize: &restoreSize}
+			restoredK8sPvc := newPersistentVolumeClaimFactoryForSnapshot(&ITAutomationRestoreReconciler{Scheme: scheme}, newTestRestore("ita"), &volumeSnapshot).New()
+			c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(restoredK8sPvc).Build()
+
+			instance := &itaallinonev1.ITAutomationAllInOne{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "ita", UID: "instance-uid"}}
+			factory := newPersistentVolumeClaimFactoryForVolume(&ITAutomationAllInOneReconciler{Scheme: scheme}, instance, fileVolumeName)
+			factory.Storage = tt.storage
+
+			// The first reconciliation takes the claim over, the next one finds it up to date
+			for i, wantRequeue := range []bool{true, false} {
+				requeue, _, err := convergeK8sResource(ctx, c, logr.Discard(), record.NewFakeRecorder(10), instance, factory)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if requeue != wantRequeue {
+					t.Fatalf("reconciliation %d: requeue = %v, want %v", i, requeue, wantRequeue)
+				}
+			}
+
+			k8sPvc := &corev1.PersistentVolumeClaim{}
+			if err := c.Get(ctx, factory.GetNamespaceName(), k8sPvc); err != nil {
+				t.Fatal(err)
+			}
+			owned := metav1.IsControlledBy(k8sPvc, instance)
+			if owned != tt.wantOwned {
+				t.Errorf("owned = %v, want %v", owned, tt.wantOwned)
+			}
+			size := k8sPvc.Spec.Resources.Requests[corev1.ResourceStorage]
+			if size.Cmp(resource.MustParse(tt.wantSize)) != 0 {
+				t.Errorf("size = %s, want %s", size.String(), tt.wantSize)
+			}
+			if k8sPvc.Spec.DataSource == nil || k8sPvc.Annotations[restoredByAnnotation] == "" {
+				t.Errorf("claim = %v, want the data source and annotations of the restore kept", k8sPvc)
+			}
+		})
+	}
+}
@@ -0,0 +1,139 @@
+/*
+Copyright 2021 NEC Corporation.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	itaallinonev1 "github.com/exastro-suite/it-automation-operator/api/v1"
+)
+
+const defaultVolumeSize = "10Gi"
+
+// PersistentVolumeClaimFactoryForVolume creates the claim of a volume which is not
+// given by name in the spec. Storage may be nil, in which case the defaults are used.
+type PersistentVolumeClaimFactoryForVolume struct {
+	k8sResourceFactoryBase
+	Reconciler     *ITAutomationAllInOneReconciler
+	CustomResource *itaallinonev1.ITAutomationAllInOne
+	VolumeName     string
+	Storage        *itaallinonev1.VolumeClaimSpec
+}
+
+func newPersistentVolumeClaimFactoryForVolume(reconciler *ITAutomationAllInOneReconciler, customResource *itaallinonev1.ITAutomationAllInOne, volumeName string) *PersistentVolumeClaimFactoryForVolume {
+	return &PersistentVolumeClaimFactoryForVolume{
+		k8sResourceFactoryBase: newK8sResourceFactoryBase(customResource, reconciler.Scheme, customResource.Name+"-"+volumeName, &corev1.PersistentVolumeClaim{}),
+		Reconciler:             reconciler,
+		CustomResource:         customResource,
+		VolumeName:             volumeName,
+	}
+}
+
+func (factory *PersistentVolumeClaimFactoryForVolume) New() client.Object {
+	labels := mergeStringMaps(createLabels(factory.CustomResource), map[string]string{
+		componentLabel: factory.VolumeName,
+	})
+
+	storage := factory.Storage
+	if storage == nil {
+		storage = &itaallinonev1.VolumeClaimSpec{}
+	}
+
+	k8sPvc := &corev1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: factory.GetNamespace(),
+			Name:      factory.GetName(),
+			Labels:    labels,
+		},
+		Spec: createVolumeClaimSpec(storage),
+	}
+
+	// Retained claims are not owned, so that the garbage collector leaves them behind
+	if storage.RetentionPolicy == itaallinonev1.RetentionPolicyDelete {
+		factory.setOwner(k8sPvc)
+	}
+
+	return k8sPvc
+}
+
+func (factory *PersistentVolumeClaimFactoryForVolume) AdjustDesired(k8sResource client.Object, desiredK8sResource client.Object) {
+	adjustDesiredVolumeClaim(k8sResource.(*corev1.PersistentVolumeClaim), desiredK8sResource.(*corev1.PersistentVolumeClaim))
+}
+
+func (factory *PersistentVolumeClaimFactoryForVolume) Merge(k8sResource client.Object, desiredK8sResource client.Object) {
+	mergeVolumeClaim(k8sResource.(*corev1.PersistentVolumeClaim), desiredK8sResource.(*corev1.PersistentVolumeClaim), factory.CustomResource.UID)
+}
+
+func createVolumeClaimSpec(storage *itaallinonev1.VolumeClaimSpec) corev1.PersistentVolumeClaimSpec {
+	size := storage.Size
+	if size.IsZero() {
+		size = resource.MustParse(defaultVolumeSize)
+	}
+
+	accessModes := storage.AccessModes
+	if len(accessModes) == 0 {
+		accessModes = []corev1.PersistentVolumeAccessMode{corev1.ReadWriteOnce}
+	}
+
+	return corev1.PersistentVolumeClaimSpec{
+		AccessModes:      accessModes,
+		StorageClassName: storage.StorageClassName,
+		Resources: corev1.ResourceRequirements{
+			Requests: corev1.ResourceList{
+				corev1.ResourceStorage: size,
+			},
+		},
+	}
+}
+
+// adjustDesiredVolumeClaim keeps a claim larger than desired at its size, since a claim cannot be shrunk.
+// This is the case of a claim expanded by hand, restored from a larger snapshot or whose size was removed from the spec.
+func adjustDesiredVolumeClaim(k8sPvc *corev1.PersistentVolumeClaim, desiredK8sPvc *corev1.PersistentVolumeClaim) {
+	desiredSize, found := desiredK8sPvc.Spec.Resources.Requests[corev1.ResourceStorage]
+	currentSize, currentFound := k8sPvc.Spec.Resources.Requests[corev1.ResourceStorage]
+	if found && currentFound && currentSize.Cmp(desiredSize) > 0 {
+		desiredK8sPvc.Spec.Resources.Requests[corev1.ResourceStorage] = currentSize
+	}
+}
+
+// mergeVolumeClaim applies the desired claim of a volume owned by the given custom resource
+func mergeVolumeClaim(k8sPvc *corev1.PersistentVolumeClaim, desiredK8sPvc *corev1.PersistentVolumeClaim, ownerUID types.UID) {
+	// Drop the owner reference when the retention policy is switched to Retain
+	if len(desiredK8sPvc.OwnerReferences) == 0 {
+		var ownerReferences []metav1.OwnerReference
+		for _, ownerReference := range k8sPvc.OwnerReferences {
+			if ownerReference.UID != ownerUID {
+				ownerReferences = append(ownerReferences, ownerReference)
+			}
+		}
+		k8sPvc.OwnerReferences = ownerReferences
+	}
+
+	// Most of the claim spec is immutable; only expansion of the volume is applied.
+	desiredSize := desiredK8sPvc.Spec.Resources.Requests[corev1.ResourceStorage]
+	currentSize, found := k8sPvc.Spec.Resources.Requests[corev1.ResourceStorage]
+	if !found || desiredSize.Cmp(currentSize) > 0 {
+		if k8sPvc.Spec.Resources.Requests == nil {
+			k8sPvc.Spec.Resources.Requests = corev1.ResourceList{}
+		}
+		k8sPvc.Spec.Resources.Requests[corev1.ResourceStorage] = desiredSize
+	}
+}
@@ -0,0 +1,84 @@
+/*
+Copyright 2021 NEC Corporation.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/resource"
+
+	itaallinonev1 "github.com/exastro-suite/it-automation-operator/api/v1"
+)
+
+func TestAdjustDesiredVolumeClaim(t *testing.T) {
+	tests := []struct {
+		name        string
+		storage     itaallinonev1.VolumeClaimSpec
+		currentSize string
+		wantDrift   bool
+		wantSize    string
+	}{
+		{
+			name:        "same size",
+			storage:     itaallinonev1.VolumeClaimSpec{Size: resource.MustParse("20Gi")},
+			currentSize: "20Gi",
+			wantSize:    "20Gi",
+		},
+		{
+			name:        "expansion is applied",
+			storage:     itaallinonev1.VolumeClaimSpec{Size: resource.MustParse("30Gi")},
+			currentSize: "20Gi",
+			wantDrift:   true,
+			wantSize:    "30Gi",
+		},
+		{
+			name:        "claim larger than desired is kept",
+			storage:     itaallinonev1.VolumeClaimSpec{Size: resource.MustParse("10Gi")},
+			currentSize: "20Gi",
+			wantSize:    "20Gi",
+		},
+		{
+			name:        "claim larger than the default size is kept",
+			currentSize: "20Gi",
+			wantSize:    "20Gi",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			storage := tt.storage
+			k8sPvc := &corev1.PersistentVolumeClaim{Spec: createVolumeClaimSpec(&storage)}
+			k8sPvc.Spec.Resources.Requests[corev1.ResourceStorage] = resource.MustParse(tt.currentSize)
+			k8sPvc.Spec.VolumeName = "pvc-0123"
+			desiredK8sPvc := &corev1.PersistentVolumeClaim{Spec: createVolumeClaimSpec(&storage)}
+
+			adjustDesiredVolumeClaim(k8sPvc, desiredK8sPvc)
+			drift := !equality.Semantic.DeepDerivative(desiredK8sPvc, k8sPvc)
+			if drift != tt.wantDrift {
+				t.Errorf("drift = %v, want %v", drift, tt.wantDrift)
+			}
+
+			mergeVolumeClaim(k8sPvc, desiredK8sPvc, "")
+			size := k8sPvc.Spec.Resources.Requests[corev1.ResourceStorage]
+			if size.Cmp(resource.MustParse(tt.wantSize)) != 0 {
+				t.Errorf("size = %s, want %s", size.String(), tt.wantSize)
+			}
+		})
+	}
+}
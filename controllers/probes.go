@@ -0,0 +1,87 @@
+/*
+Copyright 2021 NEC Corporation.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+
+	itaallinonev1 "github.com/exastro-suite/it-automation-operator/api/v1"
+)
+
+// createProbes returns the startup, readiness and liveness probes of the ITA container
+func createProbes(customResource *itaallinonev1.ITAutomationAllInOne) (*corev1.Probe, *corev1.Probe, *corev1.Probe) {
+	probes := customResource.Spec.Probes
+	if probes == nil {
+		probes = &itaallinonev1.ProbesSpec{}
+	}
+
+	if probes.Disabled {
+		return nil, nil, nil
+	}
+
+	startup := probes.Startup
+	if startup == nil {
+		// MariaDB starts listening once the volumes are initialized,
+		// which takes a while on the first start.
+		startup = &corev1.Probe{
+			Handler: corev1.Handler{
+				TCPSocket: &corev1.TCPSocketAction{
+					Port: intstr.FromString("mysql"),
+				},
+			},
+			PeriodSeconds:    10,
+			TimeoutSeconds:   5,
+			FailureThreshold: 180,
+		}
+	}
+
+	readiness, liveness := createWebConsoleProbes()
+	if probes.Readiness != nil {
+		readiness = probes.Readiness
+	}
+	if probes.Liveness != nil {
+		liveness = probes.Liveness
+	}
+
+	return startup, readiness, liveness
+}
+
+// createWebConsoleProbes returns the readiness and liveness probes requesting the web console
+func createWebConsoleProbes() (*corev1.Probe, *corev1.Probe) {
+	handler := corev1.Handler{
+		HTTPGet: &corev1.HTTPGetAction{
+			Path: "/",
+			Port: intstr.FromString("http"),
+		},
+	}
+
+	readiness := &corev1.Probe{
+		Handler:          handler,
+		PeriodSeconds:    10,
+		TimeoutSeconds:   5,
+		FailureThreshold: 3,
+	}
+	liveness := &corev1.Probe{
+		Handler:          handler,
+		PeriodSeconds:    20,
+		TimeoutSeconds:   10,
+		FailureThreshold: 6,
+	}
+
+	return readiness, liveness
+}
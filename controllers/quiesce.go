@@ -0,0 +1,75 @@
+/*
+Copyright 2021 NEC Corporation.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	itaallinonev1 "github.com/exastro-suite/it-automation-operator/api/v1"
+)
+
+// quiescedBy returns the value of quiescedByAnnotation naming the given object
+func quiescedBy(kind string, name string) string {
+	return kind + "/" + name
+}
+
+// quiesceInstance scales the frontend of the instance to zero on behalf of the holder.
+// The caller waits for the pods to terminate with hasFrontendPods.
+func quiesceInstance(ctx context.Context, c client.Client, log logr.Logger, recorder record.EventRecorder, instance *itaallinonev1.ITAutomationAllInOne, holder string) (ctrl.Result, error) {
+	log.Info("Stopping instance", append(k8sResourceToLogParameters(instance), "holder", holder)...)
+
+	annotations := mergeStringMaps(instance.Annotations, map[string]string{
+		quiescedByAnnotation: holder,
+	})
+	instance.SetAnnotations(annotations)
+	err := c.Update(ctx, instance)
+	if err != nil && !errors.IsConflict(err) {
+		log.Error(err, "Failed to stop instance", k8sResourceToLogParameters(instance)...)
+		return ctrl.Result{}, err
+	}
+	if err == nil {
+		recorder.Eventf(instance, corev1.EventTypeNormal, eventReasonInstanceStopped, "Stopping the instance for %s", holder)
+	}
+
+	return ctrl.Result{RequeueAfter: podTerminationRecheckInterval}, nil
+}
+
+// releaseInstance starts the instance stopped by quiesceInstance again
+func releaseInstance(ctx context.Context, c client.Client, log logr.Logger, recorder record.EventRecorder, instance *itaallinonev1.ITAutomationAllInOne) (ctrl.Result, error) {
+	log.Info("Starting instance", k8sResourceToLogParameters(instance)...)
+
+	holder := instance.Annotations[quiescedByAnnotation]
+
+	delete(instance.Annotations, quiescedByAnnotation)
+	err := c.Update(ctx, instance)
+	if err != nil && !errors.IsConflict(err) {
+		log.Error(err, "Failed to start instance", k8sResourceToLogParameters(instance)...)
+		return ctrl.Result{}, err
+	}
+	if err == nil {
+		recorder.Eventf(instance, corev1.EventTypeNormal, eventReasonInstanceStarted, "Starting the instance stopped for %s", holder)
+	}
+
+	return ctrl.Result{RequeueAfter: instanceRecheckInterval}, nil
+}
@@ -0,0 +1,70 @@
+/*
+Copyright 2021 NEC Corporation.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	itaallinonev1 "github.com/exastro-suite/it-automation-operator/api/v1"
+)
+
+// RestoreFactoryForRollback creates the ITAutomationRestore which restores the pre-upgrade
+// backup once the instance is rolled back to the previous version. Its existence marks
+// the upgrade to spec.version as rolled back.
+type RestoreFactoryForRollback struct {
+	k8sResourceFactoryBase
+	Reconciler     *ITAutomationAllInOneReconciler
+	CustomResource *itaallinonev1.ITAutomationAllInOne
+}
+
+func newRestoreFactoryForRollback(reconciler *ITAutomationAllInOneReconciler, customResource *itaallinonev1.ITAutomationAllInOne) *RestoreFactoryForRollback {
+	return &RestoreFactoryForRollback{
+		k8sResourceFactoryBase: newK8sResourceFactoryBase(customResource, reconciler.Scheme, customResource.Name+"-rollback-"+strings.ReplaceAll(customResource.Spec.Version, ".", "-"), &itaallinonev1.ITAutomationRestore{}),
+		Reconciler:             reconciler,
+		CustomResource:         customResource,
+	}
+}
+
+func (factory *RestoreFactoryForRollback) New() client.Object {
+	labels := mergeStringMaps(createLabels(factory.CustomResource), map[string]string{
+		upgradeToLabel: factory.CustomResource.Spec.Version,
+	})
+	backupFactory := newBackupFactoryForUpgrade(factory.Reconciler, factory.CustomResource)
+
+	restore := &itaallinonev1.ITAutomationRestore{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: factory.GetNamespace(),
+			Name:      factory.GetName(),
+			Labels:    labels,
+		},
+		Spec: itaallinonev1.ITAutomationRestoreSpec{
+			BackupName:   backupFactory.GetName(),
+			InstanceName: factory.CustomResource.Name,
+		},
+	}
+
+	factory.setOwner(restore)
+
+	return restore
+}
+
+// Merge leaves the restore untouched since it is never updated.
+func (factory *RestoreFactoryForRollback) Merge(k8sResource client.Object, desiredK8sResource client.Object) {
+}
@@ -0,0 +1,101 @@
+/*
+Copyright 2021 NEC Corporation.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	itaallinonev1 "github.com/exastro-suite/it-automation-operator/api/v1"
+)
+
+type RouteFactoryForFrontend struct {
+	k8sResourceFactoryBase
+	Reconciler     *ITAutomationAllInOneReconciler
+	CustomResource *itaallinonev1.ITAutomationAllInOne
+}
+
+func newRouteFactoryForFrontend(reconciler *ITAutomationAllInOneReconciler, customResource *itaallinonev1.ITAutomationAllInOne) *RouteFactoryForFrontend {
+	return &RouteFactoryForFrontend{
+		k8sResourceFactoryBase: newK8sResourceFactoryBase(customResource, reconciler.Scheme, customResource.Name+"-frontend", newRoute()),
+		Reconciler:             reconciler,
+		CustomResource:         customResource,
+	}
+}
+
+func (factory *RouteFactoryForFrontend) New() client.Object {
+	route := factory.CustomResource.Spec.Route
+	serviceFactory := newServiceFactoryForFrontend(factory.Reconciler, factory.CustomResource)
+
+	spec := map[string]interface{}{
+		"to": map[string]interface{}{
+			"kind":   "Service",
+			"name":   serviceFactory.GetName(),
+			"weight": int64(100),
+		},
+		"port": map[string]interface{}{
+			"targetPort": "http",
+		},
+	}
+
+	if route.Host != "" {
+		spec["host"] = route.Host
+	}
+
+	// Other terminations, stored before they were rejected, fall back to plain HTTP
+	if route.TLSTermination == itaallinonev1.RouteTLSTerminationEdge {
+		tls := map[string]interface{}{
+			"termination": route.TLSTermination,
+		}
+		if route.InsecureEdgeTerminationPolicy != "" {
+			tls["insecureEdgeTerminationPolicy"] = route.InsecureEdgeTerminationPolicy
+		}
+		spec["tls"] = tls
+	}
+
+	k8sRoute := newRoute()
+	k8sRoute.SetNamespace(factory.GetNamespace())
+	k8sRoute.SetName(factory.GetName())
+	k8sRoute.SetLabels(createLabels(factory.CustomResource))
+	k8sRoute.Object["spec"] = spec
+
+	factory.setOwner(k8sRoute)
+
+	return k8sRoute
+}
+
+func (factory *RouteFactoryForFrontend) Merge(k8sResource client.Object, desiredK8sResource client.Object) {
+	k8sRoute := k8sResource.(*unstructured.Unstructured)
+	desiredK8sRoute := desiredK8sResource.(*unstructured.Unstructured)
+
+	spec, _, _ := unstructured.NestedMap(desiredK8sRoute.Object, "spec")
+
+	// Keep the host generated by OpenShift
+	if _, found := spec["host"]; !found {
+		if host, found, _ := unstructured.NestedString(k8sRoute.Object, "spec", "host"); found {
+			spec["host"] = host
+		}
+	}
+
+	k8sRoute.Object["spec"] = spec
+}
+
+func newRoute() *unstructured.Unstructured {
+	k8sRoute := &unstructured.Unstructured{}
+	k8sRoute.SetGroupVersionKind(RouteGroupVersionKind)
+	return k8sRoute
+}
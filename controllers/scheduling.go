@@ -0,0 +1,97 @@
+/*
+Copyright 2021 NEC Corporation.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	itaallinonev1 "github.com/exastro-suite/it-automation-operator/api/v1"
+)
+
+// applyPodTemplate sets the compute resources and the scheduling constraints of the spec
+// to the pod. The ITA container must be the first one.
+func applyPodTemplate(customResource *itaallinonev1.ITAutomationAllInOne, podSpec *corev1.PodSpec) {
+	applyPodTemplateSpec(customResource.Spec.PodTemplate, createDefaultAffinity(), podSpec)
+}
+
+// applyPodTemplateSpec is applyPodTemplate with the given affinity used when the spec has none
+func applyPodTemplateSpec(podTemplate *itaallinonev1.PodTemplateSpec, defaultAffinity *corev1.Affinity, podSpec *corev1.PodSpec) {
+	if podTemplate == nil {
+		podTemplate = &itaallinonev1.PodTemplateSpec{}
+	}
+
+	podSpec.Containers[0].Resources = podTemplate.Resources
+	podSpec.NodeSelector = podTemplate.NodeSelector
+	podSpec.Tolerations = podTemplate.Tolerations
+	podSpec.TopologySpreadConstraints = podTemplate.TopologySpreadConstraints
+	podSpec.PriorityClassName = podTemplate.PriorityClassName
+
+	podSpec.Affinity = podTemplate.Affinity
+	if podSpec.Affinity == nil {
+		podSpec.Affinity = defaultAffinity
+	}
+}
+
+// applyJobPodTemplate applies the node constraints of the spec to the pod of a Job. The compute
+// resources and the affinity of the instance are meant for ITA itself, so Jobs get their own resources.
+func applyJobPodTemplate(customResource *itaallinonev1.ITAutomationAllInOne, podSpec *corev1.PodSpec) {
+	if podTemplate := customResource.Spec.PodTemplate; podTemplate != nil {
+		podSpec.NodeSelector = podTemplate.NodeSelector
+		podSpec.Tolerations = podTemplate.Tolerations
+	}
+
+	for i := range podSpec.Containers {
+		podSpec.Containers[i].Resources = createJobResources()
+	}
+}
+
+// createJobResources returns the resources of the containers of backup, restore and volume Jobs.
+// The memory is limited since they only stream dumps and archives.
+func createJobResources() corev1.ResourceRequirements {
+	return corev1.ResourceRequirements{
+		Requests: corev1.ResourceList{
+			corev1.ResourceCPU:    resource.MustParse("100m"),
+			corev1.ResourceMemory: resource.MustParse("128Mi"),
+		},
+		Limits: corev1.ResourceList{
+			corev1.ResourceMemory: resource.MustParse("512Mi"),
+		},
+	}
+}
+
+// createDefaultAffinity spreads instances over the nodes when possible
+func createDefaultAffinity() *corev1.Affinity {
+	return &corev1.Affinity{
+		PodAntiAffinity: &corev1.PodAntiAffinity{
+			PreferredDuringSchedulingIgnoredDuringExecution: []corev1.WeightedPodAffinityTerm{
+				{
+					Weight: 100,
+					PodAffinityTerm: corev1.PodAffinityTerm{
+						LabelSelector: &metav1.LabelSelector{
+							MatchLabels: map[string]string{
+								"app.kubernetes.io/name": appName,
+							},
+						},
+						TopologyKey: corev1.LabelHostname,
+					},
+				},
+			},
+		},
+	}
+}
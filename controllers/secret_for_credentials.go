@@ -0,0 +1,213 @@
+/*
+Copyright 2021 NEC Corporation.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"crypto/rand"
+	"math/big"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	itaallinonev1 "github.com/exastro-suite/it-automation-operator/api/v1"
+)
+
+const (
+	adminPasswordKey    = "admin-password"
+	databasePasswordKey = "database-password"
+)
+
+// credentialsGeneratedAnnotation marks a Secret whose passwords were generated by the operator
+const credentialsGeneratedAnnotation = "ita-all-in-one.ita.exastro/credentials-generated"
+
+// imageDatabasePassword is the password the ITA installer in the image creates the application
+// database user with, unless the image supports the Credentials feature
+const imageDatabasePassword = "ita_db_password"
+
+const (
+	passwordLength     = 24
+	passwordCharacters = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789"
+)
+
+// SecretFactoryForCredentials creates the Secret holding the passwords of an instance. The passwords
+// are chosen by newCredentialsSecretFactory, so that New returns the same ones every time.
+// An empty AdminPassword is left out, since the administrator password of the image is not known.
+type SecretFactoryForCredentials struct {
+	k8sResourceFactoryBase
+	Reconciler       *ITAutomationAllInOneReconciler
+	CustomResource   *itaallinonev1.ITAutomationAllInOne
+	AdminPassword    string
+	DatabasePassword string
+}
+
+func newSecretFactoryForCredentials(reconciler *ITAutomationAllInOneReconciler, customResource *itaallinonev1.ITAutomationAllInOne) *SecretFactoryForCredentials {
+	return &SecretFactoryForCredentials{
+		k8sResourceFactoryBase: newK8sResourceFactoryBase(customResource, reconciler.Scheme, credentialsSecretName(customResource), &corev1.Secret{}),
+		Reconciler:             reconciler,
+		CustomResource:         customResource,
+	}
+}
+
+// newCredentialsSecretFactory generates random passwords if the image applies them to the volumes
+// it initializes, and the volumes are new. Otherwise the Secret holds the database password of the image.
+func newCredentialsSecretFactory(reconciler *ITAutomationAllInOneReconciler, customResource *itaallinonev1.ITAutomationAllInOne, newVolumes bool) (*SecretFactoryForCredentials, error) {
+	factory := newSecretFactoryForCredentials(reconciler, customResource)
+	factory.DatabasePassword = imageDatabasePassword
+	if !newVolumes || !itaallinonev1.HasImageFeature(customResource.Spec.Image, itaallinonev1.ImageFeatureCredentials) {
+		return factory, nil
+	}
+
+	var err error
+	factory.AdminPassword, err = generatePassword()
+	if err != nil {
+		return nil, err
+	}
+	factory.DatabasePassword, err = generatePassword()
+	if err != nil {
+		return nil, err
+	}
+
+	return factory, nil
+}
+
+// credentialsSecretName returns the Secret given by spec.credentialsSecret, or the one created by the operator
+func credentialsSecretName(customResource *itaallinonev1.ITAutomationAllInOne) string {
+	if customResource.Spec.CredentialsSecret != "" {
+		return customResource.Spec.CredentialsSecret
+	}
+	return customResource.Name + "-credentials"
+}
+
+func (factory *SecretFactoryForCredentials) New() client.Object {
+	k8sSecret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: factory.GetNamespace(),
+			Name:      factory.GetName(),
+			Labels:    createLabels(factory.CustomResource),
+		},
+		Type: corev1.SecretTypeOpaque,
+		Data: map[string][]byte{
+			databasePasswordKey: []byte(factory.DatabasePassword),
+		},
+	}
+	if factory.AdminPassword != "" {
+		k8sSecret.Annotations = map[string]string{
+			credentialsGeneratedAnnotation: "true",
+		}
+		k8sSecret.Data[adminPasswordKey] = []byte(factory.AdminPassword)
+	}
+
+	factory.setOwner(k8sSecret)
+
+	return k8sSecret
+}
+
+// Merge leaves the Secret untouched since the passwords are never replaced.
+func (factory *SecretFactoryForCredentials) Merge(k8sResource client.Object, desiredK8sResource client.Object) {
+}
+
+// ensureCredentials creates the Secret of the passwords once, unless spec.credentialsSecret adopts an
+// existing one. The passwords are never changed afterwards: the image only applies them when it
+// initializes empty volumes, so new passwords would not match the data anymore.
+func (reconciler *ITAutomationAllInOneReconciler) ensureCredentials(ctx context.Context, customResource *itaallinonev1.ITAutomationAllInOne) (bool, ctrl.Result, error) {
+	if customResource.Spec.CredentialsSecret != "" {
+		return makeReturnValuesContinue()
+	}
+
+	k8sSecret := &corev1.Secret{}
+	err := reconciler.Get(ctx, types.NamespacedName{Namespace: customResource.Namespace, Name: credentialsSecretName(customResource)}, k8sSecret)
+	if err == nil {
+		return makeReturnValuesContinue()
+	} else if !errors.IsNotFound(err) {
+		reconciler.Log.Error(err, "Failed to get resource", k8sResourceToLogParameters(k8sSecret)...)
+		return makeReturnValuesRequeueWithError(err)
+	}
+
+	newVolumes, err := reconciler.hasNewVolumes(ctx, customResource)
+	if err != nil {
+		reconciler.Log.Error(err, "Failed to check the volumes", k8sResourceToLogParameters(customResource)...)
+		return makeReturnValuesRequeueWithError(err)
+	}
+
+	factory, err := newCredentialsSecretFactory(reconciler, customResource, newVolumes)
+	if err != nil {
+		reconciler.Log.Error(err, "Failed to generate passwords", k8sResourceToLogParameters(customResource)...)
+		return makeReturnValuesRequeueWithError(err)
+	}
+
+	return reconciler.ensureK8sResourceCreatedWithEvent(ctx, customResource, factory)
+}
+
+// hasNewVolumes reports whether the instance has never run and its volumes are going to be created
+// by the operator, so that the image initializes them with the passwords of the Secret. Claims given
+// by name may hold the data of a former instance, whose passwords are adopted with spec.credentialsSecret.
+func (reconciler *ITAutomationAllInOneReconciler) hasNewVolumes(ctx context.Context, customResource *itaallinonev1.ITAutomationAllInOne) (bool, error) {
+	if customResource.Status.Version != "" || customResource.Spec.FilePvcName != "" || customResource.Spec.DatabasePvcName != "" {
+		return false, nil
+	}
+	for _, volumeName := range instanceVolumeNames(customResource) {
+		k8sPvc := &corev1.PersistentVolumeClaim{}
+		err := reconciler.Get(ctx, types.NamespacedName{Namespace: customResource.Namespace, Name: volumeClaimName(customResource, volumeName)}, k8sPvc)
+		if errors.IsNotFound(err) {
+			continue
+		} else if err != nil {
+			return false, err
+		}
+		if _, marked := k8sPvc.Annotations[volumeInitializedAtAnnotation]; marked {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
+
+// createCredentialsContainerEnv returns the passwords the ITA container initializes the volumes with,
+// if the image supports them. The administrator password is optional in a Secret given by the user.
+func createCredentialsContainerEnv(customResource *itaallinonev1.ITAutomationAllInOne) []corev1.EnvVar {
+	if !itaallinonev1.HasImageFeature(customResource.Spec.Image, itaallinonev1.ImageFeatureCredentials) {
+		return nil
+	}
+
+	optional := true
+	adminPassword := createSecretEnvVar("EXASTRO_ADMIN_PASSWORD", credentialsSecretName(customResource), adminPasswordKey)
+	adminPassword.ValueFrom.SecretKeyRef.Optional = &optional
+
+	return []corev1.EnvVar{
+		adminPassword,
+		createSecretEnvVar("EXASTRO_DB_PASSWORD", credentialsSecretName(customResource), databasePasswordKey),
+	}
+}
+
+// generatePassword returns a random alphanumeric password, which needs no quoting in shell scripts or SQL
+func generatePassword() (string, error) {
+	password := make([]byte, passwordLength)
+	limit := big.NewInt(int64(len(passwordCharacters)))
+	for i := range password {
+		n, err := rand.Int(rand.Reader, limit)
+		if err != nil {
+			return "", err
+		}
+		password[i] = passwordCharacters[n.Int64()]
+	}
+
+	return string(password), nil
+}
@@ -0,0 +1,97 @@
+/*
+Copyright 2021 NEC Corporation.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	corev1 "k8s.io/api/core/v1"
+
+	itaallinonev1 "github.com/exastro-suite/it-automation-operator/api/v1"
+)
+
+// Capabilities kept by the Minimal profile. All of them are allowed by the
+// "baseline" Pod Security Standard.
+var minimalCapabilities = []corev1.Capability{
+	"AUDIT_WRITE",
+	"CHOWN",
+	"DAC_OVERRIDE",
+	"FOWNER",
+	"FSETID",
+	"KILL",
+	"NET_BIND_SERVICE",
+	"SETGID",
+	"SETUID",
+	"SYS_CHROOT",
+}
+
+func createSecurityContexts(customResource *itaallinonev1.ITAutomationAllInOne) (*corev1.SecurityContext, *corev1.PodSecurityContext) {
+	return createSecurityContextsFor(customResource.Spec.SecurityContext)
+}
+
+func createSecurityContextsFor(spec *itaallinonev1.SecurityContextSpec) (*corev1.SecurityContext, *corev1.PodSecurityContext) {
+	profile := itaallinonev1.SecurityProfilePrivileged
+	if spec != nil && spec.Profile != "" {
+		profile = spec.Profile
+	}
+
+	switch profile {
+	case itaallinonev1.SecurityProfileMinimal:
+		privileged := false
+		securityContext := &corev1.SecurityContext{
+			Privileged: &privileged,
+			Capabilities: &corev1.Capabilities{
+				Drop: []corev1.Capability{"ALL"},
+				Add:  minimalCapabilities,
+			},
+		}
+		podSecurityContext := &corev1.PodSecurityContext{
+			SeccompProfile: &corev1.SeccompProfile{
+				Type: corev1.SeccompProfileTypeRuntimeDefault,
+			},
+		}
+		return securityContext, podSecurityContext
+
+	case itaallinonev1.SecurityProfileRestricted:
+		privileged := false
+		allowPrivilegeEscalation := false
+		runAsNonRoot := true
+		securityContext := &corev1.SecurityContext{
+			Privileged:               &privileged,
+			AllowPrivilegeEscalation: &allowPrivilegeEscalation,
+			RunAsNonRoot:             &runAsNonRoot,
+			Capabilities: &corev1.Capabilities{
+				Drop: []corev1.Capability{"ALL"},
+				Add:  []corev1.Capability{"NET_BIND_SERVICE"},
+			},
+		}
+		podSecurityContext := &corev1.PodSecurityContext{
+			RunAsNonRoot: &runAsNonRoot,
+			SeccompProfile: &corev1.SeccompProfile{
+				Type: corev1.SeccompProfileTypeRuntimeDefault,
+			},
+		}
+		return securityContext, podSecurityContext
+
+	case itaallinonev1.SecurityProfileCustom:
+		return spec.Container, spec.Pod
+
+	default:
+		privileged := true
+		return &corev1.SecurityContext{
+			Privileged: &privileged,
+		}, nil
+	}
+}
@@ -0,0 +1,94 @@
+/*
+Copyright 2021 NEC Corporation.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	itaallinonev1 "github.com/exastro-suite/it-automation-operator/api/v1"
+)
+
+type ServiceFactoryForDatabase struct {
+	k8sResourceFactoryBase
+	Reconciler     *ITAutomationAllInOneReconciler
+	CustomResource *itaallinonev1.ITAutomationAllInOne
+}
+
+func newServiceFactoryForDatabase(reconciler *ITAutomationAllInOneReconciler, customResource *itaallinonev1.ITAutomationAllInOne) *ServiceFactoryForDatabase {
+	return &ServiceFactoryForDatabase{
+		k8sResourceFactoryBase: newK8sResourceFactoryBase(customResource, reconciler.Scheme, databaseServiceName(customResource), &corev1.Service{}),
+		Reconciler:             reconciler,
+		CustomResource:         customResource,
+	}
+}
+
+func databaseServiceName(customResource *itaallinonev1.ITAutomationAllInOne) string {
+	return customResource.Name + "-database"
+}
+
+func (factory *ServiceFactoryForDatabase) New() client.Object {
+	service := factory.CustomResource.GetDatabaseService()
+	if service == nil {
+		service = &itaallinonev1.DatabaseServiceSpec{}
+	}
+
+	serviceType := service.Type
+	if serviceType == "" {
+		serviceType = corev1.ServiceTypeClusterIP
+	}
+
+	k8sService := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:   factory.GetNamespace(),
+			Name:        factory.GetName(),
+			Annotations: service.Annotations,
+		},
+		Spec: corev1.ServiceSpec{
+			Selector: createLabels(factory.CustomResource),
+			Ports: []corev1.ServicePort{
+				{
+					Name:       "mysql",
+					Port:       databasePort,
+					TargetPort: intstr.FromString("mysql"),
+				},
+			},
+			Type: serviceType,
+		},
+	}
+
+	if serviceType != corev1.ServiceTypeClusterIP {
+		k8sService.Spec.ExternalTrafficPolicy = corev1.ServiceExternalTrafficPolicyTypeCluster
+	}
+
+	factory.setOwner(k8sService)
+
+	return k8sService
+}
+
+func (factory *ServiceFactoryForDatabase) Merge(k8sResource client.Object, desiredK8sResource client.Object) {
+	mergeService(k8sResource.(*corev1.Service), desiredK8sResource.(*corev1.Service))
+}
+
+// databaseServiceAddress returns the address clients inside the cluster connect to the database at
+func databaseServiceAddress(customResource *itaallinonev1.ITAutomationAllInOne) string {
+	return fmt.Sprintf("%s.%s.svc:%d", databaseServiceName(customResource), customResource.Namespace, databasePort)
+}
@@ -19,48 +19,54 @@ package controllers
 import (
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/util/intstr"
-	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 
 	itaallinonev1 "github.com/exastro-suite/it-automation-operator/api/v1"
 )
 
 type ServiceFactoryForFrontend struct {
+	k8sResourceFactoryBase
 	Reconciler     *ITAutomationAllInOneReconciler
 	CustomResource *itaallinonev1.ITAutomationAllInOne
 }
 
-func (factory *ServiceFactoryForFrontend) GetName() string {
-	return factory.CustomResource.Name + "-frontend"
+func newServiceFactoryForFrontend(reconciler *ITAutomationAllInOneReconciler, customResource *itaallinonev1.ITAutomationAllInOne) *ServiceFactoryForFrontend {
+	return &ServiceFactoryForFrontend{
+		k8sResourceFactoryBase: newK8sResourceFactoryBase(customResource, reconciler.Scheme, customResource.Name+"-frontend", &corev1.Service{}),
+		Reconciler:             reconciler,
+		CustomResource:         customResource,
+	}
 }
 
-func (factory *ServiceFactoryForFrontend) GetNamespace() string {
-	return factory.CustomResource.Namespace
-}
+func (factory *ServiceFactoryForFrontend) New() client.Object {
+	k8sService := createWebConsoleService(factory.GetNamespace(), factory.GetName(), factory.CustomResource.Spec.Service,
+		createLabels(factory.CustomResource))
 
-func (factory *ServiceFactoryForFrontend) GetNamespaceName() types.NamespacedName {
-	return types.NamespacedName{
-		Namespace: factory.GetNamespace(),
-		Name:      factory.GetName(),
-	}
-}
+	factory.setOwner(k8sService)
 
-func (factory *ServiceFactoryForFrontend) NewDefault() client.Object {
-	return &corev1.Service{}
+	return k8sService
 }
 
-func (factory *ServiceFactoryForFrontend) New() client.Object {
-	labels := createLabels(factory.CustomResource)
+// createWebConsoleService returns a Service publishing the http port of the selected pods as configured in the spec
+func createWebConsoleService(namespace string, name string, service *itaallinonev1.ServiceSpec, selector map[string]string) *corev1.Service {
+	if service == nil {
+		service = &itaallinonev1.ServiceSpec{}
+	}
+
+	serviceType := service.Type
+	if serviceType == "" {
+		serviceType = corev1.ServiceTypeNodePort
+	}
 
 	k8sService := &corev1.Service{
 		ObjectMeta: metav1.ObjectMeta{
-			Namespace: factory.GetNamespace(),
-			Name:      factory.GetName(),
+			Namespace:   namespace,
+			Name:        name,
+			Annotations: service.Annotations,
 		},
 		Spec: corev1.ServiceSpec{
-			Selector: labels,
+			Selector: selector,
 			Ports: []corev1.ServicePort{
 				{
 					Name:       "http",
@@ -68,12 +74,56 @@ func (factory *ServiceFactoryForFrontend) New() client.Object {
 					TargetPort: intstr.FromInt(80),
 				},
 			},
-			Type: corev1.ServiceTypeNodePort,
+			Type: serviceType,
 		},
 	}
 
-	// Set resource as the owner and controller
-	ctrl.SetControllerReference(factory.CustomResource, k8sService, factory.Reconciler.Scheme)
+	if serviceType != corev1.ServiceTypeClusterIP {
+		k8sService.Spec.Ports[0].NodePort = service.NodePort
+		k8sService.Spec.ExternalTrafficPolicy = service.ExternalTrafficPolicy
+		if k8sService.Spec.ExternalTrafficPolicy == "" {
+			k8sService.Spec.ExternalTrafficPolicy = corev1.ServiceExternalTrafficPolicyTypeCluster
+		}
+	}
+
+	if serviceType == corev1.ServiceTypeLoadBalancer {
+		k8sService.Spec.LoadBalancerIP = service.LoadBalancerIP
+		k8sService.Spec.LoadBalancerSourceRanges = service.LoadBalancerSourceRanges
+	}
 
 	return k8sService
 }
+
+func (factory *ServiceFactoryForFrontend) Merge(k8sResource client.Object, desiredK8sResource client.Object) {
+	mergeService(k8sResource.(*corev1.Service), desiredK8sResource.(*corev1.Service))
+}
+
+func mergeService(k8sService *corev1.Service, desiredK8sService *corev1.Service) {
+	// Keep node ports allocated by the API server unless a specific one is requested
+	ports := append([]corev1.ServicePort{}, desiredK8sService.Spec.Ports...)
+	if desiredK8sService.Spec.Type != corev1.ServiceTypeClusterIP {
+		for i := range ports {
+			if ports[i].NodePort != 0 {
+				continue
+			}
+			for _, port := range k8sService.Spec.Ports {
+				if port.Name == ports[i].Name {
+					ports[i].NodePort = port.NodePort
+				}
+			}
+		}
+	}
+
+	// ClusterIP and the other values assigned by the API server are left untouched.
+	k8sService.Spec.Selector = desiredK8sService.Spec.Selector
+	k8sService.Spec.Ports = ports
+	k8sService.Spec.Type = desiredK8sService.Spec.Type
+	k8sService.Spec.ExternalTrafficPolicy = desiredK8sService.Spec.ExternalTrafficPolicy
+	k8sService.Spec.LoadBalancerIP = desiredK8sService.Spec.LoadBalancerIP
+	k8sService.Spec.LoadBalancerSourceRanges = desiredK8sService.Spec.LoadBalancerSourceRanges
+
+	// The health check port is only allocated for the Local policy
+	if k8sService.Spec.ExternalTrafficPolicy != corev1.ServiceExternalTrafficPolicyTypeLocal {
+		k8sService.Spec.HealthCheckNodePort = 0
+	}
+}
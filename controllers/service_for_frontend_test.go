@@ -0,0 +1,140 @@
+/*
+Copyright 2021 NEC Corporation.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+
+	itaallinonev1 "github.com/exastro-suite/it-automation-operator/api/v1"
+)
+
+func TestCreateWebConsoleService(t *testing.T) {
+	tests := []struct {
+		name                      string
+		service                   *itaallinonev1.ServiceSpec
+		wantType                  corev1.ServiceType
+		wantNodePort              int32
+		wantExternalTrafficPolicy corev1.ServiceExternalTrafficPolicyType
+		wantLoadBalancerIP        string
+	}{
+		{
+			name:                      "NodePort by default",
+			wantType:                  corev1.ServiceTypeNodePort,
+			wantExternalTrafficPolicy: corev1.ServiceExternalTrafficPolicyTypeCluster,
+		},
+		{
+			name:                      "requested node port",
+			service:                   &itaallinonev1.ServiceSpec{NodePort: 30080, ExternalTrafficPolicy: corev1.ServiceExternalTrafficPolicyTypeLocal},
+			wantType:                  corev1.ServiceTypeNodePort,
+			wantNodePort:              30080,
+			wantExternalTrafficPolicy: corev1.ServiceExternalTrafficPolicyTypeLocal,
+		},
+		{
+			name:     "ClusterIP ignores the external options",
+			service:  &itaallinonev1.ServiceSpec{Type: corev1.ServiceTypeClusterIP, LoadBalancerIP: "192.0.2.1"},
+			wantType: corev1.ServiceTypeClusterIP,
+		},
+		{
+			name:                      "LoadBalancer",
+			service:                   &itaallinonev1.ServiceSpec{Type: corev1.ServiceTypeLoadBalancer, LoadBalancerIP: "192.0.2.1"},
+			wantType:                  corev1.ServiceTypeLoadBalancer,
+			wantExternalTrafficPolicy: corev1.ServiceExternalTrafficPolicyTypeCluster,
+			wantLoadBalancerIP:        "192.0.2.1",
+		},
+		{
+			name:                      "NodePort ignores the load balancer IP",
+			service:                   &itaallinonev1.ServiceSpec{Type: corev1.ServiceTypeNodePort, LoadBalancerIP: "192.0.2.1"},
+			wantType:                  corev1.ServiceTypeNodePort,
+			wantExternalTrafficPolicy: corev1.ServiceExternalTrafficPolicyTypeCluster,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k8sService := createWebConsoleService("default", "ita-frontend", tt.service, map[string]string{"app": "ita"})
+
+			if k8sService.Spec.Type != tt.wantType {
+				t.Errorf("type = %s, want %s", k8sService.Spec.Type, tt.wantType)
+			}
+			if k8sService.Spec.Ports[0].NodePort != tt.wantNodePort {
+				t.Errorf("node port = %d, want %d", k8sService.Spec.Ports[0].NodePort, tt.wantNodePort)
+			}
+			if k8sService.Spec.ExternalTrafficPolicy != tt.wantExternalTrafficPolicy {
+				t.Errorf("external traffic policy = %s, want %s", k8sService.Spec.ExternalTrafficPolicy, tt.wantExternalTrafficPolicy)
+			}
+			if k8sService.Spec.LoadBalancerIP != tt.wantLoadBalancerIP {
+				t.Errorf("load balancer IP = %s, want %s", k8sService.Spec.LoadBalancerIP, tt.wantLoadBalancerIP)
+			}
+		})
+	}
+}
+
+func TestMergeServiceNodePort(t *testing.T) {
+	tests := []struct {
+		name         string
+		current      *itaallinonev1.ServiceSpec
+		allocated    int32
+		desired      *itaallinonev1.ServiceSpec
+		wantNodePort int32
+	}{
+		{
+			name:         "allocated node port is kept",
+			allocated:    31234,
+			wantNodePort: 31234,
+		},
+		{
+			name:         "requested node port replaces the allocated one",
+			allocated:    31234,
+			desired:      &itaallinonev1.ServiceSpec{NodePort: 30080},
+			wantNodePort: 30080,
+		},
+		{
+			name:         "node port is dropped when switching to ClusterIP",
+			allocated:    31234,
+			desired:      &itaallinonev1.ServiceSpec{Type: corev1.ServiceTypeClusterIP},
+			wantNodePort: 0,
+		},
+		{
+			name:         "node port is left to the API server when switching from ClusterIP",
+			current:      &itaallinonev1.ServiceSpec{Type: corev1.ServiceTypeClusterIP},
+			desired:      &itaallinonev1.ServiceSpec{Type: corev1.ServiceTypeLoadBalancer},
+			wantNodePort: 0,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k8sService := createWebConsoleService("default", "ita-frontend", tt.current, nil)
+			k8sService.Spec.ClusterIP = "10.0.0.10"
+			if k8sService.Spec.Type != corev1.ServiceTypeClusterIP {
+				k8sService.Spec.Ports[0].NodePort = tt.allocated
+			}
+			desiredK8sService := createWebConsoleService("default", "ita-frontend", tt.desired, nil)
+
+			mergeService(k8sService, desiredK8sService)
+
+			if k8sService.Spec.Ports[0].NodePort != tt.wantNodePort {
+				t.Errorf("node port = %d, want %d", k8sService.Spec.Ports[0].NodePort, tt.wantNodePort)
+			}
+			if k8sService.Spec.ClusterIP != "10.0.0.10" {
+				t.Errorf("cluster IP = %s, want it to be kept", k8sService.Spec.ClusterIP)
+			}
+		})
+	}
+}
@@ -0,0 +1,88 @@
+/*
+Copyright 2021 NEC Corporation.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	itaallinonev1 "github.com/exastro-suite/it-automation-operator/api/v1"
+)
+
+// VolumeSnapshotFactoryForVolume creates the VolumeSnapshot of a claim of the instance
+// taken by a backup with the VolumeSnapshot method
+type VolumeSnapshotFactoryForVolume struct {
+	k8sResourceFactoryBase
+	Reconciler     *ITAutomationBackupReconciler
+	CustomResource *itaallinonev1.ITAutomationBackup
+	Instance       *itaallinonev1.ITAutomationAllInOne
+	// VolumeName is either fileVolumeName or databaseVolumeName
+	VolumeName string
+}
+
+func newVolumeSnapshotFactoryForVolume(reconciler *ITAutomationBackupReconciler, backup *itaallinonev1.ITAutomationBackup, volumeName string) *VolumeSnapshotFactoryForVolume {
+	return &VolumeSnapshotFactoryForVolume{
+		k8sResourceFactoryBase: newK8sResourceFactoryBase(backup, reconciler.Scheme, backup.Name+"-"+volumeName, newVolumeSnapshot()),
+		Reconciler:             reconciler,
+		CustomResource:         backup,
+		VolumeName:             volumeName,
+	}
+}
+
+func (factory *VolumeSnapshotFactoryForVolume) New() client.Object {
+	claimName := factory.Instance.GetFilePvcName()
+	if factory.VolumeName == databaseVolumeName {
+		claimName = factory.Instance.GetDatabasePvcName()
+	}
+
+	spec := map[string]interface{}{
+		"source": map[string]interface{}{
+			"persistentVolumeClaimName": claimName,
+		},
+	}
+	if className := factory.CustomResource.Spec.VolumeSnapshotClassName; className != nil {
+		spec["volumeSnapshotClassName"] = *className
+	}
+
+	labels := mergeStringMaps(createLabels(factory.Instance), map[string]string{
+		componentLabel: factory.VolumeName,
+		versionLabel:   factory.Instance.Status.Version,
+	})
+
+	k8sVolumeSnapshot := newVolumeSnapshot()
+	k8sVolumeSnapshot.SetNamespace(factory.GetNamespace())
+	k8sVolumeSnapshot.SetName(factory.GetName())
+	k8sVolumeSnapshot.SetLabels(labels)
+	k8sVolumeSnapshot.Object["spec"] = spec
+
+	// Retained snapshots are not owned, so that they outlive the backup
+	if factory.CustomResource.Spec.RetentionPolicy == itaallinonev1.RetentionPolicyDelete {
+		factory.setOwner(k8sVolumeSnapshot)
+	}
+
+	return k8sVolumeSnapshot
+}
+
+// Merge leaves the VolumeSnapshot untouched since its spec is immutable.
+func (factory *VolumeSnapshotFactoryForVolume) Merge(k8sResource client.Object, desiredK8sResource client.Object) {
+}
+
+func newVolumeSnapshot() *unstructured.Unstructured {
+	k8sVolumeSnapshot := &unstructured.Unstructured{}
+	k8sVolumeSnapshot.SetGroupVersionKind(VolumeSnapshotGroupVersionKind)
+	return k8sVolumeSnapshot
+}
@@ -19,6 +19,7 @@ package main
 import (
 	"flag"
 	"os"
+	"strings"
 
 	// Import all Kubernetes client auth plugins (e.g. Azure, GCP, OIDC, etc.)
 	// to ensure that exec-entrypoint and run can make use of them.
@@ -52,11 +53,27 @@ func main() {
 	var metricsAddr string
 	var enableLeaderElection bool
 	var probeAddr string
+	var imageDefaults controllers.ImageDefaults
+	var imageFeatures string
 	flag.StringVar(&metricsAddr, "metrics-bind-address", ":8080", "The address the metric endpoint binds to.")
 	flag.StringVar(&probeAddr, "health-probe-bind-address", ":8081", "The address the probe endpoint binds to.")
 	flag.BoolVar(&enableLeaderElection, "leader-elect", false,
 		"Enable leader election for controller manager. "+
 			"Enabling this will ensure there is only one active controller manager.")
+	flag.StringVar(&imageDefaults.Registry, "default-image-registry",
+		getEnvOrDefault("ITA_DEFAULT_IMAGE_REGISTRY", controllers.DefaultImageRegistry),
+		"The registry the ITA image is pulled from unless overridden by the custom resource.")
+	flag.StringVar(&imageDefaults.Repository, "default-image-repository",
+		getEnvOrDefault("ITA_DEFAULT_IMAGE_REPOSITORY", controllers.DefaultImageRepository),
+		"The repository of the ITA image unless overridden by the custom resource.")
+	flag.StringVar(&imageDefaults.TagTemplate, "default-image-tag-template",
+		getEnvOrDefault("ITA_DEFAULT_IMAGE_TAG_TEMPLATE", controllers.DefaultImageTagTemplate),
+		"The tag of the ITA image unless overridden by the custom resource. "+
+			"{version} and {language} are replaced with the values in the custom resource.")
+	flag.StringVar(&imageFeatures, "default-image-features",
+		getEnvOrDefault("ITA_DEFAULT_IMAGE_FEATURES", ""),
+		"Comma-separated features the default ITA image supports, e.g. Credentials. "+
+			"The published ITA images support none.")
 	opts := zap.Options{
 		Development: true,
 	}
@@ -65,6 +82,12 @@ func main() {
 
 	ctrl.SetLogger(zap.New(zap.UseFlagOptions(&opts)))
 
+	for _, feature := range strings.Split(imageFeatures, ",") {
+		if feature = strings.TrimSpace(feature); feature != "" {
+			itaallinonev1.DefaultImageFeatures = append(itaallinonev1.DefaultImageFeatures, itaallinonev1.ImageFeature(feature))
+		}
+	}
+
 	mgr, err := ctrl.NewManager(ctrl.GetConfigOrDie(), ctrl.Options{
 		Scheme:                 scheme,
 		MetricsBindAddress:     metricsAddr,
@@ -78,14 +101,57 @@ func main() {
 		os.Exit(1)
 	}
 
+	routeAvailable, err := controllers.IsAPIAvailable(mgr.GetConfig(), controllers.RouteGroupVersionKind)
+	if err != nil {
+		setupLog.Error(err, "unable to discover the OpenShift Route API")
+		os.Exit(1)
+	}
+	volumeSnapshotAvailable, err := controllers.IsAPIAvailable(mgr.GetConfig(), controllers.VolumeSnapshotGroupVersionKind)
+	if err != nil {
+		setupLog.Error(err, "unable to discover the VolumeSnapshot API")
+		os.Exit(1)
+	}
+	setupLog.Info("discovered optional APIs", "route", routeAvailable, "volumeSnapshot", volumeSnapshotAvailable)
+
 	if err = (&controllers.ITAutomationAllInOneReconciler{
-		Client: mgr.GetClient(),
-		Log:    ctrl.Log.WithName("controllers").WithName("ITAutomationAllInOne"),
-		Scheme: mgr.GetScheme(),
+		Client:         mgr.GetClient(),
+		Log:            ctrl.Log.WithName("controllers").WithName("ITAutomationAllInOne"),
+		Scheme:         mgr.GetScheme(),
+		ImageDefaults:  imageDefaults,
+		RouteAvailable: routeAvailable,
+		Recorder:       mgr.GetEventRecorderFor("itautomationallinone-controller"),
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "ITAutomationAllInOne")
 		os.Exit(1)
 	}
+	if err = (&controllers.ITAutomationBackupReconciler{
+		Client:                  mgr.GetClient(),
+		Log:                     ctrl.Log.WithName("controllers").WithName("ITAutomationBackup"),
+		Scheme:                  mgr.GetScheme(),
+		ImageDefaults:           imageDefaults,
+		VolumeSnapshotAvailable: volumeSnapshotAvailable,
+		Recorder:                mgr.GetEventRecorderFor("itautomationbackup-controller"),
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "ITAutomationBackup")
+		os.Exit(1)
+	}
+	if err = (&controllers.ITAutomationRestoreReconciler{
+		Client:                  mgr.GetClient(),
+		Log:                     ctrl.Log.WithName("controllers").WithName("ITAutomationRestore"),
+		Scheme:                  mgr.GetScheme(),
+		ImageDefaults:           imageDefaults,
+		VolumeSnapshotAvailable: volumeSnapshotAvailable,
+		Recorder:                mgr.GetEventRecorderFor("itautomationrestore-controller"),
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "ITAutomationRestore")
+		os.Exit(1)
+	}
+	if os.Getenv("ENABLE_WEBHOOKS") != "false" {
+		if err = (&itaallinonev1.ITAutomationAllInOne{}).SetupWebhookWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create webhook", "webhook", "ITAutomationAllInOne")
+			os.Exit(1)
+		}
+	}
 	//+kubebuilder:scaffold:builder
 
 	if err := mgr.AddHealthzCheck("healthz", healthz.Ping); err != nil {
@@ -103,3 +169,10 @@ func main() {
 		os.Exit(1)
 	}
 }
+
+func getEnvOrDefault(key string, defaultValue string) string {
+	if value, found := os.LookupEnv(key); found {
+		return value
+	}
+	return defaultValue
+}